@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultAssessmentWeight   = 1
+	defaultAssessmentMaxScore = 10
+
+	// Nombre de la evaluación que conserva una nota antigua al añadir la
+	// primera evaluación de esa asignatura
+	legacyAssessmentName = "Nota anterior"
+)
+
+// Assessment es una prueba calificada de una asignatura: un examen, un
+// trabajo, los deberes...
+type Assessment struct {
+	Name     string  `bson:"name" json:"name"`
+	Date     string  `bson:"date,omitempty" json:"date,omitempty"`
+	Weight   float64 `bson:"weight" json:"weight"`
+	Score    float64 `bson:"score" json:"score"`
+	MaxScore float64 `bson:"max_score" json:"max_score"`
+	// Curso (2024-2025) y evaluación (1, 2 o 3) a los que pertenece
+	Year string `bson:"year,omitempty" json:"year,omitempty"`
+	Term string `bson:"term,omitempty" json:"term,omitempty"`
+}
+
+// assessmentGrade es la media ponderada de las evaluaciones sobre 10
+func assessmentGrade(assessments []Assessment) float64 {
+	var total, weights float64
+	for _, a := range assessments {
+		total += a.Weight * a.Score / a.MaxScore * 10
+		weights += a.Weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return round2(total / weights)
+}
+
+// subjectRecord es una asignatura con evaluaciones tal como se guarda en
+// MongoDB. La nota se guarda también para las agregaciones.
+type subjectRecord struct {
+	Grade       float64      `bson:"grade"`
+	Assessments []Assessment `bson:"assessments"`
+}
+
+// plainStudent tiene los campos de Student sin sus métodos, para que
+// MarshalBSON y UnmarshalBSON no se llamen a sí mismos
+type plainStudent Student
+
+// MarshalBSON guarda cada asignatura como un número si solo tiene nota o como
+// {grade, assessments} si tiene evaluaciones
+func (st Student) MarshalBSON() ([]byte, error) {
+	plain := plainStudent(st)
+	plain.SchemaVersion = studentSchemaVersion
+	plain.NameWords = nameWords(st.Name)
+	return bson.Marshal(struct {
+		Student  plainStudent `bson:",inline"`
+		Subjects bson.D       `bson:"subjects"`
+	}{plain, st.subjectsBSON()})
+}
+
+func (st Student) subjectsBSON() bson.D {
+	subjects := bson.D{}
+	for _, subject := range sortedSubjects(st.Subjects) {
+		if assessments := st.Assessments[subject]; len(assessments) > 0 {
+			subjects = append(subjects, bson.E{Key: subject, Value: subjectRecord{Grade: st.Subjects[subject], Assessments: assessments}})
+		} else {
+			subjects = append(subjects, bson.E{Key: subject, Value: st.Subjects[subject]})
+		}
+	}
+	return subjects
+}
+
+// UnmarshalBSON lee los dos formatos de asignatura. En las que tienen
+// evaluaciones la nota se calcula a partir de ellas. Los documentos de
+// versiones anteriores se migran al formato actual.
+func (st *Student) UnmarshalBSON(data []byte) error {
+	var doc struct {
+		Student  plainStudent             `bson:",inline"`
+		Subjects map[string]bson.RawValue `bson:"subjects"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	*st = Student(doc.Student)
+	st.Subjects = make(map[string]float64, len(doc.Subjects))
+	for subject, raw := range doc.Subjects {
+		if raw.Type != bson.TypeEmbeddedDocument {
+			var grade float64
+			if err := raw.Unmarshal(&grade); err != nil {
+				return fmt.Errorf("nota de %s: %v", subject, err)
+			}
+			st.Subjects[subject] = grade
+			continue
+		}
+
+		var record subjectRecord
+		if err := raw.Unmarshal(&record); err != nil {
+			return fmt.Errorf("evaluaciones de %s: %v", subject, err)
+		}
+		if st.Assessments == nil {
+			st.Assessments = map[string][]Assessment{}
+		}
+		st.Assessments[subject] = record.Assessments
+		st.Subjects[subject] = assessmentGrade(record.Assessments)
+	}
+	return st.migrate(data)
+}
+
+// subjectGradeExpr es la nota de una asignatura en una agregación, en
+// cualquiera de los dos formatos
+func subjectGradeExpr(path string) bson.M {
+	return bson.M{"$ifNull": bson.A{path + ".grade", path}}
+}
+
+type recordAssessmentArgs struct {
+	studentRefArgs
+	Subject    string  `json:"subject" description:"Nombre de la asignatura" jsonschema:"minLength=1,maxLength=100"`
+	Assessment string  `json:"assessment" description:"Nombre de la evaluación (por ejemplo: Examen tema 3, Proyecto final)" jsonschema:"minLength=1,maxLength=200"`
+	Date       string  `json:"date,omitempty" description:"Fecha de la evaluación (AAAA-MM-DD)" jsonschema:"format=date"`
+	Weight     float64 `json:"weight,omitempty" description:"Peso en la nota de la asignatura (por defecto 1)" jsonschema:"exclusiveMinimum=0,maximum=100"`
+	Score      float64 `json:"score" description:"Puntuación obtenida" jsonschema:"minimum=0"`
+	MaxScore   float64 `json:"max_score,omitempty" description:"Puntuación máxima (por defecto 10)" jsonschema:"exclusiveMinimum=0,maximum=1000"`
+	Term       string  `json:"term,omitempty" description:"Evaluación: 1, 2 o 3. Por defecto la que corresponde a la fecha" jsonschema:"enum=1|2|3"`
+	Year       string  `json:"year,omitempty" description:"Curso (por ejemplo 2024-2025). Por defecto el de la fecha" jsonschema:"pattern=^[0-9]{4}-[0-9]{4}$"`
+	Reason     string  `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+// assessment construye la evaluación aplicando los valores por defecto
+func (args recordAssessmentArgs) assessment(now time.Time) (Assessment, error) {
+	period, err := assessmentPeriod(args.Year, args.Term, args.Date, now)
+	if err != nil {
+		return Assessment{}, err
+	}
+	a := Assessment{Name: args.Assessment, Date: args.Date, Weight: args.Weight, Score: args.Score, MaxScore: args.MaxScore, Year: period.Year, Term: period.Term}
+	if a.Weight == 0 {
+		a.Weight = defaultAssessmentWeight
+	}
+	if a.MaxScore == 0 {
+		a.MaxScore = defaultAssessmentMaxScore
+	}
+	if a.Score > a.MaxScore {
+		return Assessment{}, &InvalidParamsError{
+			Message: fmt.Sprintf("argumento '/score': %v es mayor que la puntuación máxima (%v)", a.Score, a.MaxScore),
+			Pointer: "/score",
+		}
+	}
+	return a, nil
+}
+
+type listAssessmentsArgs struct {
+	studentRefArgs
+	Subject string `json:"subject,omitempty" description:"Limita el resultado a una asignatura" jsonschema:"maxLength=100"`
+}
+
+// recordAssessment añade una evaluación y recalcula la nota de la asignatura.
+// Si la asignatura solo tenía una nota, esa nota se conserva como una
+// evaluación más para no perderla.
+func (s *Server) recordAssessment(ctx context.Context, req *ToolRequest, ref studentRef, subject string, assessment Assessment) (interface{}, error) {
+	if err := req.Role.checkGradesWrite(map[string]float64{subject: 0}); err != nil {
+		return nil, err
+	}
+	if err := s.checkTermOpen(ctx, Period{Year: assessment.Year, Term: assessment.Term}); err != nil {
+		return nil, err
+	}
+
+	found, err := s.findStudent(ctx, req.Session, req.Role, ref)
+	if err != nil {
+		return nil, err
+	}
+	var before Student
+	if err := s.collection.FindOne(ctx, bson.M{"_id": found.ID}).Decode(&before); err != nil {
+		return nil, err
+	}
+
+	field := "subjects." + subject
+	filter := bson.M{"_id": before.ID}
+	var assessments []Assessment
+	if existing := before.Assessments[subject]; len(existing) > 0 {
+		filter[field+".assessments"] = existing
+		assessments = append(assessments, existing...)
+	} else if grade, ok := before.Subjects[subject]; ok {
+		filter[field] = grade
+		// La nota anterior sigue en la evaluación en la que se puso
+		legacy := before.gradePeriod(subject, time.Now())
+		assessments = append(assessments, Assessment{Name: legacyAssessmentName, Weight: defaultAssessmentWeight, Score: grade, MaxScore: defaultAssessmentMaxScore, Year: legacy.Year, Term: legacy.Term})
+	} else {
+		filter[field] = bson.M{"$exists": false}
+	}
+	assessments = append(assessments, assessment)
+	grade := assessmentGrade(assessments)
+
+	// Con evaluaciones, el periodo de la nota sin ellas ya no se usa
+	update := bson.M{
+		"$set":   bson.M{field: subjectRecord{Grade: grade, Assessments: assessments}},
+		"$unset": bson.M{"grade_periods." + subject: ""},
+	}
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, fmt.Errorf("las notas de %s en %s han cambiado mientras se actualizaban; vuelve a intentarlo", before.Name, subject)
+	}
+
+	after := before.clone()
+	after.Subjects[subject] = grade
+	after.Assessments[subject] = assessments
+	delete(after.GradePeriods, subject)
+	req.RecordChange(Change{StudentID: before.ID, StudentName: before.Name, Before: &before, After: &after})
+
+	return map[string]interface{}{
+		"message":     "Evaluación registrada",
+		"student":     before.Name,
+		"subject":     subject,
+		"grade":       grade,
+		"assessments": assessments,
+	}, nil
+}
+
+// listAssessments devuelve la nota y las evaluaciones de cada asignatura. Las
+// asignaturas con una nota antigua aparecen sin evaluaciones.
+func (s *Server) listAssessments(ctx context.Context, sess *Session, role Role, ref studentRef, subject string) (interface{}, error) {
+	if subject != "" && !role.canSeeSubject(subject) {
+		return nil, fmt.Errorf("%w: no impartes %s", errForbidden, subject)
+	}
+
+	student, err := s.findStudent(ctx, sess, role, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := map[string]interface{}{}
+	for name, grade := range student.Subjects {
+		if subject != "" && name != subject {
+			continue
+		}
+		assessments := student.Assessments[name]
+		if assessments == nil {
+			assessments = []Assessment{}
+		}
+		subjects[name] = map[string]interface{}{
+			"grade":       grade,
+			"assessments": assessments,
+		}
+	}
+	if subject != "" && len(subjects) == 0 {
+		return nil, fmt.Errorf("%s no tiene notas de %s", student.Name, subject)
+	}
+
+	return map[string]interface{}{
+		"student":  student.Name,
+		"subjects": subjects,
+	}, nil
+}
+
+// clone copia el estudiante para modificarlo sin tocar el original
+func (st Student) clone() Student {
+	cloned := st
+	cloned.Subjects = make(map[string]float64, len(st.Subjects))
+	for subject, grade := range st.Subjects {
+		cloned.Subjects[subject] = grade
+	}
+	cloned.Assessments = make(map[string][]Assessment, len(st.Assessments))
+	for subject, assessments := range st.Assessments {
+		cloned.Assessments[subject] = append([]Assessment(nil), assessments...)
+	}
+	cloned.TermGrades = make(map[string]map[string]map[string]float64, len(st.TermGrades))
+	for year, terms := range st.TermGrades {
+		cloned.TermGrades[year] = make(map[string]map[string]float64, len(terms))
+		for term, grades := range terms {
+			cloned.TermGrades[year][term] = make(map[string]float64, len(grades))
+			for subject, grade := range grades {
+				cloned.TermGrades[year][term][subject] = grade
+			}
+		}
+	}
+	cloned.GradePeriods = make(map[string]Period, len(st.GradePeriods))
+	for subject, period := range st.GradePeriods {
+		cloned.GradePeriods[subject] = period
+	}
+	cloned.Profile = st.Profile.clone()
+	return cloned
+}
+
+// sameAssessments compara las evaluaciones de dos estados de un estudiante
+func sameAssessments(a, b map[string][]Assessment) bool {
+	for subject, list := range a {
+		if len(list) > 0 && !reflect.DeepEqual(list, b[subject]) {
+			return false
+		}
+	}
+	for subject, list := range b {
+		if len(list) > 0 && !reflect.DeepEqual(list, a[subject]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAssessmentGrade(t *testing.T) {
+	assessments := []Assessment{
+		{Name: "Examen", Weight: 3, Score: 16, MaxScore: 20},
+		{Name: "Deberes", Weight: 1, Score: 6, MaxScore: 10},
+	}
+	if grade := assessmentGrade(assessments); grade != 7.5 {
+		t.Errorf("Media ponderada incorrecta: %v", grade)
+	}
+	if grade := assessmentGrade(nil); grade != 0 {
+		t.Errorf("Sin evaluaciones la nota es 0: %v", grade)
+	}
+}
+
+func TestStudentBSONLegacyGrades(t *testing.T) {
+	// Documentos antiguos: notas como double o como entero
+	data, _ := bson.Marshal(bson.M{
+		"_id":  primitive.NewObjectID(),
+		"name": "Ana",
+		"subjects": bson.M{
+			"matematicas": 8.5,
+			"historia":    int32(7),
+			"fisica": bson.M{"grade": 0.0, "assessments": bson.A{
+				bson.M{"name": "Examen", "weight": 1.0, "score": 9.0, "max_score": 10.0},
+			}},
+		},
+	})
+
+	var student Student
+	if err := bson.Unmarshal(data, &student); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"matematicas": 8.5, "historia": 7, "fisica": 9}
+	if student.Name != "Ana" || !reflect.DeepEqual(student.Subjects, expected) {
+		t.Errorf("Notas mal leídas: %v", student.Subjects)
+	}
+	if len(student.Assessments) != 1 || student.Assessments["fisica"][0].Name != "Examen" {
+		t.Errorf("Evaluaciones mal leídas: %v", student.Assessments)
+	}
+}
+
+func TestStudentBSONRoundTrip(t *testing.T) {
+	student := Student{
+		ID:       primitive.NewObjectID(),
+		Name:     "Ana",
+		Subjects: map[string]float64{"matematicas": 8.5, "fisica": 7},
+		Assessments: map[string][]Assessment{
+			"fisica": {{Name: "Examen", Date: "2025-03-01", Weight: 1, Score: 14, MaxScore: 20}},
+		},
+	}
+	data, err := bson.Marshal(student)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Las asignaturas sin evaluaciones se guardan como número, como antes
+	var raw bson.M
+	bson.Unmarshal(data, &raw)
+	subjects := raw["subjects"].(bson.M)
+	if subjects["matematicas"] != 8.5 {
+		t.Errorf("Nota simple mal guardada: %v", subjects["matematicas"])
+	}
+	if fisica := subjects["fisica"].(bson.M); fisica["grade"] != 7.0 {
+		t.Errorf("La nota calculada se guarda para las agregaciones: %v", fisica)
+	}
+
+	var decoded Student
+	if err := bson.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !sameStudent(&student, &decoded) || decoded.ID != student.ID || decoded.Name != "Ana" {
+		t.Errorf("Ida y vuelta incorrecta: %+v", decoded)
+	}
+}
+
+func TestRecordAssessmentArgs(t *testing.T) {
+	now := time.Date(2025, 2, 10, 0, 0, 0, 0, time.UTC)
+	a, err := recordAssessmentArgs{Assessment: "Examen", Score: 7}.assessment(now)
+	if err != nil || a.Weight != defaultAssessmentWeight || a.MaxScore != defaultAssessmentMaxScore {
+		t.Errorf("Valores por defecto incorrectos: %+v %v", a, err)
+	}
+
+	var invalidParams *InvalidParamsError
+	if _, err := (recordAssessmentArgs{Assessment: "Examen", Score: 12}).assessment(now); !errors.As(err, &invalidParams) || invalidParams.Pointer != "/score" {
+		t.Errorf("Se esperaba un error en /score: %v", err)
+	}
+}
+
+func TestTeacherSeesOnlyOwnAssessments(t *testing.T) {
+	ana := Student{
+		Name:     "Ana",
+		Subjects: map[string]float64{"matematicas": 8, "historia": 6},
+		Assessments: map[string][]Assessment{
+			"matematicas": {{Name: "Examen", Weight: 1, Score: 8, MaxScore: 10}},
+			"historia":    {{Name: "Trabajo", Weight: 1, Score: 6, MaxScore: 10}},
+		},
+	}
+	got, ok := testRoles["profesora.garcia"].redact(ana)
+	if !ok || len(got.Assessments) != 1 || got.Assessments["matematicas"] == nil {
+		t.Errorf("El profesor solo ve las evaluaciones de sus asignaturas: %v", got.Assessments)
+	}
+}
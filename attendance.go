@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Estados de asistencia a una clase
+const (
+	attendancePresent = "present"
+	attendanceAbsent  = "absent"
+	attendanceLate    = "late"
+
+	defaultAttendanceCollection = "attendance"
+
+	// Faltas a partir de las que flag_absences señala a un estudiante
+	defaultAbsenceThreshold = 5
+)
+
+// AttendanceRecord es la asistencia de un estudiante a una asignatura en un
+// día. Se enlaza con el estudiante por su id; hay un registro por estudiante,
+// día y asignatura.
+type AttendanceRecord struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	StudentID  primitive.ObjectID `bson:"student_id" json:"student_id"`
+	Date       string             `bson:"date" json:"date"`
+	Subject    string             `bson:"subject" json:"subject"`
+	Status     string             `bson:"status" json:"status"`
+	Note       string             `bson:"note,omitempty" json:"note,omitempty"`
+	RecordedBy string             `bson:"recorded_by,omitempty" json:"recorded_by,omitempty"`
+	RecordedAt time.Time          `bson:"recorded_at" json:"recorded_at"`
+}
+
+type recordAttendanceArgs struct {
+	studentRefArgs
+	Subject string `json:"subject" description:"Asignatura" jsonschema:"minLength=1,maxLength=100"`
+	Date    string `json:"date,omitempty" description:"Día de la clase (AAAA-MM-DD); por defecto hoy" jsonschema:"format=date"`
+	Status  string `json:"status" description:"present (asiste), absent (falta) o late (llega tarde)" jsonschema:"enum=present|absent|late"`
+	Note    string `json:"note,omitempty" description:"Observación, por ejemplo si la falta está justificada" jsonschema:"maxLength=500"`
+	Reason  string `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+type absencesArgs struct {
+	Name    string `json:"name,omitempty" description:"Nombre del estudiante; sin él ni id, todos los visibles" jsonschema:"maxLength=200"`
+	ID      string `json:"id,omitempty" description:"Id del estudiante, en lugar del nombre" jsonschema:"pattern=^[0-9a-f]{24}$"`
+	Group   string `json:"group,omitempty" description:"Limita el resultado a un grupo" jsonschema:"maxLength=100"`
+	Subject string `json:"subject,omitempty" description:"Limita el resultado a una asignatura" jsonschema:"maxLength=100"`
+	From    string `json:"from,omitempty" description:"Primer día del periodo (AAAA-MM-DD)" jsonschema:"format=date"`
+	To      string `json:"to,omitempty" description:"Último día del periodo (AAAA-MM-DD)" jsonschema:"format=date"`
+}
+
+type flagAbsencesArgs struct {
+	Threshold   int    `json:"threshold,omitempty" description:"Número de faltas a partir del que se señala a un estudiante (por defecto 5)" jsonschema:"minimum=1,maximum=1000"`
+	IncludeLate bool   `json:"include_late,omitempty" description:"Contar también los retrasos como faltas"`
+	Group       string `json:"group,omitempty" description:"Limita el resultado a un grupo" jsonschema:"maxLength=100"`
+	Subject     string `json:"subject,omitempty" description:"Cuenta solo las faltas de una asignatura" jsonschema:"maxLength=100"`
+	From        string `json:"from,omitempty" description:"Primer día del periodo (AAAA-MM-DD)" jsonschema:"format=date"`
+	To          string `json:"to,omitempty" description:"Último día del periodo (AAAA-MM-DD)" jsonschema:"format=date"`
+}
+
+// attendanceDate es el día de un registro: el indicado o hoy. No se registra
+// la asistencia de días que aún no han llegado.
+func attendanceDate(date string, now time.Time) (string, error) {
+	today := now.Format("2006-01-02")
+	if date == "" {
+		return today, nil
+	}
+	// El esquema ya comprueba el formato AAAA-MM-DD, que se ordena como texto
+	if date > today {
+		return "", &InvalidParamsError{Message: fmt.Sprintf("argumento '/date': %s es posterior a hoy", date), Pointer: "/date"}
+	}
+	return date, nil
+}
+
+// checkDateRange comprueba que el periodo no está invertido
+func checkDateRange(from, to string) error {
+	if from != "" && to != "" && from > to {
+		return &InvalidParamsError{Message: fmt.Sprintf("argumento '/to': %s es anterior a %s", to, from), Pointer: "/to"}
+	}
+	return nil
+}
+
+// ensureAttendanceIndexes crea el índice que garantiza un registro por
+// estudiante, día y asignatura
+func ensureAttendanceIndexes(ctx context.Context, attendance *mongo.Collection) error {
+	_, err := attendance.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "student_id", Value: 1}, {Key: "date", Value: 1}, {Key: "subject", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// recordAttendance registra la asistencia de un estudiante a una clase. Si ya
+// había un registro de ese día y asignatura, se corrige.
+func (s *Server) recordAttendance(ctx context.Context, req *ToolRequest, ref studentRef, subject, date, status, note string) (interface{}, error) {
+	// Un profesor solo pasa lista en sus asignaturas
+	if err := req.Role.checkAttendanceWrite(subject); err != nil {
+		return nil, err
+	}
+
+	student, err := s.findStudent(ctx, req.Session, req.Role, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, _ := req.Session.Identity()
+	record := AttendanceRecord{
+		ID:         primitive.NewObjectID(),
+		StudentID:  student.ID,
+		Date:       date,
+		Subject:    subject,
+		Status:     status,
+		Note:       note,
+		RecordedBy: identity.Subject,
+		RecordedAt: time.Now().UTC(),
+	}
+	key := bson.M{"student_id": student.ID, "date": date, "subject": subject}
+	update := bson.M{
+		"$set": bson.M{
+			"status":      record.Status,
+			"note":        record.Note,
+			"recorded_by": record.RecordedBy,
+			"recorded_at": record.RecordedAt,
+		},
+		"$setOnInsert": bson.M{"_id": record.ID},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var previous AttendanceRecord
+	err = s.attendance.FindOneAndUpdate(ctx, key, update, opts).Decode(&previous)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		req.RecordChange(Change{StudentID: student.ID, StudentName: student.Name, Collection: s.attendance.Name(), RecordAfter: record})
+		return map[string]interface{}{
+			"message": "Asistencia registrada",
+			"student": student.Name,
+			"subject": subject,
+			"date":    date,
+			"status":  status,
+		}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	// La corrección queda en la auditoría con el registro anterior
+	record.ID = previous.ID
+	req.RecordChange(Change{StudentID: student.ID, StudentName: student.Name, Collection: s.attendance.Name(), RecordBefore: previous, RecordAfter: record})
+	return map[string]interface{}{
+		"message":         "Asistencia corregida",
+		"student":         student.Name,
+		"subject":         subject,
+		"date":            date,
+		"status":          status,
+		"previous_status": previous.Status,
+	}, nil
+}
+
+// visibleStudents devuelve los nombres de los estudiantes que el rol puede
+// ver, de un grupo o de todos
+func (s *Server) visibleStudents(ctx context.Context, role Role, group string) (map[primitive.ObjectID]string, error) {
+	filter, err := s.groupFilter(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := s.collection.Find(ctx, role.restrict(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	students := map[primitive.ObjectID]string{}
+	for cursor.Next(ctx) {
+		var student Student
+		if err := cursor.Decode(&student); err != nil {
+			return nil, err
+		}
+		if student, visible := role.redact(student); visible {
+			students[student.ID] = student.Name
+		}
+	}
+	return students, cursor.Err()
+}
+
+// absenceRecords devuelve las faltas y retrasos de unos estudiantes en un
+// periodo. Un profesor solo ve los de sus asignaturas.
+func (s *Server) absenceRecords(ctx context.Context, role Role, students map[primitive.ObjectID]string, subject, from, to string) ([]AttendanceRecord, error) {
+	ids := make([]primitive.ObjectID, 0, len(students))
+	for id := range students {
+		ids = append(ids, id)
+	}
+
+	filter := bson.M{
+		"student_id": bson.M{"$in": ids},
+		"status":     bson.M{"$in": bson.A{attendanceAbsent, attendanceLate}},
+	}
+	switch {
+	case subject != "":
+		filter["subject"] = subject
+	case role.Name == roleTeacher:
+		taught := role.Subjects
+		if taught == nil {
+			taught = []string{}
+		}
+		filter["subject"] = bson.M{"$in": taught}
+	}
+	dates := bson.M{}
+	if from != "" {
+		dates["$gte"] = from
+	}
+	if to != "" {
+		dates["$lte"] = to
+	}
+	if len(dates) > 0 {
+		filter["date"] = dates
+	}
+
+	cursor, err := s.attendance.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "subject", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []AttendanceRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// AbsenceSummary son las faltas y retrasos de un estudiante en un periodo
+type AbsenceSummary struct {
+	StudentID primitive.ObjectID `json:"student_id"`
+	Student   string             `json:"student"`
+	Absences  int                `json:"absences"`
+	Late      int                `json:"late"`
+	// Faltas por asignatura
+	BySubject map[string]int     `json:"by_subject"`
+	Records   []AttendanceRecord `json:"records,omitempty"`
+}
+
+// summarizeAbsences agrupa los registros por estudiante, de más a menos
+// faltas
+func summarizeAbsences(records []AttendanceRecord, students map[primitive.ObjectID]string) []AbsenceSummary {
+	byStudent := map[primitive.ObjectID]*AbsenceSummary{}
+	for _, record := range records {
+		summary, ok := byStudent[record.StudentID]
+		if !ok {
+			summary = &AbsenceSummary{StudentID: record.StudentID, Student: students[record.StudentID], BySubject: map[string]int{}}
+			byStudent[record.StudentID] = summary
+		}
+		switch record.Status {
+		case attendanceAbsent:
+			summary.Absences++
+			summary.BySubject[record.Subject]++
+		case attendanceLate:
+			summary.Late++
+		}
+		summary.Records = append(summary.Records, record)
+	}
+
+	summaries := make([]AbsenceSummary, 0, len(byStudent))
+	for _, summary := range byStudent {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Absences != summaries[j].Absences {
+			return summaries[i].Absences > summaries[j].Absences
+		}
+		return summaries[i].Student < summaries[j].Student
+	})
+	return summaries
+}
+
+// flaggedStudents son los estudiantes con al menos threshold faltas, contando
+// los retrasos si includeLate
+func flaggedStudents(summaries []AbsenceSummary, threshold int, includeLate bool) []map[string]interface{} {
+	flagged := []map[string]interface{}{}
+	for _, summary := range summaries {
+		count := summary.Absences
+		if includeLate {
+			count += summary.Late
+		}
+		if count < threshold {
+			continue
+		}
+		flagged = append(flagged, map[string]interface{}{
+			"student_id": summary.StudentID,
+			"student":    summary.Student,
+			"absences":   summary.Absences,
+			"late":       summary.Late,
+			"count":      count,
+			"by_subject": summary.BySubject,
+		})
+	}
+	sort.SliceStable(flagged, func(i, j int) bool {
+		return flagged[i]["count"].(int) > flagged[j]["count"].(int)
+	})
+	return flagged
+}
+
+// attendanceScope devuelve los estudiantes de una consulta de asistencia: uno
+// por nombre o id, o los visibles del grupo
+func (s *Server) attendanceScope(ctx context.Context, sess *Session, role Role, ref studentRef, group string) (map[primitive.ObjectID]string, error) {
+	if ref.isZero() {
+		return s.visibleStudents(ctx, role, group)
+	}
+	if group != "" {
+		return nil, &InvalidParamsError{Message: "argumento '/group': indica un estudiante o un grupo, no los dos", Pointer: "/group"}
+	}
+	student, err := s.findStudent(ctx, sess, role, ref)
+	if err != nil {
+		return nil, err
+	}
+	return map[primitive.ObjectID]string{student.ID: student.Name}, nil
+}
+
+// getAbsences devuelve las faltas y retrasos de un estudiante, de un grupo o
+// de todos los visibles en un periodo
+func (s *Server) getAbsences(ctx context.Context, sess *Session, role Role, args absencesArgs) (interface{}, error) {
+	if args.Subject != "" && !role.canSeeSubject(args.Subject) {
+		return nil, fmt.Errorf("%w: no impartes %s", errForbidden, args.Subject)
+	}
+	if err := checkDateRange(args.From, args.To); err != nil {
+		return nil, err
+	}
+
+	students, err := s.attendanceScope(ctx, sess, role, studentRef{ID: args.ID, Name: args.Name}, args.Group)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.absenceRecords(ctx, role, students, args.Subject, args.From, args.To)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"from":     args.From,
+		"to":       args.To,
+		"students": summarizeAbsences(records, students),
+	}, nil
+}
+
+// flagAbsences señala a los estudiantes que superan un número de faltas
+func (s *Server) flagAbsences(ctx context.Context, role Role, args flagAbsencesArgs) (interface{}, error) {
+	if args.Subject != "" && !role.canSeeSubject(args.Subject) {
+		return nil, fmt.Errorf("%w: no impartes %s", errForbidden, args.Subject)
+	}
+	if err := checkDateRange(args.From, args.To); err != nil {
+		return nil, err
+	}
+	threshold := args.Threshold
+	if threshold == 0 {
+		threshold = defaultAbsenceThreshold
+	}
+
+	students, err := s.visibleStudents(ctx, role, args.Group)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.absenceRecords(ctx, role, students, args.Subject, args.From, args.To)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"threshold":    threshold,
+		"include_late": args.IncludeLate,
+		"from":         args.From,
+		"to":           args.To,
+		"students":     flaggedStudents(summarizeAbsences(records, students), threshold, args.IncludeLate),
+	}, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAttendanceDate(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	if date, err := attendanceDate("", now); err != nil || date != "2025-03-10" {
+		t.Errorf("Sin fecha es hoy: %q %v", date, err)
+	}
+	if date, err := attendanceDate("2025-03-07", now); err != nil || date != "2025-03-07" {
+		t.Errorf("Un día pasado es válido: %q %v", date, err)
+	}
+
+	_, err := attendanceDate("2025-03-11", now)
+	var invalid *InvalidParamsError
+	if !errors.As(err, &invalid) || invalid.Pointer != "/date" {
+		t.Errorf("Un día futuro es un argumento inválido: %v", err)
+	}
+}
+
+func TestCheckDateRange(t *testing.T) {
+	for _, valid := range [][2]string{{"", ""}, {"2025-01-01", ""}, {"", "2025-01-01"}, {"2025-01-01", "2025-01-01"}} {
+		if err := checkDateRange(valid[0], valid[1]); err != nil {
+			t.Errorf("%v: %v", valid, err)
+		}
+	}
+
+	var invalid *InvalidParamsError
+	if err := checkDateRange("2025-02-01", "2025-01-31"); !errors.As(err, &invalid) || invalid.Pointer != "/to" {
+		t.Errorf("Un periodo invertido es un argumento inválido: %v", err)
+	}
+}
+
+func TestSummarizeAbsences(t *testing.T) {
+	ana, luis := primitive.NewObjectID(), primitive.NewObjectID()
+	students := map[primitive.ObjectID]string{ana: "Ana", luis: "Luis"}
+	records := []AttendanceRecord{
+		{StudentID: ana, Date: "2025-03-03", Subject: "matematicas", Status: attendanceAbsent},
+		{StudentID: luis, Date: "2025-03-03", Subject: "historia", Status: attendanceLate},
+		{StudentID: ana, Date: "2025-03-04", Subject: "historia", Status: attendanceLate},
+		{StudentID: luis, Date: "2025-03-05", Subject: "matematicas", Status: attendanceAbsent},
+		{StudentID: ana, Date: "2025-03-05", Subject: "matematicas", Status: attendanceAbsent},
+	}
+
+	summaries := summarizeAbsences(records, students)
+	if len(summaries) != 2 {
+		t.Fatalf("Se esperaba un resumen por estudiante: %+v", summaries)
+	}
+	first := summaries[0]
+	if first.Student != "Ana" || first.Absences != 2 || first.Late != 1 || first.BySubject["matematicas"] != 2 || len(first.Records) != 3 {
+		t.Errorf("Resumen de Ana incorrecto: %+v", first)
+	}
+	if second := summaries[1]; second.Student != "Luis" || second.Absences != 1 || second.Late != 1 {
+		t.Errorf("Resumen de Luis incorrecto: %+v", second)
+	}
+
+	if flagged := flaggedStudents(summaries, 2, false); len(flagged) != 1 || flagged[0]["student"] != "Ana" {
+		t.Errorf("Solo Ana llega a 2 faltas: %v", flagged)
+	}
+	flagged := flaggedStudents(summaries, 2, true)
+	if len(flagged) != 2 || flagged[0]["count"] != 3 || flagged[1]["count"] != 2 {
+		t.Errorf("Contando los retrasos llegan los dos: %v", flagged)
+	}
+	if flagged := flaggedStudents(nil, 1, false); flagged == nil || len(flagged) != 0 {
+		t.Errorf("Sin registros no se señala a nadie: %v", flagged)
+	}
+}
+
+func TestRecordAttendanceChecksSubject(t *testing.T) {
+	req := &ToolRequest{Session: sessionFor("profesora.garcia", authMTLS), Role: testRoles["profesora.garcia"]}
+	_, err := (&Server{}).recordAttendance(context.Background(), req, studentRef{Name: "Ana"}, "historia", "2025-03-10", attendanceAbsent, "")
+	if !errors.Is(err, errForbidden) {
+		t.Errorf("Un profesor solo pasa lista en sus asignaturas: %v", err)
+	}
+}
+
+func TestRecordAttendanceDeniedToReaders(t *testing.T) {
+	req := &ToolRequest{Session: sessionFor("tutor.ruiz", authMTLS), Role: testRoles["tutor.ruiz"]}
+	_, err := (&Server{}).recordAttendance(context.Background(), req, studentRef{Name: "Ana"}, "matematicas", "2025-03-10", attendanceAbsent, "")
+	if !errors.Is(err, errForbidden) || !strings.Contains(err.Error(), "asistencia") {
+		t.Errorf("Un tutor no pasa lista, y el error lo dice: %v", err)
+	}
+}
+
+func TestAbsencesRejectOtherSubjects(t *testing.T) {
+	s := &Server{}
+	ctx := context.Background()
+	teacher := testRoles["profesora.garcia"]
+	sess := sessionFor("profesora.garcia", authMTLS)
+
+	if _, err := s.getAbsences(ctx, sess, teacher, absencesArgs{Subject: "historia"}); !errors.Is(err, errForbidden) {
+		t.Errorf("Un profesor no consulta las faltas de otras asignaturas: %v", err)
+	}
+	if _, err := s.flagAbsences(ctx, teacher, flagAbsencesArgs{Subject: "historia"}); !errors.Is(err, errForbidden) {
+		t.Errorf("Un profesor no consulta las faltas de otras asignaturas: %v", err)
+	}
+}
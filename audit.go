@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultAuditCollection = "audit_log"
+	defaultAuditLimit      = 50
+)
+
+// Change describe una modificación de un estudiante hecha por una
+// herramienta. Before es nil en una inserción y After en un borrado.
+type Change struct {
+	StudentID   primitive.ObjectID
+	StudentName string
+	Before      *Student
+	After       *Student
+
+	// Cambios de otras colecciones (asistencia, grupos, catálogo): la
+	// colección y su documento antes y después, con las mismas reglas que
+	// Before y After. revert_change no los deshace.
+	Collection   string
+	RecordBefore interface{}
+	RecordAfter  interface{}
+}
+
+// AuditEntry es un registro inmutable de una llamada a una herramienta de
+// escritura: quién, desde dónde, con qué argumentos y qué cambió
+type AuditEntry struct {
+	ID          primitive.ObjectID     `bson:"_id" json:"id"`
+	Time        time.Time              `bson:"time" json:"time"`
+	Tool        string                 `bson:"tool" json:"tool"`
+	Arguments   map[string]interface{} `bson:"arguments" json:"arguments"`
+	Reason      string                 `bson:"reason,omitempty" json:"reason,omitempty"`
+	Caller      string                 `bson:"caller" json:"caller"`
+	AuthMethod  string                 `bson:"auth_method" json:"auth_method"`
+	Session     string                 `bson:"session" json:"session"`
+	Transport   string                 `bson:"transport" json:"transport"`
+	Client      string                 `bson:"client,omitempty" json:"client,omitempty"`
+	StudentID   primitive.ObjectID     `bson:"student_id,omitempty" json:"student_id,omitempty"`
+	StudentName string                 `bson:"student_name,omitempty" json:"student_name,omitempty"`
+	Before      *Student               `bson:"before,omitempty" json:"before,omitempty"`
+	After       *Student               `bson:"after,omitempty" json:"after,omitempty"`
+	// Cambio de un documento que no es un estudiante; ver Change
+	Collection   string      `bson:"collection,omitempty" json:"collection,omitempty"`
+	RecordBefore interface{} `bson:"record_before,omitempty" json:"record_before,omitempty"`
+	RecordAfter  interface{} `bson:"record_after,omitempty" json:"record_after,omitempty"`
+	// Si la llamada falló no hay cambio, pero el intento queda registrado
+	Error string `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// AuditQuery filtra el registro de auditoría. Los campos vacíos no filtran.
+type AuditQuery struct {
+	// Id de una entrada concreta
+	ID primitive.ObjectID
+	// Nombre o id del estudiante
+	Student string
+	Caller  string
+	Since   time.Time
+	Until   time.Time
+	// Solo los cambios de estudiantes: excluye los intentos fallidos, las
+	// llamadas que no cambiaron nada y los cambios de otras colecciones
+	ChangesOnly bool
+	Limit       int
+}
+
+func (q AuditQuery) matches(entry AuditEntry) bool {
+	switch {
+	case !q.ID.IsZero() && entry.ID != q.ID:
+		return false
+	case q.ChangesOnly && (entry.Error != "" || entry.StudentID.IsZero() || entry.Collection != ""):
+		return false
+	case q.Student != "" && entry.StudentName != q.Student && entry.StudentID.Hex() != q.Student:
+		return false
+	case q.Caller != "" && entry.Caller != q.Caller:
+		return false
+	case !q.Since.IsZero() && entry.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && entry.Time.After(q.Until):
+		return false
+	}
+	return true
+}
+
+// AuditLog guarda las entradas de auditoría. Solo se añaden: no hay forma de
+// modificarlas ni borrarlas desde el servidor.
+type AuditLog interface {
+	Record(ctx context.Context, entry AuditEntry) error
+	// Query devuelve las entradas más recientes primero
+	Query(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+}
+
+// mongoAuditLog guarda la auditoría en una colección propia
+type mongoAuditLog struct {
+	collection *mongo.Collection
+}
+
+func (a *mongoAuditLog) Record(ctx context.Context, entry AuditEntry) error {
+	_, err := a.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (a *mongoAuditLog) Query(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	filter := bson.M{}
+	if !q.ID.IsZero() {
+		filter["_id"] = q.ID
+	}
+	if q.ChangesOnly {
+		filter["error"] = bson.M{"$exists": false}
+		filter["student_id"] = bson.M{"$exists": true}
+		filter["collection"] = bson.M{"$exists": false}
+	}
+	if q.Student != "" {
+		or := bson.A{bson.M{"student_name": q.Student}}
+		if id, err := primitive.ObjectIDFromHex(q.Student); err == nil {
+			or = append(or, bson.M{"student_id": id})
+		}
+		filter["$or"] = or
+	}
+	if q.Caller != "" {
+		filter["caller"] = q.Caller
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		timeRange := bson.M{}
+		if !q.Since.IsZero() {
+			timeRange["$gte"] = q.Since
+		}
+		if !q.Until.IsZero() {
+			timeRange["$lte"] = q.Until
+		}
+		filter["time"] = timeRange
+	}
+
+	cursor, err := a.collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}}).
+		SetLimit(int64(q.Limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// fileAuditEntry es una línea del fichero de auditoría. Los documentos del
+// estudiante se guardan en Extended JSON, como en MongoDB: el JSON de Student
+// omite campos que revert_change necesita para restaurarlo (la versión del
+// perfil, las claves del nombre, la evaluación de cada nota...). Las líneas
+// escritas antes llevan el documento en before y after.
+type fileAuditEntry struct {
+	AuditEntry
+	BeforeDocument json.RawMessage `json:"before_document,omitempty"`
+	AfterDocument  json.RawMessage `json:"after_document,omitempty"`
+}
+
+func newFileAuditEntry(entry AuditEntry) (fileAuditEntry, error) {
+	line := fileAuditEntry{AuditEntry: entry}
+	line.Before, line.After = nil, nil
+	for _, doc := range []struct {
+		student *Student
+		raw     *json.RawMessage
+	}{{entry.Before, &line.BeforeDocument}, {entry.After, &line.AfterDocument}} {
+		if doc.student == nil {
+			continue
+		}
+		data, err := bson.MarshalExtJSON(doc.student, true, false)
+		if err != nil {
+			return fileAuditEntry{}, err
+		}
+		*doc.raw = data
+	}
+	return line, nil
+}
+
+func (line fileAuditEntry) entry() (AuditEntry, error) {
+	entry := line.AuditEntry
+	for _, doc := range []struct {
+		raw     json.RawMessage
+		student **Student
+	}{{line.BeforeDocument, &entry.Before}, {line.AfterDocument, &entry.After}} {
+		if len(doc.raw) == 0 {
+			continue
+		}
+		var student Student
+		if err := bson.UnmarshalExtJSON(doc.raw, true, &student); err != nil {
+			return AuditEntry{}, err
+		}
+		*doc.student = &student
+	}
+	return entry, nil
+}
+
+// fileAuditLog guarda la auditoría en un fichero JSONL abierto en modo append
+type fileAuditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func openFileAuditLog(path string) (*fileAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error abriendo el fichero de auditoría: %v", err)
+	}
+	return &fileAuditLog{path: path, file: file}, nil
+}
+
+func (a *fileAuditLog) Record(ctx context.Context, entry AuditEntry) error {
+	line, err := newFileAuditEntry(entry)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *fileAuditLog) Query(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var line fileAuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("fichero de auditoría dañado: %v", err)
+		}
+		entry, err := line.entry()
+		if err != nil {
+			return nil, fmt.Errorf("fichero de auditoría dañado: %v", err)
+		}
+		if q.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	return entries, nil
+}
+
+func (a *fileAuditLog) Close() error {
+	return a.file.Close()
+}
+
+// recordAudit registra una llamada a una herramienta de escritura: una entrada
+// por cada cambio, o una sola si no hubo cambios (por ejemplo, si falló). Un
+// fallo de la auditoría no deshace el cambio, pero queda en el log.
+func (s *Server) recordAudit(ctx context.Context, req *ToolRequest, tool string, arguments map[string]interface{}, callErr error) {
+	if s.audit == nil {
+		return
+	}
+
+	identity, _ := req.Session.Identity()
+	base := AuditEntry{
+		Time:       time.Now().UTC(),
+		Tool:       tool,
+		Arguments:  arguments,
+		Caller:     identity.Subject,
+		AuthMethod: identity.Method,
+		Session:    req.Session.id,
+		Transport:  req.Session.transport,
+		Client:     req.Session.ClientInfo().Name,
+	}
+	base.Reason, _ = arguments["reason"].(string)
+	if callErr != nil {
+		base.Error = callErr.Error()
+	}
+
+	changes := req.changes
+	if len(changes) == 0 {
+		changes = []Change{{}}
+	}
+
+	// La auditoría se escribe aunque el cliente haya cancelado la petición
+	ctx = context.WithoutCancel(ctx)
+	for _, change := range changes {
+		entry := base
+		entry.ID = primitive.NewObjectID()
+		entry.StudentID = change.StudentID
+		entry.StudentName = change.StudentName
+		entry.Before = change.Before
+		entry.After = change.After
+		entry.Collection = change.Collection
+		entry.RecordBefore = change.RecordBefore
+		entry.RecordAfter = change.RecordAfter
+
+		if err := s.audit.Record(ctx, entry); err != nil {
+			req.Session.logger.Error("Error registrando la auditoría", "herramienta", tool, "error", err)
+		}
+	}
+}
+
+type auditLogArgs struct {
+	Student string `json:"student,omitempty" description:"Nombre o id del estudiante"`
+	Caller  string `json:"caller,omitempty" description:"Identidad que hizo los cambios"`
+	Since   string `json:"since,omitempty" description:"Inicio del periodo (RFC 3339, por ejemplo 2025-03-01T00:00:00Z)" jsonschema:"format=date-time"`
+	Until   string `json:"until,omitempty" description:"Fin del periodo (RFC 3339)" jsonschema:"format=date-time"`
+	Limit   int    `json:"limit,omitempty" description:"Número máximo de entradas (por defecto 50)" jsonschema:"minimum=1,maximum=500"`
+}
+
+// query convierte los argumentos; las fechas ya las validó el esquema
+func (args auditLogArgs) query() AuditQuery {
+	q := AuditQuery{Student: args.Student, Caller: args.Caller, Limit: args.Limit}
+	q.Since, _ = time.Parse(time.RFC3339, args.Since)
+	q.Until, _ = time.Parse(time.RFC3339, args.Until)
+	return q
+}
+
+// getAuditLog consulta la auditoría. Salvo los administradores, cada
+// identidad solo ve sus propios cambios, y de los estudiantes solo lo que su
+// rol puede ver.
+func (s *Server) getAuditLog(ctx context.Context, sess *Session, role Role, q AuditQuery) (interface{}, error) {
+	if s.audit == nil {
+		return nil, fmt.Errorf("la auditoría no está activada")
+	}
+
+	if role.Name != roleAdmin {
+		identity, _ := sess.Identity()
+		if q.Caller != "" && q.Caller != identity.Subject {
+			return nil, fmt.Errorf("%w: solo puedes consultar tus propios cambios", errForbidden)
+		}
+		q.Caller = identity.Subject
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultAuditLimit
+	}
+
+	entries, err := s.audit.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i] = role.redactEntry(entries[i])
+	}
+	return map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	}, nil
+}
+
+// redactEntry deja en la entrada lo que el rol puede ver de los estudiantes
+// antes y después del cambio: las escrituras registran el documento completo
+func (role Role) redactEntry(entry AuditEntry) AuditEntry {
+	entry.Before = role.redactChanged(entry.Before)
+	entry.After = role.redactChanged(entry.After)
+	return entry
+}
+
+// redactChanged es redact para un estado registrado; nil si el rol no lo ve
+func (role Role) redactChanged(student *Student) *Student {
+	if student == nil {
+		return nil
+	}
+	redacted, visible := role.redact(*student)
+	if !visible {
+		return nil
+	}
+	return &redacted
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestAuditLog(t *testing.T) *fileAuditLog {
+	t.Helper()
+	audit, err := openFileAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	return audit
+}
+
+func TestRecordAudit(t *testing.T) {
+	audit := newTestAuditLog(t)
+	s := &Server{audit: audit}
+
+	sess := newSession(transportHTTP, "10.0.0.1:5000", io.Discard)
+	sess.setIdentity(Identity{Subject: "profesora.garcia", Method: authOAuth})
+	sess.initialize(InitializeParams{ClientInfo: ClientInfo{Name: "asistente"}})
+
+	ana := &Student{ID: primitive.NewObjectID(), Name: "Ana", Subjects: map[string]float64{"matematicas": 8}}
+	req := &ToolRequest{Session: sess}
+	req.RecordChange(Change{StudentID: ana.ID, StudentName: ana.Name, After: ana})
+	arguments := map[string]interface{}{"name": "Ana", "reason": "matrícula tardía"}
+	s.recordAudit(context.Background(), req, "add_student", arguments, nil)
+
+	// Un intento fallido también queda registrado
+	s.recordAudit(context.Background(), &ToolRequest{Session: sess}, "add_student", map[string]interface{}{"name": "Luis"}, errors.New("sin conexión"))
+
+	entries, err := audit.Query(context.Background(), AuditQuery{})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Se esperaban 2 entradas: %v %v", entries, err)
+	}
+
+	failed, inserted := entries[0], entries[1]
+	if failed.Error != "sin conexión" || failed.After != nil {
+		t.Errorf("Entrada del intento fallido incorrecta: %+v", failed)
+	}
+	if inserted.Caller != "profesora.garcia" || inserted.AuthMethod != authOAuth || inserted.Transport != transportHTTP ||
+		inserted.Session != sess.id || inserted.Client != "asistente" || inserted.Reason != "matrícula tardía" {
+		t.Errorf("Contexto de la llamada incorrecto: %+v", inserted)
+	}
+	if inserted.StudentID != ana.ID || inserted.Before != nil || inserted.After.Subjects["matematicas"] != 8 {
+		t.Errorf("Cambio mal registrado: %+v", inserted)
+	}
+}
+
+func TestFileAuditLogKeepsStoredFields(t *testing.T) {
+	audit := newTestAuditLog(t)
+	ctx := context.Background()
+
+	// Campos que no salen en el JSON del estudiante pero sí en MongoDB
+	before := &Student{ID: testAnaID, Name: "Ana García", Subjects: map[string]float64{"matematicas": 6, "historia": 7.5},
+		Assessments:   map[string][]Assessment{"historia": {{Name: "Examen", Weight: 1, Score: 7.5, MaxScore: 10, Year: "2024-2025", Term: "2"}}},
+		GradePeriods:  map[string]Period{"matematicas": {Year: "2024-2025", Term: "1"}},
+		Profile:       &Profile{Email: "ana@example.com", Version: 3},
+		SchemaVersion: studentSchemaVersion,
+		NameKey:       "ana garcia",
+	}
+	after := before.clone()
+	after.Subjects["matematicas"] = 8
+	after.Profile.Version = 4
+	audit.Record(ctx, AuditEntry{ID: primitive.NewObjectID(), Time: time.Now(), Tool: "set_grade", StudentID: testAnaID, Before: before, After: &after})
+
+	entries, err := audit.Query(ctx, AuditQuery{})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Se esperaba 1 entrada: %v %v", entries, err)
+	}
+	got := entries[0]
+	if got.Before.Profile.Version != 3 || got.After.Profile.Version != 4 || got.After.NameKey != "ana garcia" ||
+		got.After.SchemaVersion != studentSchemaVersion || len(got.After.NameWords) != 2 ||
+		!reflect.DeepEqual(got.After.GradePeriods, before.GradePeriods) || !reflect.DeepEqual(got.After.Assessments, before.Assessments) {
+		t.Errorf("El documento no sobrevive al fichero: %+v", got.After)
+	}
+	if filter := studentStateFilter(got.After); filter["profile.version"] != 4 || filter["subjects.matematicas"] != 8.0 {
+		t.Errorf("Filtro de revert_change incorrecto: %v", filter)
+	}
+
+	// Las líneas escritas con el formato anterior se siguen leyendo
+	legacy := `{"id":"` + primitive.NewObjectID().Hex() + `","time":"2025-03-01T10:00:00Z","tool":"add_student","after":{"id":"` + testLuisID.Hex() + `","name":"Luis","subjects":{"matematicas":5}}}` + "\n"
+	if _, err := audit.file.WriteString(legacy); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = audit.Query(ctx, AuditQuery{Limit: 2})
+	if err != nil || len(entries) != 2 || entries[1].After.ID != testLuisID || entries[1].After.Subjects["matematicas"] != 5 {
+		t.Errorf("Línea antigua mal leída: %+v %v", entries, err)
+	}
+}
+
+func TestAuditQueryFilters(t *testing.T) {
+	audit := newTestAuditLog(t)
+	ctx := context.Background()
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	anaID := primitive.NewObjectID()
+
+	for i, entry := range []AuditEntry{
+		{Caller: "garcia", StudentName: "Ana", StudentID: anaID},
+		{Caller: "ruiz", StudentName: "Luis"},
+		{Caller: "garcia", StudentName: "Luis"},
+	} {
+		entry.ID = primitive.NewObjectID()
+		entry.Time = base.Add(time.Duration(i) * 24 * time.Hour)
+		audit.Record(ctx, entry)
+	}
+
+	cases := []struct {
+		query    AuditQuery
+		expected int
+	}{
+		{AuditQuery{}, 3},
+		{AuditQuery{Student: "Luis"}, 2},
+		{AuditQuery{Student: anaID.Hex()}, 1},
+		{AuditQuery{Caller: "garcia"}, 2},
+		{AuditQuery{Since: base.Add(12 * time.Hour)}, 2},
+		{AuditQuery{Until: base.Add(12 * time.Hour)}, 1},
+		{AuditQuery{Limit: 1}, 1},
+	}
+	for _, c := range cases {
+		entries, err := audit.Query(ctx, c.query)
+		if err != nil || len(entries) != c.expected {
+			t.Errorf("%+v: %d entradas, esperadas %d (%v)", c.query, len(entries), c.expected, err)
+		}
+	}
+
+	entries, _ := audit.Query(ctx, AuditQuery{})
+	if !entries[0].Time.After(entries[1].Time) {
+		t.Error("Las entradas más recientes van primero")
+	}
+}
+
+func TestAuditLogOnlyShowsOwnChanges(t *testing.T) {
+	audit := newTestAuditLog(t)
+	s := &Server{audit: audit}
+	ctx := context.Background()
+	audit.Record(ctx, AuditEntry{ID: primitive.NewObjectID(), Time: time.Now(), Caller: "garcia"})
+	audit.Record(ctx, AuditEntry{ID: primitive.NewObjectID(), Time: time.Now(), Caller: "ruiz"})
+
+	sess := sessionFor("garcia", authMTLS)
+	teacher := Role{Name: roleTeacher, Subjects: []string{"matematicas"}}
+
+	result, err := s.getAuditLog(ctx, sess, teacher, AuditQuery{})
+	if err != nil || result.(map[string]interface{})["count"] != 1 {
+		t.Errorf("El profesor solo ve sus cambios: %v %v", result, err)
+	}
+	if _, err := s.getAuditLog(ctx, sess, teacher, AuditQuery{Caller: "ruiz"}); !errors.Is(err, errForbidden) {
+		t.Errorf("Se esperaba acceso denegado: %v", err)
+	}
+	if result, _ := s.getAuditLog(ctx, sess, adminRole, AuditQuery{}); result.(map[string]interface{})["count"] != 2 {
+		t.Errorf("El administrador ve todo: %v", result)
+	}
+}
+
+func TestAuditLogRedactsStudents(t *testing.T) {
+	audit := newTestAuditLog(t)
+	s := &Server{audit: audit}
+	ctx := context.Background()
+
+	// set_grade registra el documento completo, con otras asignaturas y el perfil
+	before := &Student{ID: testAnaID, Name: "Ana", Subjects: map[string]float64{"matematicas": 6, "historia": 9},
+		Profile: &Profile{Email: "ana@example.com", Notes: "Beca comedor"}}
+	after := before.clone()
+	after.Subjects["matematicas"] = 8
+	audit.Record(ctx, AuditEntry{ID: primitive.NewObjectID(), Time: time.Now(), Caller: "garcia", Tool: "set_grade",
+		StudentID: testAnaID, StudentName: "Ana", Before: before, After: &after})
+
+	sess := sessionFor("garcia", authMTLS)
+	teacher := Role{Name: roleTeacher, Subjects: []string{"matematicas"}}
+	result, err := s.getAuditLog(ctx, sess, teacher, AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := result.(map[string]interface{})["entries"].([]AuditEntry)[0]
+	if !reflect.DeepEqual(entry.After.Subjects, map[string]float64{"matematicas": 8}) || entry.After.Profile != nil || entry.Before.Profile != nil {
+		t.Errorf("El profesor solo ve sus asignaturas y ningún dato personal: %+v %+v", entry.Before, entry.After)
+	}
+
+	changes, err := s.listRecentChanges(ctx, sess, teacher, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := changes.(map[string]interface{})["changes"].([]changeSummary)[0]
+	if summary.Kind != changeUpdate || len(summary.Grades) != 1 || summary.Grades[0].Subject != "matematicas" {
+		t.Errorf("El resumen solo lleva sus asignaturas: %+v", summary)
+	}
+
+	// Fuera de su ámbito no ve el documento
+	tutor := Role{Name: roleTutor, StudentIDs: []primitive.ObjectID{testLuisID}}
+	if redacted := tutor.redactEntry(entry); redacted.Before != nil || redacted.After != nil {
+		t.Errorf("Un tutor no ve a estudiantes ajenos: %+v", redacted)
+	}
+	if full, _ := s.getAuditLog(ctx, sess, adminRole, AuditQuery{}); full.(map[string]interface{})["entries"].([]AuditEntry)[0].After.Profile.Notes != "Beca comedor" {
+		t.Error("El administrador ve el documento completo")
+	}
+}
+
+func TestAuditLogArgs(t *testing.T) {
+	q := auditLogArgs{Student: "Ana", Since: "2025-03-01T00:00:00Z"}.query()
+	if q.Student != "Ana" || !q.Since.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)) || !q.Until.IsZero() {
+		t.Errorf("Consulta mal construida: %+v", q)
+	}
+}
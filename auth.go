@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// Tiempo por defecto para completar el TLS e initialize en una conexión TCP
+const defaultAuthTimeout = 10 * time.Second
+
+// Formas en que se identificó una sesión
+const (
+	authLocal = "local" // stdio: el cliente es quien lanzó el proceso
+	authNone  = "none"  // TCP o HTTP sin autenticación configurada
+	authToken = "token"
+	authMTLS  = "mtls"
+	authOAuth = "oauth"
+)
+
+// Identity es quién está al otro lado de una sesión
+type Identity struct {
+	Subject string
+	Method  string
+	// Scopes del access token; solo para authOAuth
+	Scopes []string
+}
+
+var errUnauthorized = errors.New("no autorizado: token de acceso inválido o ausente")
+
+// AuthConfig controla la autenticación del transporte TCP. Sin token ni TLS
+// cualquiera que alcance el puerto tiene acceso.
+type AuthConfig struct {
+	// Token compartido que el cliente envía en el parámetro authToken de initialize
+	Token string
+	// Si no es nil el listener usa TLS; con ClientCAs exige certificado de cliente
+	TLS *tls.Config
+	// Plazo para completar el TLS e initialize antes de cerrar la conexión
+	Timeout time.Duration
+}
+
+// loadAuthConfig lee TCP_AUTH_TOKEN, TLS_CERT_FILE, TLS_KEY_FILE,
+// TLS_CLIENT_CA_FILE y AUTH_TIMEOUT. A diferencia del resto de la
+// configuración, un error aquí impide arrancar: no queremos abrir el puerto
+// sin la protección que se pidió.
+func loadAuthConfig() (AuthConfig, error) {
+	cfg := AuthConfig{
+		Token:   os.Getenv("TCP_AUTH_TOKEN"),
+		Timeout: durationFromEnv("AUTH_TIMEOUT", defaultAuthTimeout),
+	}
+
+	certFile, keyFile, caFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"), os.Getenv("TLS_CLIENT_CA_FILE")
+	if certFile == "" && keyFile == "" {
+		if caFile != "" {
+			return cfg, fmt.Errorf("TLS_CLIENT_CA_FILE requiere TLS_CERT_FILE y TLS_KEY_FILE")
+		}
+		return cfg, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return cfg, fmt.Errorf("error cargando el certificado TLS: %v", err)
+	}
+	cfg.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return cfg, fmt.Errorf("error leyendo TLS_CLIENT_CA_FILE: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return cfg, fmt.Errorf("TLS_CLIENT_CA_FILE no contiene certificados válidos")
+		}
+		cfg.TLS.ClientCAs = pool
+		cfg.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// required indica si las conexiones TCP deben identificarse
+func (cfg AuthConfig) required() bool {
+	return cfg.Token != "" || (cfg.TLS != nil && cfg.TLS.ClientCAs != nil)
+}
+
+// authenticate identifica la sesión al recibir initialize. En TCP, si hay
+// token configurado, debe coincidir con authToken. La identidad del
+// certificado de cliente o del access token HTTP, si la hay, ya la fijó el
+// transporte.
+func (s *Server) authenticate(sess *Session, params InitializeParams) error {
+	if sess.transport == transportStdio {
+		sess.setIdentity(Identity{Subject: authLocal, Method: authLocal})
+		return nil
+	}
+
+	identity, identified := sess.Identity()
+	if sess.transport == transportTCP && s.auth.Token != "" {
+		if subtle.ConstantTimeCompare([]byte(params.AuthToken), []byte(s.auth.Token)) != 1 {
+			return errUnauthorized
+		}
+		if !identified {
+			identity = Identity{Subject: authToken, Method: authToken}
+		}
+	} else if !identified {
+		identity = Identity{Subject: sess.remoteAddr, Method: authNone}
+	}
+
+	sess.setIdentity(identity)
+	return nil
+}
+
+// acceptTLS completa el handshake TLS y, con mTLS, fija la identidad de la
+// sesión a partir del certificado del cliente
+func (s *Server) acceptTLS(sess *Session, conn *tls.Conn) error {
+	conn.SetDeadline(time.Now().Add(s.auth.Timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		sess.setIdentity(Identity{Subject: certs[0].Subject.CommonName, Method: authMTLS})
+	}
+	return nil
+}
+
+// closeUnauthenticated cierra la conexión si el cliente no completa initialize
+// a tiempo, para que no se puedan mantener conexiones abiertas sin identificarse
+func (s *Server) closeUnauthenticated(sess *Session, conn net.Conn) *time.Timer {
+	return time.AfterFunc(s.auth.Timeout, func() {
+		if !sess.acceptsRequests() {
+			slog.Warn("Autenticación fallida", "remote", sess.remoteAddr, "motivo", "sin initialize en el plazo")
+			conn.Close()
+		}
+	})
+}
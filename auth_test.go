@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenAuthentication(t *testing.T) {
+	s := &Server{auth: AuthConfig{Token: "secreto"}}
+
+	cases := []struct {
+		token string
+		ok    bool
+	}{
+		{"secreto", true},
+		{"otro", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		sess := newSession(transportTCP, "127.0.0.1:5000", io.Discard)
+		response := processTestMessage(t, s, sess, MCPMessage{
+			JsonRPC: "2.0",
+			ID:      1,
+			Method:  "initialize",
+			Params:  map[string]interface{}{"protocolVersion": latestProtocolVersion, "authToken": c.token},
+		})
+
+		if c.ok {
+			identity, _ := sess.Identity()
+			if response.Error != nil || identity.Method != authToken {
+				t.Errorf("%q: debería autenticarse: %+v %+v", c.token, response.Error, identity)
+			}
+			continue
+		}
+		if response.Error == nil || response.Error.Code != -32001 {
+			t.Errorf("%q: se esperaba error -32001: %+v", c.token, response.Error)
+		}
+		if sess.ctx.Err() == nil || sess.acceptsRequests() {
+			t.Errorf("%q: la sesión debería cerrarse sin inicializar", c.token)
+		}
+	}
+}
+
+func TestStdioDoesNotRequireToken(t *testing.T) {
+	s := &Server{auth: AuthConfig{Token: "secreto"}}
+	sess := newSession(transportStdio, "", io.Discard)
+
+	response := processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+	if response.Error != nil {
+		t.Fatalf("stdio no usa token: %+v", response.Error)
+	}
+	if identity, _ := sess.Identity(); identity.Method != authLocal {
+		t.Errorf("Identidad incorrecta: %+v", identity)
+	}
+}
+
+func TestUnauthenticatedConnectionIsClosed(t *testing.T) {
+	s := &Server{auth: AuthConfig{Token: "secreto", Timeout: 50 * time.Millisecond}}
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.handleConnection(server)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("La conexión sin initialize debería cerrarse")
+	}
+}
+
+func TestMutualTLSIdentity(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "CA de pruebas", nil, nil)
+	serverCert, serverKey := newTestCertificate(t, "servidor", ca, caKey)
+	clientCert, clientKey := newTestCertificate(t, "profesora.garcia", ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	s := &Server{auth: AuthConfig{
+		TLS: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+		Timeout: 2 * time.Second,
+	}}
+
+	serverConn, clientConn := net.Pipe()
+	go s.handleConnection(tls.Server(serverConn, s.auth.TLS))
+
+	conn := tls.Client(clientConn, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{clientCert.Raw}, PrivateKey: clientKey}},
+		RootCAs:      pool,
+		ServerName:   "servidor",
+	})
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	request, _ := json.Marshal(MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+	if _, err := conn.Write(append(request, '\n')); err != nil {
+		t.Fatalf("Error escribiendo: %v", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Error leyendo la respuesta: %v", err)
+	}
+	var response MCPMessage
+	json.Unmarshal(line, &response)
+	if response.Error != nil {
+		t.Fatalf("Error inesperado: %+v", response.Error)
+	}
+
+	var sess *Session
+	for _, active := range s.activeSessions() {
+		sess = active
+	}
+	if identity, _ := sess.Identity(); identity.Subject != "profesora.garcia" || identity.Method != authMTLS {
+		t.Errorf("Identidad incorrecta: %+v", identity)
+	}
+}
+
+// newTestCertificate genera un certificado firmado por parent, o autofirmado
+// y de CA si parent es nil
+func newTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
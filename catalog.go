@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultSubjectsCollection = "subjects"
+
+// Subject es una asignatura del catálogo. Code es la clave con la que se
+// guardan sus notas en los estudiantes.
+type Subject struct {
+	Code       string `bson:"_id" json:"code"`
+	Name       string `bson:"name" json:"name"`
+	Department string `bson:"department,omitempty" json:"department,omitempty"`
+	// Identidad del profesor que la imparte, como en ROLES_FILE
+	Teacher string   `bson:"teacher,omitempty" json:"teacher,omitempty"`
+	Credits float64  `bson:"credits,omitempty" json:"credits,omitempty"`
+	Aliases []string `bson:"aliases,omitempty" json:"aliases,omitempty"`
+}
+
+// subjectKey normaliza un nombre de asignatura para compararlo: sin
+// mayúsculas, sin tildes y con los espacios simplificados
+func subjectKey(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.Join(strings.Fields(name), " ")) {
+		switch r {
+		case 'á', 'à', 'ä':
+			r = 'a'
+		case 'é', 'è', 'ë':
+			r = 'e'
+		case 'í', 'ì', 'ï':
+			r = 'i'
+		case 'ó', 'ò', 'ö':
+			r = 'o'
+		case 'ú', 'ù', 'ü':
+			r = 'u'
+		case 'ñ':
+			r = 'n'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// subjectCode convierte un nombre en un código válido como clave de MongoDB:
+// "Lengua Castellana" es "lengua_castellana"
+func subjectCode(name string) string {
+	var b strings.Builder
+	for _, r := range subjectKey(name) {
+		switch {
+		case r == ' ' || r == '-' || r == '_':
+			b.WriteRune('_')
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		}
+	}
+	return strings.Trim(b.String(), "_")
+}
+
+// subjectCatalog resuelve nombres y alias de asignatura a su código
+type subjectCatalog struct {
+	subjects []Subject
+	byKey    map[string]string
+}
+
+func newSubjectCatalog(subjects []Subject) *subjectCatalog {
+	catalog := &subjectCatalog{subjects: subjects, byKey: map[string]string{}}
+	for _, subject := range subjects {
+		for _, name := range subject.names() {
+			catalog.byKey[subjectKey(name)] = subject.Code
+		}
+	}
+	return catalog
+}
+
+// names son todas las formas de referirse a la asignatura
+func (subject Subject) names() []string {
+	return append([]string{subject.Code, subject.Name}, subject.Aliases...)
+}
+
+// resolve devuelve el código de una asignatura del catálogo
+func (c *subjectCatalog) resolve(name string) (string, bool) {
+	code, ok := c.byKey[subjectKey(name)]
+	return code, ok
+}
+
+// canonical devuelve el código con el que se guarda una asignatura. Con el
+// catálogo vacío se acepta cualquier nombre, normalizado; si no, solo las
+// asignaturas del catálogo.
+func (c *subjectCatalog) canonical(name, pointer string) (string, error) {
+	if code, ok := c.resolve(name); ok {
+		return code, nil
+	}
+	if len(c.subjects) == 0 {
+		if code := subjectCode(name); code != "" {
+			return code, nil
+		}
+	}
+
+	codes := catalogCodes(c)
+	message := fmt.Sprintf("argumento '%s': asignatura desconocida '%s'", pointer, name)
+	if len(codes) > 0 {
+		message += "; las del catálogo son: " + strings.Join(codes, ", ")
+	}
+	return "", &InvalidParamsError{Message: message, Pointer: pointer}
+}
+
+// subjectCatalog lee el catálogo; sin colección está vacío
+func (s *Server) subjectCatalog(ctx context.Context) (*subjectCatalog, error) {
+	if s.subjects == nil {
+		return newSubjectCatalog(nil), nil
+	}
+
+	cursor, err := s.subjects.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var subjects []Subject
+	if err := cursor.All(ctx, &subjects); err != nil {
+		return nil, err
+	}
+	return newSubjectCatalog(subjects), nil
+}
+
+// canonicalSubject normaliza la asignatura de una escritura
+func (s *Server) canonicalSubject(ctx context.Context, name, pointer string) (string, error) {
+	catalog, err := s.subjectCatalog(ctx)
+	if err != nil {
+		return "", err
+	}
+	return catalog.canonical(name, pointer)
+}
+
+// canonicalGrades normaliza las asignaturas de un conjunto de notas. Dos
+// nombres de la misma asignatura son un error, no una nota que pisa a otra.
+func (s *Server) canonicalGrades(ctx context.Context, grades map[string]float64) (map[string]float64, error) {
+	catalog, err := s.subjectCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := make(map[string]float64, len(grades))
+	given := map[string]string{}
+	for _, name := range sortedSubjects(grades) {
+		pointer := joinPointer("/subjects", name)
+		code, err := catalog.canonical(name, pointer)
+		if err != nil {
+			return nil, err
+		}
+		if other, dup := given[code]; dup {
+			return nil, &InvalidParamsError{
+				Message: fmt.Sprintf("argumento '%s': '%s' y '%s' son la misma asignatura (%s)", pointer, other, name, code),
+				Pointer: pointer,
+			}
+		}
+		given[code] = name
+		normalized[code] = grades[name]
+	}
+	return normalized, nil
+}
+
+// resolveSubject traduce el nombre de una asignatura en una consulta; si no
+// está en el catálogo se busca tal cual
+func (s *Server) resolveSubject(ctx context.Context, name string) string {
+	catalog, err := s.subjectCatalog(ctx)
+	if err != nil {
+		return name
+	}
+	if code, ok := catalog.resolve(name); ok {
+		return code
+	}
+	return name
+}
+
+// taughtSubjects devuelve las asignaturas del catálogo que imparte un profesor
+func (s *Server) taughtSubjects(ctx context.Context, teacher string) ([]string, error) {
+	if s.subjects == nil {
+		return nil, nil
+	}
+	catalog, err := s.subjectCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	for _, subject := range catalog.subjects {
+		if subject.Teacher == teacher {
+			codes = append(codes, subject.Code)
+		}
+	}
+	return codes, nil
+}
+
+type saveSubjectArgs struct {
+	Code       string   `json:"code" description:"Código de la asignatura, con el que se guardan las notas (por ejemplo lengua_castellana)" jsonschema:"minLength=1,maxLength=100,pattern=^[a-z0-9_]+$"`
+	Name       string   `json:"name" description:"Nombre para mostrar (por ejemplo Lengua Castellana)" jsonschema:"minLength=1,maxLength=200"`
+	Department string   `json:"department,omitempty" description:"Departamento" jsonschema:"maxLength=200"`
+	Teacher    string   `json:"teacher,omitempty" description:"Identidad del profesor que la imparte, como en ROLES_FILE" jsonschema:"maxLength=200"`
+	Credits    float64  `json:"credits,omitempty" description:"Créditos u horas semanales" jsonschema:"minimum=0,maximum=100"`
+	Aliases    []string `json:"aliases,omitempty" description:"Otros nombres con los que se conoce (por ejemplo: mates, Matemáticas)" jsonschema:"maxItems=20" values:"minLength=1,maxLength=100"`
+	Reason     string   `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+// saveSubject crea o actualiza una asignatura del catálogo. Sus nombres y
+// alias no pueden ser los de otra asignatura.
+func (s *Server) saveSubject(ctx context.Context, req *ToolRequest, subject Subject) (interface{}, error) {
+	if req.Role.Name != roleAdmin {
+		return nil, fmt.Errorf("%w: solo un administrador puede modificar el catálogo de asignaturas", errForbidden)
+	}
+
+	catalog, err := s.subjectCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range subject.names() {
+		if code, ok := catalog.resolve(name); ok && code != subject.Code {
+			return nil, fmt.Errorf("'%s' ya identifica a la asignatura '%s'", name, code)
+		}
+	}
+
+	var previous Subject
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.Before)
+	err = s.subjects.FindOneAndReplace(ctx, bson.M{"_id": subject.Code}, subject, opts).Decode(&previous)
+	message := "Asignatura actualizada"
+	switch {
+	case err == mongo.ErrNoDocuments:
+		message = "Asignatura añadida al catálogo"
+		req.RecordChange(Change{Collection: s.subjects.Name(), RecordAfter: subject})
+	case err != nil:
+		return nil, err
+	default:
+		req.RecordChange(Change{Collection: s.subjects.Name(), RecordBefore: previous, RecordAfter: subject})
+	}
+	return map[string]interface{}{
+		"message": message,
+		"subject": subject,
+	}, nil
+}
+
+// subjectCounts cuenta los estudiantes con nota en cada asignatura
+func (s *Server) subjectCounts(ctx context.Context) (map[string]int, error) {
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"subject": bson.M{"$objectToArray": "$subjects"}}}},
+		{{Key: "$unwind", Value: "$subject"}},
+		{{Key: "$group", Value: bson.M{"_id": "$subject.k", "students": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Subject  string `bson:"_id"`
+		Students int    `bson:"students"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.Subject] = row.Students
+	}
+	return counts, nil
+}
+
+// subjectListing combina el catálogo con el número de estudiantes de cada
+// asignatura. Las que aparecen en los datos pero no en el catálogo (notas
+// antiguas o escritas a mano) se devuelven aparte, con la asignatura del
+// catálogo a la que corresponden si la hay.
+func subjectListing(catalog *subjectCatalog, counts map[string]int) map[string]interface{} {
+	subjects := make([]map[string]interface{}, len(catalog.subjects))
+	for i, subject := range catalog.subjects {
+		subjects[i] = map[string]interface{}{
+			"code":       subject.Code,
+			"name":       subject.Name,
+			"department": subject.Department,
+			"teacher":    subject.Teacher,
+			"credits":    subject.Credits,
+			"aliases":    subject.Aliases,
+			"students":   counts[subject.Code],
+		}
+	}
+
+	codes := catalogCodes(catalog)
+	var names []string
+	for name := range counts {
+		if !containsString(codes, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	uncatalogued := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		entry := map[string]interface{}{"name": name, "students": counts[name]}
+		if code, ok := catalog.resolve(name); ok {
+			entry["matches"] = code
+		}
+		uncatalogued = append(uncatalogued, entry)
+	}
+
+	return map[string]interface{}{
+		"subjects":     subjects,
+		"uncatalogued": uncatalogued,
+	}
+}
+
+func catalogCodes(catalog *subjectCatalog) []string {
+	codes := make([]string, len(catalog.subjects))
+	for i, subject := range catalog.subjects {
+		codes[i] = subject.Code
+	}
+	return codes
+}
+
+// listSubjects devuelve el catálogo y cuántos estudiantes tienen nota en
+// cada asignatura
+func (s *Server) listSubjects(ctx context.Context) (interface{}, error) {
+	catalog, err := s.subjectCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.subjectCounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return subjectListing(catalog, counts), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var testCatalog = newSubjectCatalog([]Subject{
+	{Code: "ingles", Name: "Inglés"},
+	{Code: "matematicas", Name: "Matemáticas", Aliases: []string{"mates"}},
+})
+
+func TestSubjectNormalization(t *testing.T) {
+	if key := subjectKey("  Matemáticas   Aplicadas "); key != "matematicas aplicadas" {
+		t.Errorf("Clave incorrecta: %q", key)
+	}
+	for name, expected := range map[string]string{
+		"Lengua Castellana": "lengua_castellana",
+		"Educación Física":  "educacion_fisica",
+		"Inglés (B1)":       "ingles_b1",
+		"???":               "",
+	} {
+		if code := subjectCode(name); code != expected {
+			t.Errorf("%s: código %q, esperado %q", name, code, expected)
+		}
+	}
+}
+
+func TestCanonicalSubject(t *testing.T) {
+	for _, name := range []string{"matematicas", "Matemáticas", "MATES", " mates "} {
+		if code, err := testCatalog.canonical(name, "/subject"); err != nil || code != "matematicas" {
+			t.Errorf("%s: %q %v", name, code, err)
+		}
+	}
+
+	_, err := testCatalog.canonical("fisica", "/subject")
+	var invalid *InvalidParamsError
+	if !errors.As(err, &invalid) || invalid.Pointer != "/subject" {
+		t.Fatalf("Una asignatura fuera del catálogo es un argumento inválido: %v", err)
+	}
+	if invalid.Message != "argumento '/subject': asignatura desconocida 'fisica'; las del catálogo son: ingles, matematicas" {
+		t.Errorf("Mensaje incorrecto: %s", invalid.Message)
+	}
+
+	// Sin catálogo se acepta cualquier asignatura, normalizada
+	empty := newSubjectCatalog(nil)
+	if code, err := empty.canonical("Física", "/subject"); err != nil || code != "fisica" {
+		t.Errorf("Sin catálogo: %q %v", code, err)
+	}
+	if _, err := empty.canonical("???", "/subject"); err == nil {
+		t.Error("Un nombre sin letras ni números no es una asignatura")
+	}
+}
+
+func TestCanonicalGrades(t *testing.T) {
+	s := &Server{}
+	ctx := context.Background()
+
+	grades, err := s.canonicalGrades(ctx, map[string]float64{"Matemáticas": 8, "Historia del Arte": 7})
+	if err != nil || grades["matematicas"] != 8 || grades["historia_del_arte"] != 7 || len(grades) != 2 {
+		t.Errorf("Notas normalizadas incorrectas: %v %v", grades, err)
+	}
+
+	_, err = s.canonicalGrades(ctx, map[string]float64{"matematicas": 8, "Matemáticas": 6})
+	var invalid *InvalidParamsError
+	if !errors.As(err, &invalid) {
+		t.Errorf("Dos nombres de la misma asignatura son un error: %v", err)
+	}
+}
+
+func TestSubjectListing(t *testing.T) {
+	listing := subjectListing(testCatalog, map[string]int{"matematicas": 3, "Mates": 1, "dibujo": 2})
+
+	subjects := listing["subjects"].([]map[string]interface{})
+	if len(subjects) != 2 || subjects[0]["students"] != 0 || subjects[1]["students"] != 3 {
+		t.Errorf("Asignaturas del catálogo incorrectas: %v", subjects)
+	}
+
+	uncatalogued := listing["uncatalogued"].([]map[string]interface{})
+	if len(uncatalogued) != 2 {
+		t.Fatalf("Asignaturas fuera del catálogo incorrectas: %v", uncatalogued)
+	}
+	if uncatalogued[0]["name"] != "Mates" || uncatalogued[0]["matches"] != "matematicas" {
+		t.Errorf("Un alias se relaciona con su asignatura: %v", uncatalogued[0])
+	}
+	if _, ok := uncatalogued[1]["matches"]; ok || uncatalogued[1]["name"] != "dibujo" {
+		t.Errorf("Una asignatura desconocida no tiene correspondencia: %v", uncatalogued[1])
+	}
+}
+
+func TestSaveSubjectRequiresAdmin(t *testing.T) {
+	_, err := (&Server{}).saveSubject(context.Background(), &ToolRequest{Role: testRoles["profesora.garcia"]}, Subject{Code: "fisica", Name: "Física"})
+	if !errors.Is(err, errForbidden) {
+		t.Errorf("Solo un administrador modifica el catálogo: %v", err)
+	}
+}
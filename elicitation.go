@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Acciones posibles en la respuesta a elicitation/create
+const (
+	elicitAccept  = "accept"
+	elicitDecline = "decline"
+	elicitCancel  = "cancel"
+)
+
+var errElicitationUnsupported = errors.New("el cliente no soporta elicitación")
+
+// gradesSchema es el esquema del argumento subjects de add_student, con el que
+// se validan también las notas elicitadas
+var gradesSchema = schemaFor(reflect.TypeOf(addStudentArgs{})).Properties["subjects"]
+
+// ElicitResult es la respuesta del cliente a elicitation/create
+type ElicitResult struct {
+	Action  string                 `json:"action"`
+	Content map[string]interface{} `json:"content,omitempty"`
+}
+
+// elicit pide datos al usuario a través del cliente. requestedSchema debe ser
+// un objeto plano con propiedades de tipo primitivo, como exige el protocolo.
+func (sess *Session) elicit(ctx context.Context, message string, requestedSchema map[string]interface{}) (ElicitResult, error) {
+	if !sess.supportsElicitation() {
+		return ElicitResult{}, errElicitationUnsupported
+	}
+
+	response, err := sess.request(ctx, "elicitation/create", map[string]interface{}{
+		"message":         message,
+		"requestedSchema": requestedSchema,
+	})
+	if err != nil {
+		return ElicitResult{}, err
+	}
+
+	var result ElicitResult
+	if err := decodeParams(response.Result, &result); err != nil {
+		return ElicitResult{}, fmt.Errorf("respuesta de elicitación inválida: %v", err)
+	}
+	switch result.Action {
+	case elicitAccept, elicitDecline, elicitCancel:
+	default:
+		return ElicitResult{}, fmt.Errorf("acción de elicitación desconocida: %q", result.Action)
+	}
+
+	sess.logger.Debug("Respuesta de elicitación", "accion", result.Action)
+	return result, nil
+}
+
+// elicitGrades pide al usuario las notas de un estudiante nuevo, una por
+// asignatura conocida. Las asignaturas que deje en blanco no se guardan.
+func (sess *Session) elicitGrades(ctx context.Context, name string, subjects []string) (map[string]float64, error) {
+	properties := map[string]interface{}{}
+	for _, subject := range subjects {
+		properties[subject] = map[string]interface{}{
+			"type":        "number",
+			"title":       subject,
+			"description": "Nota de " + subject + " (0-10)",
+			"minimum":     0,
+			"maximum":     10,
+		}
+	}
+
+	result, err := sess.elicit(ctx, fmt.Sprintf("Introduce las notas de %s. Deja en blanco las asignaturas que no curse.", name), map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Action != elicitAccept {
+		return nil, fmt.Errorf("el usuario no proporcionó las notas de %s", name)
+	}
+
+	// minimum y maximum de requestedSchema son solo una indicación para el
+	// cliente: las notas se validan como el argumento subjects, que también
+	// admite los números tal como los escribió el usuario
+	content := map[string]interface{}{}
+	for subject, grade := range result.Content {
+		if grade != nil && grade != "" {
+			content[subject] = grade
+		}
+	}
+	if err := gradesSchema.validate(content, "/subjects"); err != nil {
+		invalid := &InvalidParamsError{Message: "notas elicitadas: " + err.Error()}
+		if schemaErr, ok := err.(*SchemaError); ok {
+			invalid.Pointer = schemaErr.Pointer
+		}
+		return nil, invalid
+	}
+
+	grades := make(map[string]float64, len(content))
+	for subject, grade := range content {
+		grades[subject] = grade.(float64)
+	}
+	return grades, nil
+}
+
+// elicitStudentChoice pide al usuario que elija entre varios estudiantes con el
+// mismo nombre. Devuelve el elegido.
+func (sess *Session) elicitStudentChoice(ctx context.Context, name string, candidates []Student) (Student, error) {
+	ids := make([]string, len(candidates))
+	labels := make([]string, len(candidates))
+	for i, student := range candidates {
+		ids[i] = student.ID.Hex()
+		grades, _ := json.Marshal(student.Subjects)
+		labels[i] = fmt.Sprintf("%s %s", student.Name, grades)
+	}
+
+	result, err := sess.elicit(ctx, fmt.Sprintf("Hay %d estudiantes llamados '%s'. ¿A cuál te refieres?", len(candidates), name), map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"student_id": map[string]interface{}{
+				"type":      "string",
+				"title":     "Estudiante",
+				"enum":      ids,
+				"enumNames": labels,
+			},
+		},
+		"required": []string{"student_id"},
+	})
+	if err != nil {
+		return Student{}, err
+	}
+	if result.Action != elicitAccept {
+		return Student{}, fmt.Errorf("el usuario no eligió ningún estudiante llamado '%s'", name)
+	}
+
+	chosen, _ := result.Content["student_id"].(string)
+	for _, student := range candidates {
+		if student.ID.Hex() == chosen {
+			return student, nil
+		}
+	}
+	return Student{}, fmt.Errorf("el estudiante elegido (%s) no está entre los candidatos", chosen)
+}
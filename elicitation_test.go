@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Test helper: sesión con un cliente simulado que responde a cada petición
+// del servidor con el resultado que devuelve answer
+func newClientStub(t *testing.T, capabilities map[string]interface{}, answer func(MCPMessage) interface{}) *Session {
+	t.Helper()
+
+	s := &Server{}
+	reader, writer := io.Pipe()
+	sess := newSession(transportStdio, "", writer)
+	sess.initialize(InitializeParams{ProtocolVersion: "2025-06-18", Capabilities: capabilities})
+
+	go func() {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			var msg MCPMessage
+			json.Unmarshal(scanner.Bytes(), &msg)
+			if msg.Method == "" || msg.ID == nil {
+				continue
+			}
+			reply, _ := json.Marshal(MCPMessage{JsonRPC: "2.0", ID: msg.ID, Result: answer(msg)})
+			s.processMessage(context.Background(), sess, reply)
+		}
+	}()
+
+	t.Cleanup(func() {
+		sess.close()
+		writer.Close()
+	})
+	return sess
+}
+
+var elicitationCapability = map[string]interface{}{"elicitation": map[string]interface{}{}}
+
+func TestElicitGrades(t *testing.T) {
+	var requested map[string]interface{}
+	sess := newClientStub(t, elicitationCapability, func(msg MCPMessage) interface{} {
+		requested = msg.Params.(map[string]interface{})
+		return map[string]interface{}{
+			"action":  "accept",
+			"content": map[string]interface{}{"matematicas": 7.5, "historia": nil},
+		}
+	})
+
+	grades, err := sess.elicitGrades(context.Background(), "Lucía Romero", []string{"historia", "matematicas"})
+	if err != nil {
+		t.Fatalf("Error en elicitación: %v", err)
+	}
+	if len(grades) != 1 || grades["matematicas"] != 7.5 {
+		t.Errorf("Notas incorrectas: %v", grades)
+	}
+
+	schema := requested["requestedSchema"].(map[string]interface{})
+	if properties := schema["properties"].(map[string]interface{}); len(properties) != 2 {
+		t.Errorf("Se esperaba una propiedad por asignatura: %v", properties)
+	}
+}
+
+func TestElicitGradesValidatesRange(t *testing.T) {
+	for _, content := range []map[string]interface{}{
+		{"matematicas": 99.0},
+		{"matematicas": "-5"},
+		{"matematicas": "siete"},
+		{"matematicas": true},
+	} {
+		sess := newClientStub(t, elicitationCapability, func(MCPMessage) interface{} {
+			return map[string]interface{}{"action": "accept", "content": content}
+		})
+		var invalid *InvalidParamsError
+		if _, err := sess.elicitGrades(context.Background(), "Lucía Romero", []string{"matematicas"}); !errors.As(err, &invalid) || invalid.Pointer != "/subjects/matematicas" {
+			t.Errorf("%v: se esperaba un error de parámetros, se obtuvo %v", content, err)
+		}
+	}
+
+	// Las notas escritas como texto se siguen aceptando
+	sess := newClientStub(t, elicitationCapability, func(MCPMessage) interface{} {
+		return map[string]interface{}{"action": "accept", "content": map[string]interface{}{"matematicas": " 7.5", "historia": ""}}
+	})
+	if grades, err := sess.elicitGrades(context.Background(), "Lucía Romero", []string{"historia", "matematicas"}); err != nil || !reflect.DeepEqual(grades, map[string]float64{"matematicas": 7.5}) {
+		t.Errorf("Notas como texto: %v %v", grades, err)
+	}
+}
+
+func TestElicitGradesDeclined(t *testing.T) {
+	sess := newClientStub(t, elicitationCapability, func(MCPMessage) interface{} {
+		return map[string]interface{}{"action": "decline"}
+	})
+
+	if _, err := sess.elicitGrades(context.Background(), "Lucía Romero", []string{"historia"}); err == nil {
+		t.Error("Rechazar la elicitación debería producir un error")
+	}
+}
+
+func TestElicitStudentChoice(t *testing.T) {
+	candidates := []Student{
+		{ID: primitive.NewObjectID(), Name: "Juan Pérez", Subjects: map[string]float64{"historia": 9}},
+		{ID: primitive.NewObjectID(), Name: "Juan Pérez", Subjects: map[string]float64{"historia": 5}},
+	}
+
+	sess := newClientStub(t, elicitationCapability, func(MCPMessage) interface{} {
+		return map[string]interface{}{
+			"action":  "accept",
+			"content": map[string]interface{}{"student_id": candidates[1].ID.Hex()},
+		}
+	})
+
+	student, err := sess.elicitStudentChoice(context.Background(), "Juan Pérez", candidates)
+	if err != nil {
+		t.Fatalf("Error en elicitación: %v", err)
+	}
+	if student.ID != candidates[1].ID {
+		t.Errorf("Estudiante incorrecto: %v", student.ID)
+	}
+}
+
+func TestElicitationRequiresCapability(t *testing.T) {
+	sess := newClientStub(t, map[string]interface{}{}, func(MCPMessage) interface{} {
+		t.Error("No debería enviarse elicitation/create")
+		return nil
+	})
+
+	if _, err := sess.elicit(context.Background(), "¿?", map[string]interface{}{}); err != errElicitationUnsupported {
+		t.Errorf("Se esperaba errElicitationUnsupported: %v", err)
+	}
+}
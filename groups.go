@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultGroupsCollection = "groups"
+
+	// Nota mínima para aprobar
+	passingGrade = 5
+)
+
+// Group es un grupo de clase (por ejemplo, "2º ESO B") con su tutor y los
+// estudiantes matriculados
+type Group struct {
+	Name string `bson:"_id" json:"name"`
+	Year string `bson:"year,omitempty" json:"year,omitempty"`
+	// Identidad del tutor (el sub del token, el CN del certificado...)
+	Tutor    string               `bson:"tutor,omitempty" json:"tutor,omitempty"`
+	Students []primitive.ObjectID `bson:"students" json:"students"`
+}
+
+type createGroupArgs struct {
+	Name   string `json:"name" description:"Nombre del grupo (por ejemplo 2º ESO B)" jsonschema:"minLength=1,maxLength=100"`
+	Year   string `json:"year,omitempty" description:"Curso (por ejemplo 2024-2025)" jsonschema:"pattern=^[0-9]{4}-[0-9]{4}$"`
+	Tutor  string `json:"tutor,omitempty" description:"Identidad del tutor, tal como aparece en ROLES_FILE" jsonschema:"maxLength=200"`
+	Reason string `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+type enrollmentArgs struct {
+	Group     string `json:"group" description:"Nombre del grupo" jsonschema:"minLength=1,maxLength=100"`
+	Student   string `json:"student,omitempty" description:"Nombre del estudiante" jsonschema:"minLength=1,maxLength=200"`
+	StudentID string `json:"student_id,omitempty" description:"Id del estudiante, en lugar del nombre; necesario si hay varios con el mismo nombre" jsonschema:"pattern=^[0-9a-f]{24}$"`
+	Reason    string `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+type groupFilterArgs struct {
+	Group string `json:"group,omitempty" description:"Limita el resultado a los estudiantes de un grupo" jsonschema:"maxLength=100"`
+}
+
+type statisticsArgs struct {
+	Subject string `json:"subject,omitempty" description:"Asignatura; sin ella, todas" jsonschema:"maxLength=100"`
+	Group   string `json:"group,omitempty" description:"Limita las estadísticas a un grupo" jsonschema:"maxLength=100"`
+	Term    string `json:"term,omitempty" description:"Evaluación: 1, 2, 3 o final. Sin ella, las notas actuales" jsonschema:"enum=1|2|3|final"`
+	Year    string `json:"year,omitempty" description:"Curso de la evaluación (por ejemplo 2024-2025); por defecto el actual" jsonschema:"pattern=^[0-9]{4}-[0-9]{4}$"`
+}
+
+// findGroup busca un grupo por nombre
+func (s *Server) findGroup(ctx context.Context, name string) (Group, error) {
+	var group Group
+	err := s.groups.FindOne(ctx, bson.M{"_id": name}).Decode(&group)
+	if err == mongo.ErrNoDocuments {
+		return Group{}, fmt.Errorf("grupo '%s' no encontrado", name)
+	}
+	return group, err
+}
+
+// groupFilter selecciona en MongoDB los estudiantes de un grupo; sin grupo no
+// filtra
+func (s *Server) groupFilter(ctx context.Context, name string) (bson.M, error) {
+	if name == "" {
+		return bson.M{}, nil
+	}
+	group, err := s.findGroup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	students := group.Students
+	if students == nil {
+		students = []primitive.ObjectID{}
+	}
+	return bson.M{"_id": bson.M{"$in": students}}, nil
+}
+
+// tutoredStudents devuelve los estudiantes de los grupos de un tutor
+func (s *Server) tutoredStudents(ctx context.Context, tutor string) ([]primitive.ObjectID, error) {
+	if s.groups == nil {
+		return nil, nil
+	}
+	cursor, err := s.groups.Find(ctx, bson.M{"tutor": tutor})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []Group
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	var students []primitive.ObjectID
+	for _, group := range groups {
+		students = append(students, group.Students...)
+	}
+	return students, nil
+}
+
+// requestRole es el rol de la sesión para una llamada: al de ROLES_FILE se
+// le añaden a un tutor los estudiantes de los grupos que tutoriza, y los
+// estudiantes asignados por número o por nombre se resuelven a su id
+func (s *Server) requestRole(ctx context.Context, sess *Session) Role {
+	role := s.roleFor(sess)
+	identity, _ := sess.Identity()
+
+	switch role.Name {
+	case roleTutor:
+		students, err := s.tutoredStudents(ctx, identity.Subject)
+		if err != nil {
+			sess.logger.Error("Error leyendo los grupos del tutor", "sujeto", identity.Subject, "error", err)
+		}
+		role.StudentIDs = append(students, s.resolveBoundStudents(ctx, sess, identity.Subject, role.Students, role.StudentNumbers)...)
+	case roleStudent:
+		// El número de expediente, si lo hay, identifica sin ambigüedad
+		if role.StudentNumber != "" {
+			role.StudentIDs = s.resolveBoundStudents(ctx, sess, identity.Subject, nil, []string{role.StudentNumber})
+		} else {
+			role.StudentIDs = s.resolveBoundStudents(ctx, sess, identity.Subject, []string{role.Student}, nil)
+		}
+	case roleTeacher:
+		// y a un profesor, las asignaturas que tiene asignadas en el catálogo
+		taught, err := s.taughtSubjects(ctx, identity.Subject)
+		if err != nil {
+			sess.logger.Error("Error leyendo las asignaturas del profesor", "sujeto", identity.Subject, "error", err)
+		}
+		subjects := append([]string(nil), role.Subjects...)
+		for _, subject := range taught {
+			if !containsString(subjects, subject) {
+				subjects = append(subjects, subject)
+			}
+		}
+		role.Subjects = subjects
+	}
+	return role
+}
+
+// resolveBoundStudents resuelve los estudiantes de ROLES_FILE de una identidad
+// y avisa de los que no se pueden resolver
+func (s *Server) resolveBoundStudents(ctx context.Context, sess *Session, subject string, names, numbers []string) []primitive.ObjectID {
+	ids, ambiguous, err := s.boundStudents(ctx, names, numbers)
+	if err != nil {
+		sess.logger.Error("Error leyendo los estudiantes del rol", "sujeto", subject, "error", err)
+	}
+	if len(ambiguous) > 0 {
+		sess.logger.Warn("Hay varios estudiantes con el nombre asignado en ROLES_FILE; asígnalos por número de expediente", "sujeto", subject, "nombres", ambiguous)
+	}
+	return ids
+}
+
+// createGroup crea un grupo vacío
+func (s *Server) createGroup(ctx context.Context, req *ToolRequest, name, year, tutor string) (interface{}, error) {
+	if req.Role.Name != roleAdmin {
+		return nil, fmt.Errorf("%w: solo un administrador puede gestionar grupos", errForbidden)
+	}
+
+	group := Group{Name: name, Year: year, Tutor: tutor, Students: []primitive.ObjectID{}}
+	if _, err := s.groups.InsertOne(ctx, group); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("ya existe el grupo '%s'", name)
+		}
+		return nil, err
+	}
+	req.RecordChange(Change{Collection: s.groups.Name(), RecordAfter: group})
+	return map[string]interface{}{
+		"message": "Grupo creado",
+		"group":   group,
+	}, nil
+}
+
+// enrollStudent matricula a un estudiante en un grupo, o lo da de baja
+func (s *Server) enrollStudent(ctx context.Context, req *ToolRequest, groupName string, ref studentRef, enroll bool) (interface{}, error) {
+	if req.Role.Name != roleAdmin {
+		return nil, fmt.Errorf("%w: solo un administrador puede gestionar grupos", errForbidden)
+	}
+
+	group, err := s.findGroup(ctx, groupName)
+	if err != nil {
+		return nil, err
+	}
+	if err := ref.check("/student", "/student_id"); err != nil {
+		return nil, err
+	}
+	student, err := s.findStudent(ctx, req.Session, req.Role, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	enrolled := false
+	for _, id := range group.Students {
+		if id == student.ID {
+			enrolled = true
+		}
+	}
+
+	after := group
+	after.Students = append([]primitive.ObjectID{}, group.Students...)
+	update, message := bson.M{"$addToSet": bson.M{"students": student.ID}}, "Estudiante matriculado"
+	switch {
+	case enroll && enrolled:
+		return nil, fmt.Errorf("%s ya está en el grupo '%s'", student.Name, group.Name)
+	case !enroll && !enrolled:
+		return nil, fmt.Errorf("%s no está en el grupo '%s'", student.Name, group.Name)
+	case enroll:
+		after.Students = append(after.Students, student.ID)
+	default:
+		update, message = bson.M{"$pull": bson.M{"students": student.ID}}, "Estudiante dado de baja"
+		after.Students = after.Students[:0]
+		for _, id := range group.Students {
+			if id != student.ID {
+				after.Students = append(after.Students, id)
+			}
+		}
+	}
+
+	if _, err := s.groups.UpdateOne(ctx, bson.M{"_id": group.Name}, update); err != nil {
+		return nil, err
+	}
+	req.RecordChange(Change{StudentID: student.ID, StudentName: student.Name, Collection: s.groups.Name(), RecordBefore: group, RecordAfter: after})
+	return map[string]interface{}{
+		"message":    message,
+		"group":      group.Name,
+		"student":    student.Name,
+		"student_id": student.ID,
+	}, nil
+}
+
+// listGroups devuelve los grupos con su tutor y el número de estudiantes
+func (s *Server) listGroups(ctx context.Context) (interface{}, error) {
+	cursor, err := s.groups.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []Group
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	summaries := make([]map[string]interface{}, len(groups))
+	for i, group := range groups {
+		summaries[i] = map[string]interface{}{
+			"name":     group.Name,
+			"year":     group.Year,
+			"tutor":    group.Tutor,
+			"students": len(group.Students),
+		}
+	}
+	return map[string]interface{}{"groups": summaries}, nil
+}
+
+// SubjectStatistics resume las notas de una asignatura
+type SubjectStatistics struct {
+	Count    int     `json:"count"`
+	Average  float64 `json:"average"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Passed   int     `json:"passed"`
+	PassRate float64 `json:"pass_rate"`
+}
+
+// subjectStatistics calcula las estadísticas de cada asignatura a partir de
+// las notas de los estudiantes
+func subjectStatistics(grades []map[string]float64, subject string) map[string]SubjectStatistics {
+	stats := map[string]SubjectStatistics{}
+	totals := map[string]float64{}
+	for _, studentGrades := range grades {
+		for name, grade := range studentGrades {
+			if subject != "" && name != subject {
+				continue
+			}
+			st, seen := stats[name]
+			if !seen {
+				st.Min, st.Max = math.Inf(1), math.Inf(-1)
+			}
+			st.Count++
+			st.Min = math.Min(st.Min, grade)
+			st.Max = math.Max(st.Max, grade)
+			if grade >= passingGrade {
+				st.Passed++
+			}
+			totals[name] += grade
+			stats[name] = st
+		}
+	}
+
+	for name, st := range stats {
+		st.Average = round2(totals[name] / float64(st.Count))
+		st.PassRate = round2(float64(st.Passed) / float64(st.Count) * 100)
+		stats[name] = st
+	}
+	return stats
+}
+
+// getStatistics devuelve media, mínima, máxima y porcentaje de aprobados por
+// asignatura, de todos los estudiantes visibles o de un grupo
+func (s *Server) getStatistics(ctx context.Context, role Role, subject, group string, period Period, progress *ProgressReporter) (interface{}, error) {
+	if subject != "" && !role.canSeeSubject(subject) {
+		return nil, fmt.Errorf("%w: no impartes %s", errForbidden, subject)
+	}
+	filter, err := s.groupFilter(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	total := s.countStudents(ctx, progress)
+
+	cursor, err := s.collection.Find(ctx, role.restrict(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var grades []map[string]float64
+	for cursor.Next(ctx) {
+		var student Student
+		if err := cursor.Decode(&student); err != nil {
+			return nil, err
+		}
+		if student, visible := role.redact(student); visible {
+			studentGrades, _ := student.gradesFor(period)
+			grades = append(grades, studentGrades)
+		}
+		if len(grades)%progressStep == 0 {
+			progress.Report(float64(len(grades)), total, fmt.Sprintf("%d estudiantes revisados", len(grades)))
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	progress.Report(float64(len(grades)), total, "Estadísticas calculadas")
+
+	result := map[string]interface{}{
+		"students": len(grades),
+		"subjects": subjectStatistics(grades, subject),
+	}
+	if group != "" {
+		result["group"] = group
+	}
+	if !period.IsZero() {
+		result["year"] = period.Year
+		result["term"] = period.Term
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSubjectStatistics(t *testing.T) {
+	grades := []map[string]float64{
+		{"matematicas": 4, "historia": 8},
+		{"matematicas": 6},
+		{"matematicas": 9.5, "historia": 5},
+	}
+
+	stats := subjectStatistics(grades, "")
+	expected := SubjectStatistics{Count: 3, Average: 6.5, Min: 4, Max: 9.5, Passed: 2, PassRate: 66.67}
+	if stats["matematicas"] != expected {
+		t.Errorf("Estadísticas de matemáticas incorrectas: %+v", stats["matematicas"])
+	}
+	if stats["historia"].Count != 2 || stats["historia"].PassRate != 100 {
+		t.Errorf("Estadísticas de historia incorrectas: %+v", stats["historia"])
+	}
+
+	if only := subjectStatistics(grades, "historia"); len(only) != 1 {
+		t.Errorf("Con asignatura solo se calcula esa: %v", only)
+	}
+	if empty := subjectStatistics(nil, ""); len(empty) != 0 {
+		t.Errorf("Sin notas no hay estadísticas: %v", empty)
+	}
+}
+
+func TestTutorSeesGroupStudents(t *testing.T) {
+	inGroup := Student{ID: primitive.NewObjectID(), Name: "Pedro"}
+	bound := Student{ID: primitive.NewObjectID(), Name: "Ana"}
+	tutor := Role{Name: roleTutor, Students: []string{"Ana"}, StudentIDs: []primitive.ObjectID{inGroup.ID, bound.ID}}
+
+	if _, ok := tutor.redact(inGroup); !ok {
+		t.Error("El tutor ve a los estudiantes de sus grupos")
+	}
+	if _, ok := tutor.redact(bound); !ok {
+		t.Error("El tutor sigue viendo a los estudiantes de ROLES_FILE")
+	}
+	if _, ok := tutor.redact(Student{ID: primitive.NewObjectID(), Name: "Ana"}); ok {
+		t.Error("El tutor no ve a otro estudiante con el mismo nombre")
+	}
+	if _, ok := tutor.redact(Student{ID: primitive.NewObjectID(), Name: "Luis"}); ok {
+		t.Error("El tutor no ve a otros estudiantes")
+	}
+
+	expected := bson.M{"_id": bson.M{"$in": []primitive.ObjectID{inGroup.ID, bound.ID}}}
+	if filter := tutor.studentFilter(); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Filtro de tutor incorrecto: %v", filter)
+	}
+}
+
+func TestGroupManagementRequiresAdmin(t *testing.T) {
+	s := &Server{}
+	ctx := context.Background()
+	req := &ToolRequest{Session: sessionFor("profesora.garcia", authMTLS), Role: testRoles["profesora.garcia"]}
+
+	if _, err := s.createGroup(ctx, req, "2º ESO B", "", ""); !errors.Is(err, errForbidden) {
+		t.Errorf("Solo un administrador crea grupos: %v", err)
+	}
+	if _, err := s.enrollStudent(ctx, req, "2º ESO B", studentRef{Name: "Ana"}, true); !errors.Is(err, errForbidden) {
+		t.Errorf("Solo un administrador matricula: %v", err)
+	}
+}
+
+func TestGroupFilterWithoutGroup(t *testing.T) {
+	filter, err := (&Server{}).groupFilter(context.Background(), "")
+	if err != nil || len(filter) != 0 {
+		t.Errorf("Sin grupo no se filtra: %v %v", filter, err)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultHistoryCollection = "grade_history"
+
+// GradeEvent es una versión de la nota de un estudiante en una asignatura.
+// Grade es nil si la nota se eliminó; Previous es la nota anterior, lo que
+// permite saber qué valor tenían los estudiantes cargados antes de que
+// existiera el historial.
+type GradeEvent struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	StudentID   primitive.ObjectID `bson:"student_id" json:"student_id"`
+	StudentName string             `bson:"student_name" json:"student_name"`
+	Subject     string             `bson:"subject" json:"subject"`
+	Version     int                `bson:"version" json:"version"`
+	Grade       *float64           `bson:"grade" json:"grade"`
+	Previous    *float64           `bson:"previous,omitempty" json:"previous,omitempty"`
+	Time        time.Time          `bson:"time" json:"time"`
+	Caller      string             `bson:"caller,omitempty" json:"caller,omitempty"`
+	Tool        string             `bson:"tool" json:"tool"`
+}
+
+// gradeEvents compara las notas antes y después de un cambio y devuelve un
+// evento por asignatura modificada, sin versión ni fecha
+func gradeEvents(change Change) []GradeEvent {
+	var before, after map[string]float64
+	if change.Before != nil {
+		before = change.Before.Subjects
+	}
+	if change.After != nil {
+		after = change.After.Subjects
+	}
+
+	subjects := map[string]interface{}{}
+	for subject := range before {
+		subjects[subject] = nil
+	}
+	for subject := range after {
+		subjects[subject] = nil
+	}
+
+	var events []GradeEvent
+	for _, subject := range sortedKeys(subjects) {
+		old, hadOld := before[subject]
+		grade, hasNew := after[subject]
+		if hadOld == hasNew && old == grade {
+			continue
+		}
+
+		event := GradeEvent{StudentID: change.StudentID, StudentName: change.StudentName, Subject: subject}
+		if hadOld {
+			event.Previous = &old
+		}
+		if hasNew {
+			event.Grade = &grade
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// Intentos de guardar un evento cuando otra escritura simultánea se queda
+// con su versión
+const historyVersionAttempts = 5
+
+// recordGradeHistory guarda los eventos de nota de los cambios que hizo una
+// herramienta. La versión es consecutiva por estudiante y asignatura; si el
+// índice único la rechaza porque otra escritura simultánea la ocupó, el
+// evento se guarda con la siguiente.
+func (s *Server) recordGradeHistory(ctx context.Context, req *ToolRequest, tool string) {
+	if s.history == nil {
+		return
+	}
+
+	identity, _ := req.Session.Identity()
+	ctx = context.WithoutCancel(ctx)
+
+	for _, change := range req.changes {
+		for _, event := range gradeEvents(change) {
+			event.Caller = identity.Subject
+			event.Tool = tool
+			if err := s.insertGradeEvent(ctx, event); err != nil {
+				req.Session.logger.Error("Error registrando el historial de notas", "estudiante", event.StudentName, "asignatura", event.Subject, "error", err)
+			}
+		}
+	}
+}
+
+// insertGradeEvent guarda el evento con la versión siguiente a la última
+func (s *Server) insertGradeEvent(ctx context.Context, event GradeEvent) error {
+	for attempt := 1; ; attempt++ {
+		var latest GradeEvent
+		err := s.history.FindOne(ctx,
+			bson.M{"student_id": event.StudentID, "subject": event.Subject},
+			options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+		).Decode(&latest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		event.ID = primitive.NewObjectID()
+		event.Version = latest.Version + 1
+		// La fecha se toma con la versión para que ambas sigan el mismo orden
+		event.Time = time.Now().UTC()
+		_, err = s.history.InsertOne(ctx, event)
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		if attempt == historyVersionAttempts {
+			return fmt.Errorf("la versión %d está ocupada tras %d intentos: %v", event.Version, attempt, err)
+		}
+	}
+}
+
+// ensureHistoryIndexes crea el índice que garantiza versiones únicas
+func ensureHistoryIndexes(ctx context.Context, history *mongo.Collection) error {
+	_, err := history.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "student_id", Value: 1}, {Key: "subject", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// gradeHistory devuelve los eventos de un estudiante en orden cronológico,
+// opcionalmente de una sola asignatura
+func (s *Server) gradeHistory(ctx context.Context, studentID primitive.ObjectID, subject string) ([]GradeEvent, error) {
+	events := []GradeEvent{}
+	if s.history == nil {
+		return events, nil
+	}
+
+	filter := bson.M{"student_id": studentID}
+	if subject != "" {
+		filter["subject"] = subject
+	}
+	cursor, err := s.history.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "version", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// gradesAsOf reconstruye las notas en una fecha a partir de las notas
+// actuales y los eventos en orden cronológico. Las asignaturas sin eventos
+// se devuelven con su nota actual y se indican en withoutHistory, porque no
+// sabemos desde cuándo la tienen.
+func gradesAsOf(current map[string]float64, events []GradeEvent, asOf time.Time) (grades map[string]float64, withoutHistory []string) {
+	grades = map[string]float64{}
+
+	bySubject := map[string][]GradeEvent{}
+	for _, event := range events {
+		bySubject[event.Subject] = append(bySubject[event.Subject], event)
+	}
+
+	for subject, grade := range current {
+		if len(bySubject[subject]) == 0 {
+			grades[subject] = grade
+			withoutHistory = append(withoutHistory, subject)
+		}
+	}
+	sort.Strings(withoutHistory)
+
+	for subject, subjectEvents := range bySubject {
+		// Antes del primer evento valía lo que ese evento reemplazó
+		value := subjectEvents[0].Previous
+		for _, event := range subjectEvents {
+			if event.Time.After(asOf) {
+				break
+			}
+			value = event.Grade
+		}
+		if value != nil {
+			grades[subject] = *value
+		}
+	}
+	return grades, withoutHistory
+}
+
+// parseAsOf admite una fecha y hora RFC 3339 o una fecha (AAAA-MM-DD), que se
+// interpreta como el final de ese día en UTC
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return time.Time{}, &InvalidParamsError{
+		Message: fmt.Sprintf("argumento '/as_of': se esperaba una fecha (AAAA-MM-DD) o fecha y hora RFC 3339, se recibió %q", value),
+		Pointer: "/as_of",
+	}
+}
+
+// getGradeHistory devuelve la trayectoria de notas de un estudiante. Los
+// profesores solo ven la de sus asignaturas.
+func (s *Server) getGradeHistory(ctx context.Context, sess *Session, role Role, ref studentRef, subject string) (interface{}, error) {
+	if subject != "" && !role.canSeeSubject(subject) {
+		return nil, fmt.Errorf("%w: no impartes %s", errForbidden, subject)
+	}
+
+	student, err := s.findStudent(ctx, sess, role, ref)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.gradeHistory(ctx, student.ID, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := []GradeEvent{}
+	for _, event := range events {
+		if role.canSeeSubject(event.Subject) {
+			visible = append(visible, event)
+		}
+	}
+	return map[string]interface{}{
+		"student": student.Name,
+		"history": visible,
+	}, nil
+}
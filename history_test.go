@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGradeEvents(t *testing.T) {
+	before := &Student{Name: "Ana", Subjects: map[string]float64{"matematicas": 6, "historia": 7, "fisica": 5}}
+	after := &Student{Name: "Ana", Subjects: map[string]float64{"matematicas": 8, "historia": 7, "ingles": 9}}
+
+	events := gradeEvents(Change{StudentName: "Ana", Before: before, After: after})
+	if len(events) != 3 {
+		t.Fatalf("Se esperaban 3 eventos: %+v", events)
+	}
+
+	fisica, ingles, matematicas := events[0], events[1], events[2]
+	if fisica.Subject != "fisica" || *fisica.Previous != 5 || fisica.Grade != nil {
+		t.Errorf("Nota eliminada mal registrada: %+v", fisica)
+	}
+	if ingles.Subject != "ingles" || ingles.Previous != nil || *ingles.Grade != 9 {
+		t.Errorf("Nota nueva mal registrada: %+v", ingles)
+	}
+	if matematicas.Subject != "matematicas" || *matematicas.Previous != 6 || *matematicas.Grade != 8 {
+		t.Errorf("Nota cambiada mal registrada: %+v", matematicas)
+	}
+
+	if events := gradeEvents(Change{After: after}); len(events) != 3 {
+		t.Errorf("Una inserción genera un evento por asignatura: %+v", events)
+	}
+}
+
+func TestGradesAsOf(t *testing.T) {
+	grade := func(v float64) *float64 { return &v }
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 12, 0, 0, 0, time.UTC) }
+
+	current := map[string]float64{"matematicas": 9, "historia": 7}
+	events := []GradeEvent{
+		// Nota cargada antes del historial y corregida dos veces
+		{Subject: "matematicas", Previous: grade(5), Grade: grade(6), Time: day(5)},
+		{Subject: "matematicas", Previous: grade(6), Grade: grade(9), Time: day(10)},
+		{Subject: "fisica", Grade: grade(4), Time: day(3)},
+		{Subject: "fisica", Previous: grade(4), Time: day(8)},
+	}
+
+	cases := []struct {
+		asOf     time.Time
+		expected map[string]float64
+	}{
+		{day(1), map[string]float64{"matematicas": 5, "historia": 7}},
+		{day(5), map[string]float64{"matematicas": 6, "historia": 7, "fisica": 4}},
+		{day(9), map[string]float64{"matematicas": 6, "historia": 7}},
+		{day(20), map[string]float64{"matematicas": 9, "historia": 7}},
+	}
+	for _, c := range cases {
+		grades, withoutHistory := gradesAsOf(current, events, c.asOf)
+		if !reflect.DeepEqual(grades, c.expected) {
+			t.Errorf("%v: notas %v, esperadas %v", c.asOf, grades, c.expected)
+		}
+		if !reflect.DeepEqual(withoutHistory, []string{"historia"}) {
+			t.Errorf("Asignaturas sin historial incorrectas: %v", withoutHistory)
+		}
+	}
+}
+
+func TestParseAsOf(t *testing.T) {
+	if asOf, err := parseAsOf("2025-03-01"); err != nil || !asOf.Equal(time.Date(2025, 3, 1, 23, 59, 59, 999999999, time.UTC)) {
+		t.Errorf("Una fecha se interpreta como el final del día: %v %v", asOf, err)
+	}
+	if asOf, err := parseAsOf("2025-03-01T10:00:00+01:00"); err != nil || !asOf.Equal(time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Fecha y hora mal interpretada: %v %v", asOf, err)
+	}
+
+	var invalidParams *InvalidParamsError
+	if _, err := parseAsOf("1 de marzo"); !errors.As(err, &invalidParams) || invalidParams.Pointer != "/as_of" {
+		t.Errorf("Se esperaba un error de parámetros: %v", err)
+	}
+}
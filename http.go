@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cabeceras del transporte Streamable HTTP de MCP
+const (
+	mcpSessionHeader  = "Mcp-Session-Id"
+	mcpProtocolHeader = "MCP-Protocol-Version"
+)
+
+const (
+	// Ruta del endpoint MCP
+	httpEndpointPath = "/mcp"
+	// Tamaño máximo del cuerpo de una petición
+	maxHTTPBody = 4 << 20
+	// Mensajes del servidor que esperan a que el cliente abra el stream SSE
+	httpStreamBuffer = 64
+	// Inactividad tras la que se descarta una sesión HTTP
+	defaultHTTPSessionTimeout = 30 * time.Minute
+	// Interfaz en la que escucha el transporte HTTP; solo local por defecto
+	defaultHTTPHost = "127.0.0.1"
+)
+
+// httpTransport sirve MCP sobre HTTP: cada mensaje del cliente es un POST, las
+// respuestas van en el cuerpo como JSON y los mensajes que inicia el servidor
+// (notificaciones, elicitación, ping) se envían por el stream SSE que el
+// cliente abre con GET
+type httpTransport struct {
+	server *Server
+	// nil si el transporte no exige autorización
+	oauth          *OAuthConfig
+	sessionTimeout time.Duration
+	// Orígenes desde los que un navegador puede llamar al servidor; vacío
+	// solo admite localhost
+	allowedOrigins []string
+
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+type httpSession struct {
+	*Session
+	events *eventQueue
+}
+
+// eventQueue es la salida de una sesión HTTP: guarda los mensajes hasta que
+// el stream SSE los envía. Si el cliente no abre el stream y la cola se
+// llena, los mensajes se descartan.
+type eventQueue struct {
+	ch chan []byte
+
+	mu        sync.Mutex
+	streaming bool
+}
+
+func (q *eventQueue) Write(p []byte) (int, error) {
+	message := bytes.TrimSpace(append([]byte(nil), p...))
+	select {
+	case q.ch <- message:
+	default:
+		slog.Debug("Mensaje descartado: el cliente HTTP no lee el stream SSE")
+	}
+	return len(p), nil
+}
+
+// isStreaming indica si el cliente tiene abierto el stream SSE
+func (q *eventQueue) isStreaming() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.streaming
+}
+
+func newHTTPTransport(s *Server, oauth *OAuthConfig) *httpTransport {
+	return &httpTransport{
+		server:         s,
+		oauth:          oauth,
+		sessionTimeout: defaultHTTPSessionTimeout,
+		sessions:       map[string]*httpSession{},
+	}
+}
+
+func (t *httpTransport) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(httpEndpointPath, t.serveMCP)
+	if t.oauth != nil {
+		mux.HandleFunc(protectedResourcePath, t.oauth.serveMetadata)
+		mux.HandleFunc(protectedResourcePath+httpEndpointPath, t.oauth.serveMetadata)
+	}
+	return mux
+}
+
+func (t *httpTransport) serveMCP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" && !t.allowedOrigin(origin) {
+		slog.Warn("Origen no permitido", "remote", r.RemoteAddr, "origen", origin)
+		http.Error(w, "origen no permitido: "+origin, http.StatusForbidden)
+		return
+	}
+
+	identity, ok := t.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	if version := r.Header.Get(mcpProtocolHeader); version != "" && negotiateProtocolVersion(version) != version {
+		http.Error(w, "versión del protocolo no soportada: "+version, http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		t.handlePost(w, r, identity)
+	case http.MethodGet:
+		t.handleStream(w, r, identity)
+	case http.MethodDelete:
+		if sess := t.session(w, r, identity); sess != nil {
+			t.closeSession(sess, "cerrada por el cliente")
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "método no permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+// allowedOrigin impide que una página web llame al servidor mediante DNS
+// rebinding: los navegadores envían Origin y solo se aceptan los de
+// HTTP_ALLOWED_ORIGINS o, si no se define, los de localhost. Los clientes
+// que no son navegadores no lo envían.
+func (t *httpTransport) allowedOrigin(origin string) bool {
+	if len(t.allowedOrigins) > 0 {
+		return containsString(t.allowedOrigins, strings.TrimSuffix(origin, "/"))
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// authorize valida el access token si hay OAuth configurado; sin OAuth la
+// identidad queda a cargo de authenticate
+func (t *httpTransport) authorize(w http.ResponseWriter, r *http.Request) (*Identity, bool) {
+	if t.oauth == nil {
+		return nil, true
+	}
+	identity, ok := t.oauth.authorize(w, r)
+	return &identity, ok
+}
+
+func (t *httpTransport) handlePost(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPBody))
+	if err != nil {
+		http.Error(w, "cuerpo demasiado grande", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var header struct {
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
+	}
+	if err := json.Unmarshal(body, &header); err != nil {
+		// processMessage responde con el error -32700 sin usar la sesión
+		writeJSON(w, http.StatusBadRequest, t.server.processMessage(r.Context(), nil, body))
+		return
+	}
+
+	if header.Method == "initialize" {
+		t.initialize(w, r, identity, body)
+		return
+	}
+
+	sess := t.session(w, r, identity)
+	if sess == nil {
+		return
+	}
+	sess.touch()
+
+	// Notificaciones y respuestas a peticiones del servidor no llevan respuesta
+	if header.ID == nil || header.Method == "" {
+		t.server.processMessage(sess.ctx, sess.Session, body)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// La petición se cancela también si el cliente corta la conexión
+	ctx, done := sess.beginRequest(header.ID)
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(r.Context(), cancel)
+	response := t.server.processMessage(ctx, sess.Session, body)
+	cancelled := ctx.Err() != nil
+	stop()
+	cancel()
+	done()
+
+	// Una petición cancelada no lleva respuesta
+	if cancelled {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// initialize crea la sesión y le asigna un identificador que el cliente debe
+// enviar en Mcp-Session-Id en adelante
+func (t *httpTransport) initialize(w http.ResponseWriter, r *http.Request, identity *Identity, body []byte) {
+	events := &eventQueue{ch: make(chan []byte, httpStreamBuffer)}
+	sess := &httpSession{
+		Session: newSession(transportHTTP, r.RemoteAddr, events),
+		events:  events,
+	}
+	if identity != nil {
+		sess.setIdentity(*identity)
+	}
+	sess.reachable = events.isStreaming
+
+	response := t.server.processMessage(sess.ctx, sess.Session, body)
+	if !sess.acceptsRequests() {
+		// initialize falló: no hay sesión que conservar
+		sess.close()
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	t.mu.Lock()
+	t.sessions[sess.id] = sess
+	t.mu.Unlock()
+	t.server.addSession(sess.Session)
+	sess.touch()
+	sess.logger.Info("Nueva sesión HTTP", "sesion", sess.id, "remote", r.RemoteAddr)
+	go sess.keepalive(t.server.keepalive, func() { t.closeSession(sess, "sin respuesta al ping") })
+
+	w.Header().Set(mcpSessionHeader, sess.id)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// session busca la sesión de la petición y comprueba que la usa la misma
+// identidad que la creó. Si falla ya ha respondido al cliente.
+func (t *httpTransport) session(w http.ResponseWriter, r *http.Request, identity *Identity) *httpSession {
+	id := r.Header.Get(mcpSessionHeader)
+	if id == "" {
+		http.Error(w, "falta la cabecera "+mcpSessionHeader, http.StatusBadRequest)
+		return nil
+	}
+
+	t.mu.Lock()
+	sess, ok := t.sessions[id]
+	t.mu.Unlock()
+	if !ok {
+		// El cliente debe volver a inicializar
+		http.Error(w, "sesión desconocida o caducada", http.StatusNotFound)
+		return nil
+	}
+
+	if identity != nil {
+		current, _ := sess.Identity()
+		if current.Subject != identity.Subject {
+			slog.Warn("Autenticación fallida", "remote", r.RemoteAddr, "sesion", id, "motivo", "la sesión pertenece a otra identidad")
+			http.Error(w, "la sesión pertenece a otra identidad", http.StatusForbidden)
+			return nil
+		}
+		// Un token renovado puede traer otros scopes
+		sess.setIdentity(*identity)
+	}
+	return sess
+}
+
+// handleStream envía por SSE los mensajes que inicia el servidor. Solo se
+// admite un stream por sesión.
+func (t *httpTransport) handleStream(w http.ResponseWriter, r *http.Request, identity *Identity) {
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		http.Error(w, "se requiere Accept: text/event-stream", http.StatusNotAcceptable)
+		return
+	}
+	sess := t.session(w, r, identity)
+	if sess == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming no soportado", http.StatusInternalServerError)
+		return
+	}
+
+	sess.events.mu.Lock()
+	if sess.events.streaming {
+		sess.events.mu.Unlock()
+		http.Error(w, "la sesión ya tiene un stream abierto", http.StatusConflict)
+		return
+	}
+	sess.events.streaming = true
+	sess.events.mu.Unlock()
+	defer func() {
+		sess.events.mu.Lock()
+		sess.events.streaming = false
+		sess.events.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sess.ctx.Done():
+			return
+		case message := <-sess.events.ch:
+			sess.touch()
+			if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", message); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (t *httpTransport) closeSession(sess *httpSession, reason string) {
+	t.mu.Lock()
+	delete(t.sessions, sess.id)
+	t.mu.Unlock()
+
+	t.server.removeSession(sess.Session)
+	sess.close()
+	sess.logger.Info("Sesión HTTP cerrada", "sesion", sess.id, "motivo", reason)
+}
+
+// expireSessions descarta periódicamente las sesiones inactivas hasta que ctx
+// termina
+func (t *httpTransport) expireSessions(ctx context.Context) {
+	ticker := time.NewTicker(t.sessionTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			var idle []*httpSession
+			for _, sess := range t.sessions {
+				if time.Since(sess.idleSince()) > t.sessionTimeout {
+					idle = append(idle, sess)
+				}
+			}
+			t.mu.Unlock()
+
+			for _, sess := range idle {
+				t.closeSession(sess, "inactiva")
+			}
+		}
+	}
+}
+
+// serveHTTP atiende el transporte HTTP en el puerto indicado, en la interfaz
+// de HTTP_HOST, hasta que falla el listener
+func (s *Server) serveHTTP(port string, oauth *OAuthConfig) error {
+	t := newHTTPTransport(s, oauth)
+	t.sessionTimeout = durationFromEnv("HTTP_SESSION_TIMEOUT", defaultHTTPSessionTimeout)
+	for _, origin := range strings.Split(os.Getenv("HTTP_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			t.allowedOrigins = append(t.allowedOrigins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	addr := net.JoinHostPort(getEnv("HTTP_HOST", defaultHTTPHost), port)
+	if t.sessionTimeout > 0 {
+		go t.expireSessions(context.Background())
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           t.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+func writeJSON(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func newSessionID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// httpTestClient envía mensajes JSON-RPC al transporte HTTP con un token y
+// la sesión obtenida en initialize
+type httpTestClient struct {
+	t       *testing.T
+	url     string
+	token   string
+	session string
+}
+
+func (c *httpTestClient) post(message MCPMessage) (*http.Response, MCPMessage) {
+	c.t.Helper()
+
+	body, _ := json.Marshal(message)
+	req, _ := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.session != "" {
+		req.Header.Set(mcpSessionHeader, c.session)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("Error en la petición: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response MCPMessage
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp, response
+}
+
+func (c *httpTestClient) initialize() {
+	c.t.Helper()
+	resp, response := c.post(MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize", Params: map[string]interface{}{"protocolVersion": latestProtocolVersion}})
+	if resp.StatusCode != http.StatusOK || response.Error != nil {
+		c.t.Fatalf("initialize falló: %d %+v", resp.StatusCode, response.Error)
+	}
+	c.session = resp.Header.Get(mcpSessionHeader)
+	if c.session == "" {
+		c.t.Fatal("initialize debería devolver Mcp-Session-Id")
+	}
+}
+
+func (c *httpTestClient) toolNames() []string {
+	c.t.Helper()
+	_, response := c.post(MCPMessage{JsonRPC: "2.0", ID: 2, Method: "tools/list"})
+	result, _ := response.Result.(map[string]interface{})
+	tools, _ := result["tools"].([]interface{})
+
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+func TestHTTPTransportSessions(t *testing.T) {
+	s := &Server{}
+	server := httptest.NewServer(newHTTPTransport(s, nil).handler())
+	defer server.Close()
+	client := &httpTestClient{t: t, url: server.URL + httpEndpointPath}
+
+	// Sin sesión solo se admite initialize
+	if resp, _ := client.post(MCPMessage{JsonRPC: "2.0", ID: 1, Method: "tools/list"}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Sin Mcp-Session-Id se esperaba 400: %d", resp.StatusCode)
+	}
+
+	client.initialize()
+	if resp, _ := client.post(MCPMessage{JsonRPC: "2.0", Method: "notifications/initialized"}); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Una notificación se responde con 202: %d", resp.StatusCode)
+	}
+	if len(client.toolNames()) == 0 {
+		t.Error("tools/list debería devolver herramientas")
+	}
+
+	// Cerrar la sesión obliga a inicializar de nuevo
+	req, _ := http.NewRequest(http.MethodDelete, client.url, nil)
+	req.Header.Set(mcpSessionHeader, client.session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE falló: %v %v", err, resp)
+	}
+	if resp, _ := client.post(MCPMessage{JsonRPC: "2.0", ID: 3, Method: "ping"}); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Una sesión cerrada debería dar 404: %d", resp.StatusCode)
+	}
+	if len(s.activeSessions()) != 0 {
+		t.Error("La sesión cerrada sigue registrada")
+	}
+}
+
+func TestHTTPRejectsInvalidJSON(t *testing.T) {
+	server := httptest.NewServer(newHTTPTransport(&Server{}, nil).handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+httpEndpointPath, "application/json", strings.NewReader(`{"jsonrpc": "2.0", "id": 1,`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response MCPMessage
+	json.NewDecoder(resp.Body).Decode(&response)
+	if resp.StatusCode != http.StatusBadRequest || response.Error == nil || response.Error.Code != -32700 {
+		t.Errorf("Un cuerpo que no es JSON es un error de parsing: %d %+v", resp.StatusCode, response.Error)
+	}
+}
+
+func TestHTTPChecksOrigin(t *testing.T) {
+	transport := newHTTPTransport(&Server{}, nil)
+	server := httptest.NewServer(transport.handler())
+	defer server.Close()
+
+	status := func(origin string) int {
+		body, _ := json.Marshal(MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize", Params: map[string]interface{}{"protocolVersion": latestProtocolVersion}})
+		req, _ := http.NewRequest(http.MethodPost, server.URL+httpEndpointPath, bytes.NewReader(body))
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for origin, expected := range map[string]int{
+		"":                      http.StatusOK,
+		"http://localhost:6274": http.StatusOK,
+		"http://127.0.0.1":      http.StatusOK,
+		"https://evil.example":  http.StatusForbidden,
+	} {
+		if got := status(origin); got != expected {
+			t.Errorf("Origin %q: %d, esperado %d", origin, got, expected)
+		}
+	}
+
+	transport.allowedOrigins = []string{"https://app.example"}
+	if got := status("https://app.example"); got != http.StatusOK {
+		t.Errorf("Un origen configurado se admite: %d", got)
+	}
+	if got := status("http://localhost:6274"); got != http.StatusForbidden {
+		t.Errorf("Con orígenes configurados localhost ya no se admite: %d", got)
+	}
+}
+
+func TestHTTPStreamDeliversServerMessages(t *testing.T) {
+	s := &Server{}
+	server := httptest.NewServer(newHTTPTransport(s, nil).handler())
+	defer server.Close()
+	client := &httpTestClient{t: t, url: server.URL + httpEndpointPath}
+	client.initialize()
+	client.post(MCPMessage{JsonRPC: "2.0", Method: "notifications/initialized"})
+
+	req, _ := http.NewRequest(http.MethodGet, client.url, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(mcpSessionHeader, client.session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET del stream falló: %v %v", err, resp)
+	}
+	defer resp.Body.Close()
+
+	s.notifyToolListChanged()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "data: ") && strings.Contains(line, "notifications/tools/list_changed") {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("La notificación no llegó por el stream SSE")
+		}
+	}
+}
+
+func TestHTTPTransportRequiresBearerToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	cfg := newTestOAuthConfig(t, issuer)
+
+	s := &Server{}
+	server := httptest.NewServer(newHTTPTransport(s, cfg).handler())
+	defer server.Close()
+	client := &httpTestClient{t: t, url: server.URL + httpEndpointPath}
+
+	resp, _ := client.post(MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(resp.Header.Get("WWW-Authenticate"), "resource_metadata=") {
+		t.Errorf("Sin token se esperaba 401 con resource_metadata: %d %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	client.token = issuer.token(cfg.Audience, "otro:scope", nil)
+	if resp, _ := client.post(MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Sin scopes del servidor se esperaba 403: %d", resp.StatusCode)
+	}
+
+	// Un token de solo lectura no ve add_student
+	client.token = issuer.token(cfg.Audience, scopeRead, nil)
+	client.initialize()
+	for _, name := range client.toolNames() {
+		if name == "add_student" {
+			t.Error("add_student requiere students:write")
+		}
+	}
+	_, response := client.post(MCPMessage{
+		JsonRPC: "2.0",
+		ID:      3,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "add_student", "arguments": map[string]interface{}{"name": "X"}},
+	})
+	if response.Error == nil || response.Error.Code != -32602 {
+		t.Errorf("add_student debería rechazarse: %+v", response.Error)
+	}
+
+	// La sesión no se puede usar con el token de otra persona
+	client.token = issuer.token(cfg.Audience, scopeRead, map[string]interface{}{"sub": "alumno.perez"})
+	if resp, _ := client.post(MCPMessage{JsonRPC: "2.0", ID: 4, Method: "ping"}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Otra identidad debería recibir 403: %d", resp.StatusCode)
+	}
+
+	// Los metadatos del recurso protegido son públicos
+	metadata, err := http.Get(server.URL + protectedResourcePath + httpEndpointPath)
+	if err != nil || metadata.StatusCode != http.StatusOK {
+		t.Errorf("Metadatos no disponibles: %v %v", err, metadata)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Valores por defecto del keepalive de los transportes de red (TCP y HTTP)
+const (
+	defaultKeepaliveInterval = 30 * time.Second
+	defaultKeepaliveTimeout  = 10 * time.Second
+)
+
+// KeepaliveConfig controla los ping que el servidor envía a clientes inactivos.
+// Un intervalo 0 desactiva el keepalive.
+type KeepaliveConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// loadKeepaliveConfig lee KEEPALIVE_INTERVAL y KEEPALIVE_TIMEOUT (por ejemplo
+// "30s"). Los valores inválidos se ignoran y se usa el valor por defecto.
+func loadKeepaliveConfig() KeepaliveConfig {
+	return KeepaliveConfig{
+		Interval: durationFromEnv("KEEPALIVE_INTERVAL", defaultKeepaliveInterval),
+		Timeout:  durationFromEnv("KEEPALIVE_TIMEOUT", defaultKeepaliveTimeout),
+	}
+}
+
+func durationFromEnv(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		slog.Warn("Valor de configuración inválido, usando el valor por defecto", "variable", key, "valor", value, "defecto", defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// keepalive envía ping al cliente cuando lleva un intervalo sin actividad y
+// llama a onTimeout si no responde a tiempo. Termina al cerrarse la sesión.
+func (sess *Session) keepalive(cfg KeepaliveConfig, onTimeout func()) {
+	if cfg.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sess.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(sess.idleSince()) < cfg.Interval {
+			continue
+		}
+		// Un ping que el cliente no puede recibir cerraría una sesión viva
+		if sess.reachable != nil && !sess.reachable() {
+			continue
+		}
+
+		if err := sess.ping(cfg.Timeout); err != nil {
+			if sess.ctx.Err() != nil {
+				return
+			}
+			sess.logger.Warn("Cliente sin respuesta al ping, cerrando sesión", "cliente", sess.String(), "error", err)
+			onTimeout()
+			return
+		}
+	}
+}
+
+// ping envía un ping al cliente y espera la respuesta como mucho timeout
+func (sess *Session) ping(timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	_, err := sess.request(ctx, "ping", nil)
+	return err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestClientPing(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportTCP, "127.0.0.1:5000", io.Discard)
+
+	// ping se acepta incluso antes de initialize
+	response := processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 1, Method: "ping"})
+	if response.Error != nil {
+		t.Fatalf("Error en respuesta a ping: %v", response.Error)
+	}
+	if result, ok := response.Result.(map[string]interface{}); !ok || len(result) != 0 {
+		t.Errorf("ping debe responder con un objeto vacío: %v", response.Result)
+	}
+}
+
+func TestServerPingAnswered(t *testing.T) {
+	s := &Server{}
+	reader, writer := io.Pipe()
+	sess := newSession(transportTCP, "127.0.0.1:5000", writer)
+	defer sess.close()
+
+	// Cliente de prueba que responde a todas las peticiones del servidor
+	go func() {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			var msg MCPMessage
+			json.Unmarshal(scanner.Bytes(), &msg)
+			reply, _ := json.Marshal(MCPMessage{JsonRPC: "2.0", ID: msg.ID, Result: map[string]interface{}{}})
+			s.processMessage(context.Background(), sess, reply)
+		}
+	}()
+
+	if err := sess.ping(time.Second); err != nil {
+		t.Fatalf("El ping debería responderse: %v", err)
+	}
+}
+
+func TestKeepaliveClosesUnresponsiveSession(t *testing.T) {
+	sess := newSession(transportTCP, "127.0.0.1:5000", io.Discard)
+	defer sess.close()
+
+	timedOut := make(chan struct{})
+	go sess.keepalive(KeepaliveConfig{Interval: 10 * time.Millisecond, Timeout: 20 * time.Millisecond}, func() {
+		close(timedOut)
+	})
+
+	select {
+	case <-timedOut:
+	case <-time.After(time.Second):
+		t.Fatal("El keepalive debería cerrar una sesión que no responde")
+	}
+}
+
+func TestKeepaliveWaitsForReachableClient(t *testing.T) {
+	// Una sesión HTTP sin stream SSE no puede recibir el ping
+	sess := newSession(transportHTTP, "127.0.0.1:5000", io.Discard)
+	sess.reachable = func() bool { return false }
+	defer sess.close()
+
+	timedOut := make(chan struct{})
+	go sess.keepalive(KeepaliveConfig{Interval: 10 * time.Millisecond, Timeout: 20 * time.Millisecond}, func() {
+		close(timedOut)
+	})
+
+	select {
+	case <-timedOut:
+		t.Error("No se envía ping a un cliente que no puede recibirlo")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUnexpectedResponseIgnored(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportStdio, "", io.Discard)
+
+	reply, _ := json.Marshal(MCPMessage{JsonRPC: "2.0", ID: "srv-99", Result: map[string]interface{}{}})
+	if out := s.processMessage(context.Background(), sess, reply); len(out) != 0 {
+		t.Errorf("Una respuesta del cliente no lleva respuesta: %s", out)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Niveles de log de MCP (RFC 5424) y su equivalente en slog
+var mcpLogLevels = []struct {
+	name  string
+	level slog.Level
+}{
+	{"debug", slog.LevelDebug},
+	{"info", slog.LevelInfo},
+	{"notice", slog.LevelInfo + 2},
+	{"warning", slog.LevelWarn},
+	{"error", slog.LevelError},
+	{"critical", slog.LevelError + 4},
+	{"alert", slog.LevelError + 8},
+	{"emergency", slog.LevelError + 12},
+}
+
+// Nivel mínimo que se envía al cliente mientras no llame a logging/setLevel
+const defaultClientLogLevel = slog.LevelWarn
+
+func parseMCPLogLevel(name string) (slog.Level, bool) {
+	for _, l := range mcpLogLevels {
+		if l.name == name {
+			return l.level, true
+		}
+	}
+	return 0, false
+}
+
+// mcpLogLevelName traduce un nivel de slog al nivel MCP más alto que no lo supera
+func mcpLogLevelName(level slog.Level) string {
+	name := mcpLogLevels[0].name
+	for _, l := range mcpLogLevels {
+		if level >= l.level {
+			name = l.name
+		}
+	}
+	return name
+}
+
+// newLogger crea el logger del servidor. Escribe en LOG_FILE si está definido
+// y si no en stderr, que no interfiere con el canal stdio. LOG_LEVEL acepta
+// debug, info, warn o error.
+func newLogger() (*slog.Logger, io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		return nil, nil, fmt.Errorf("LOG_LEVEL inválido: %v", err)
+	}
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer = io.NopCloser(nil)
+	if path := os.Getenv("LOG_FILE"); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("no se pudo abrir LOG_FILE: %v", err)
+		}
+		out, closer = file, file
+	}
+
+	return slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: level})), closer, nil
+}
+
+// setLogLevel atiende logging/setLevel
+func (sess *Session) setLogLevel(name string) error {
+	level, ok := parseMCPLogLevel(strings.ToLower(name))
+	if !ok {
+		return fmt.Errorf("nivel de log desconocido: %s", name)
+	}
+
+	sess.mu.Lock()
+	sess.clientLogLevel = level
+	sess.mu.Unlock()
+	return nil
+}
+
+// sendsLogsAt indica si un mensaje de ese nivel debe enviarse al cliente
+func (sess *Session) sendsLogsAt(level slog.Level) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.state != sessionCreated && level >= sess.clientLogLevel
+}
+
+// clientLogHandler convierte los registros de slog en notifications/message
+type clientLogHandler struct {
+	sess   *Session
+	attrs  []slog.Attr
+	prefix string
+}
+
+func (h *clientLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.sess.sendsLogsAt(level)
+}
+
+func (h *clientLogHandler) Handle(_ context.Context, r slog.Record) error {
+	data := map[string]interface{}{"message": r.Message}
+	for _, a := range h.attrs {
+		data[a.Key] = a.Value.Resolve().Any()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		data[h.prefix+a.Key] = a.Value.Resolve().Any()
+		return true
+	})
+
+	// Los errores no se serializan a JSON por sí solos
+	for key, value := range data {
+		if err, ok := value.(error); ok {
+			data[key] = err.Error()
+		}
+	}
+
+	return h.sess.notify("notifications/message", map[string]interface{}{
+		"level":  mcpLogLevelName(r.Level),
+		"logger": serverName,
+		"data":   data,
+	})
+}
+
+func (h *clientLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+func (h *clientLogHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
+
+// teeHandler envía cada registro a todos los handlers que lo acepten
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
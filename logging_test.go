@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestMCPLogLevelMapping(t *testing.T) {
+	cases := map[slog.Level]string{
+		slog.LevelDebug:      "debug",
+		slog.LevelInfo:       "info",
+		slog.LevelWarn:       "warning",
+		slog.LevelError:      "error",
+		slog.LevelError + 12: "emergency",
+	}
+	for level, expected := range cases {
+		if got := mcpLogLevelName(level); got != expected {
+			t.Errorf("Nivel %v: esperado %s, obtenido %s", level, expected, got)
+		}
+	}
+
+	if _, ok := parseMCPLogLevel("verbose"); ok {
+		t.Error("'verbose' no es un nivel MCP")
+	}
+}
+
+// Test helper para leer las notifications/message escritas por una sesión
+func readLogNotifications(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var params []map[string]interface{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var msg MCPMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatalf("Mensaje inválido: %s", scanner.Bytes())
+		}
+		if msg.Method == "notifications/message" {
+			params = append(params, msg.Params.(map[string]interface{}))
+		}
+	}
+	return params
+}
+
+func TestSetLevelControlsClientLogs(t *testing.T) {
+	s := &Server{}
+	var out bytes.Buffer
+	sess := newSession(transportStdio, "", &out)
+	processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+
+	// Por defecto solo llegan advertencias y errores
+	sess.logger.Info("no debería enviarse")
+	sess.logger.Error("fallo de prueba", "herramienta", "list_students")
+
+	logs := readLogNotifications(t, &out)
+	if len(logs) != 1 {
+		t.Fatalf("Se esperaba 1 notificación, obtenidas %d", len(logs))
+	}
+	if logs[0]["level"] != "error" || logs[0]["logger"] != serverName {
+		t.Errorf("Notificación incorrecta: %v", logs[0])
+	}
+	data := logs[0]["data"].(map[string]interface{})
+	if data["message"] != "fallo de prueba" || data["herramienta"] != "list_students" {
+		t.Errorf("Datos incorrectos: %v", data)
+	}
+
+	response := processTestMessage(t, s, sess, MCPMessage{
+		JsonRPC: "2.0",
+		ID:      2,
+		Method:  "logging/setLevel",
+		Params:  map[string]interface{}{"level": "debug"},
+	})
+	if response.Error != nil {
+		t.Fatalf("Error en logging/setLevel: %v", response.Error)
+	}
+	out.Reset()
+
+	sess.logger.Debug("detalle")
+	if logs := readLogNotifications(t, &out); len(logs) != 1 || logs[0]["level"] != "debug" {
+		t.Errorf("Con nivel debug deberían llegar los mensajes de depuración: %v", logs)
+	}
+}
+
+func TestSetLevelRejectsUnknownLevel(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportStdio, "", io.Discard)
+	processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+
+	response := processTestMessage(t, s, sess, MCPMessage{
+		JsonRPC: "2.0",
+		ID:      2,
+		Method:  "logging/setLevel",
+		Params:  map[string]interface{}{"level": "verbose"},
+	})
+	if response.Error == nil || response.Error.Code != -32602 {
+		t.Errorf("Se esperaba error -32602: %+v", response.Error)
+	}
+}
+
+func TestNoClientLogsBeforeInitialize(t *testing.T) {
+	var out bytes.Buffer
+	sess := newSession(transportStdio, "", &out)
+
+	sess.logger.Error("antes de initialize")
+	if out.Len() != 0 {
+		t.Errorf("No deben enviarse logs antes de initialize: %s", out.String())
+	}
+}
@@ -2,14 +2,19 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
-	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -17,11 +22,36 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Identificación del servidor en initialize y en los logs enviados al cliente
+const (
+	serverName    = "mongodb-student-server"
+	serverVersion = "1.0.0"
+)
+
 // Estructura para representar un alumno
 type Student struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Name     string             `bson:"name" json:"name"`
-	Subjects map[string]float64 `bson:"subjects" json:"subjects"`
+	ID   primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name string             `bson:"name" json:"name"`
+	// Nota de cada asignatura; en las que tienen evaluaciones se calcula a
+	// partir de ellas. MarshalBSON y UnmarshalBSON guardan ambos campos en
+	// "subjects".
+	Subjects    map[string]float64      `bson:"-" json:"subjects"`
+	Assessments map[string][]Assessment `bson:"-" json:"assessments,omitempty"`
+	// Evaluación en la que se puso cada nota sin evaluaciones (add_student,
+	// set_grade); con ella cuenta en las consultas por evaluación
+	GradePeriods map[string]Period `bson:"grade_periods,omitempty" json:"-"`
+	// Notas definitivas de las evaluaciones cerradas: curso, evaluación y asignatura
+	TermGrades map[string]map[string]map[string]float64 `bson:"term_grades,omitempty" json:"term_grades,omitempty"`
+	// Datos personales opcionales
+	Profile *Profile `bson:"profile,omitempty" json:"profile,omitempty"`
+	// Versión del formato del documento; ver studentSchemaVersion
+	SchemaVersion int `bson:"schema_version" json:"-"`
+	// Claves para buscar duplicados con índices: las palabras normalizadas
+	// del nombre, que MarshalBSON calcula siempre, y el nombre normalizado,
+	// que se guarda al añadir un estudiante sin allow_duplicate y un índice
+	// único impide repetir
+	NameWords []string `bson:"name_words,omitempty" json:"-"`
+	NameKey   string   `bson:"name_key,omitempty" json:"-"`
 }
 
 // Estructura para el protocolo MCP
@@ -35,26 +65,47 @@ type MCPMessage struct {
 }
 
 type MCPError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
 type Tool struct {
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	InputSchema interface{} `json:"inputSchema"`
-}
-
-type ToolSchema struct {
-	Type       string                 `json:"type"`
-	Properties map[string]interface{} `json:"properties"`
-	Required   []string               `json:"required,omitempty"`
+	Name        string           `json:"name"`
+	Title       string           `json:"title,omitempty"`
+	Description string           `json:"description"`
+	InputSchema interface{}      `json:"inputSchema"`
+	Annotations *ToolAnnotations `json:"annotations,omitempty"`
 }
 
 type Server struct {
 	client     *mongo.Client
 	database   *mongo.Database
 	collection *mongo.Collection
+	keepalive  KeepaliveConfig
+	auth       AuthConfig
+
+	toolsOnce sync.Once
+	tools     *ToolRegistry
+
+	mu       sync.Mutex
+	sessions map[*Session]struct{}
+	readOnly bool
+	policies map[string]ToolPolicy
+	// Roles por sujeto autenticado; nil desactiva el control de acceso por roles
+	roles map[string]Role
+	// nil si la auditoría está desactivada
+	audit AuditLog
+	// Historial de notas; nil si no hay base de datos
+	history *mongo.Collection
+	// Evaluaciones cerradas
+	terms *mongo.Collection
+	// Grupos de clase y sus estudiantes
+	groups *mongo.Collection
+	// Catálogo de asignaturas
+	subjects *mongo.Collection
+	// Asistencia a clase
+	attendance *mongo.Collection
 }
 
 func NewServer(mongoURI, dbName, collectionName string) (*Server, error) {
@@ -72,10 +123,31 @@ func NewServer(mongoURI, dbName, collectionName string) (*Server, error) {
 	database := client.Database(dbName)
 	collection := database.Collection(collectionName)
 
+	history := database.Collection(getEnv("GRADE_HISTORY_COLLECTION", defaultHistoryCollection))
+	if err := ensureHistoryIndexes(context.TODO(), history); err != nil {
+		return nil, fmt.Errorf("error creando los índices del historial de notas: %v", err)
+	}
+	if err := ensureStudentIndexes(context.TODO(), collection); err != nil {
+		return nil, fmt.Errorf("error creando los índices de estudiantes (¿números de expediente repetidos?): %v", err)
+	}
+	if err := pinUndatedGrades(context.TODO(), collection, history, time.Now()); err != nil {
+		return nil, fmt.Errorf("error asignando una evaluación a las notas antiguas: %v", err)
+	}
+	attendance := database.Collection(getEnv("ATTENDANCE_COLLECTION", defaultAttendanceCollection))
+	if err := ensureAttendanceIndexes(context.TODO(), attendance); err != nil {
+		return nil, fmt.Errorf("error creando los índices de asistencia: %v", err)
+	}
+
 	return &Server{
 		client:     client,
 		database:   database,
 		collection: collection,
+		audit:      &mongoAuditLog{collection: database.Collection(getEnv("AUDIT_COLLECTION", defaultAuditCollection))},
+		history:    history,
+		terms:      database.Collection(getEnv("TERMS_COLLECTION", defaultTermsCollection)),
+		groups:     database.Collection(getEnv("GROUPS_COLLECTION", defaultGroupsCollection)),
+		subjects:   database.Collection(getEnv("SUBJECTS_COLLECTION", defaultSubjectsCollection)),
+		attendance: attendance,
 	}, nil
 }
 
@@ -83,154 +155,516 @@ func (s *Server) Close() error {
 	return s.client.Disconnect(context.TODO())
 }
 
+// Argumentos de las herramientas. El esquema de entrada se genera a partir de
+// las etiquetas json y description.
+type studentGradesArgs struct {
+	studentRefArgs
+	AsOf string `json:"as_of,omitempty" description:"Devuelve las notas que tenía en esa fecha (AAAA-MM-DD) o fecha y hora (RFC 3339)"`
+	Term string `json:"term,omitempty" description:"Evaluación: 1, 2, 3 o final. Sin ella, las notas actuales" jsonschema:"enum=1|2|3|final"`
+	Year string `json:"year,omitempty" description:"Curso de la evaluación (por ejemplo 2024-2025); por defecto el actual" jsonschema:"pattern=^[0-9]{4}-[0-9]{4}$"`
+}
+
+type studentTermArgs struct {
+	studentRefArgs
+	Term string `json:"term,omitempty" description:"Evaluación: 1, 2, 3 o final. Sin ella, las notas actuales" jsonschema:"enum=1|2|3|final"`
+	Year string `json:"year,omitempty" description:"Curso de la evaluación (por ejemplo 2024-2025); por defecto el actual" jsonschema:"pattern=^[0-9]{4}-[0-9]{4}$"`
+}
+
+type subjectTermArgs struct {
+	Subject string `json:"subject" description:"Nombre de la asignatura" jsonschema:"minLength=1,maxLength=100"`
+	Group   string `json:"group,omitempty" description:"Limita el resultado a los estudiantes de un grupo" jsonschema:"maxLength=100"`
+	Term    string `json:"term,omitempty" description:"Evaluación: 1, 2, 3 o final. Sin ella, las notas actuales" jsonschema:"enum=1|2|3|final"`
+	Year    string `json:"year,omitempty" description:"Curso de la evaluación (por ejemplo 2024-2025); por defecto el actual" jsonschema:"pattern=^[0-9]{4}-[0-9]{4}$"`
+}
+
+type gradeHistoryArgs struct {
+	studentRefArgs
+	Subject string `json:"subject,omitempty" description:"Limita el historial a una asignatura" jsonschema:"maxLength=100"`
+}
+
+type setGradeArgs struct {
+	studentRefArgs
+	Subject string  `json:"subject" description:"Nombre de la asignatura" jsonschema:"minLength=1,maxLength=100"`
+	Grade   float64 `json:"grade" description:"Nueva nota" jsonschema:"minimum=0,maximum=10"`
+	Reason  string  `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+type subjectArgs struct {
+	Subject string `json:"subject" description:"Nombre de la asignatura" jsonschema:"minLength=1,maxLength=100"`
+}
+
+type addStudentArgs struct {
+	Name     string             `json:"name" description:"Nombre del estudiante" jsonschema:"minLength=1,maxLength=200"`
+	Subjects map[string]float64 `json:"subjects,omitempty" description:"Asignaturas y notas del estudiante (formato: {\"matematicas\": 8.5, \"historia\": 9.0}; también se aceptan como texto, \"8.5\"). Si se omite y el cliente soporta elicitación, se piden al usuario" values:"minimum=0,maximum=10,numericString"`
+	Profile  *Profile           `json:"profile,omitempty" description:"Datos personales (opcionales)"`
+	// Un nombre igual o parecido al de otro estudiante se rechaza salvo que se confirme
+	AllowDuplicate bool   `json:"allow_duplicate,omitempty" description:"Añadirlo aunque ya haya un estudiante con el mismo nombre o uno parecido"`
+	Reason         string `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
 // Herramientas disponibles
 func (s *Server) getTools() []Tool {
-	return []Tool{
-		{
-			Name:        "list_students",
-			Description: "Lista todos los estudiantes en la base de datos",
-			InputSchema: ToolSchema{
-				Type:       "object",
-				Properties: map[string]interface{}{},
-			},
-		},
-		{
-			Name:        "get_student_by_name",
-			Description: "Busca un estudiante por su nombre",
-			InputSchema: ToolSchema{
-				Type: "object",
-				Properties: map[string]interface{}{
-					"name": map[string]interface{}{
-						"type":        "string",
-						"description": "Nombre del estudiante a buscar",
-					},
-				},
-				Required: []string{"name"},
-			},
-		},
-		{
-			Name:        "get_student_grades",
-			Description: "Obtiene las notas de un estudiante específico",
-			InputSchema: ToolSchema{
-				Type: "object",
-				Properties: map[string]interface{}{
-					"name": map[string]interface{}{
-						"type":        "string",
-						"description": "Nombre del estudiante",
-					},
-				},
-				Required: []string{"name"},
-			},
-		},
-		{
-			Name:        "get_subject_grades",
-			Description: "Obtiene todas las notas de una asignatura específica",
-			InputSchema: ToolSchema{
-				Type: "object",
-				Properties: map[string]interface{}{
-					"subject": map[string]interface{}{
-						"type":        "string",
-						"description": "Nombre de la asignatura",
-					},
-				},
-				Required: []string{"subject"},
-			},
-		},
-		{
-			Name:        "calculate_student_average",
-			Description: "Calcula el promedio de notas de un estudiante",
-			InputSchema: ToolSchema{
-				Type: "object",
-				Properties: map[string]interface{}{
-					"name": map[string]interface{}{
-						"type":        "string",
-						"description": "Nombre del estudiante",
-					},
-				},
-				Required: []string{"name"},
-			},
-		},
-		{
-			Name:        "add_student",
-			Description: "Añade un nuevo estudiante a la base de datos",
-			InputSchema: ToolSchema{
-				Type: "object",
-				Properties: map[string]interface{}{
-					"name": map[string]interface{}{
-						"type":        "string",
-						"description": "Nombre del estudiante",
-					},
-					"subjects": map[string]interface{}{
-						"type":        "object",
-						"description": "Asignaturas y notas del estudiante (formato: {\"matematicas\": 8.5, \"historia\": 9.0})",
-					},
-				},
-				Required: []string{"name", "subjects"},
-			},
-		},
-	}
+	return s.toolRegistry().Tools()
+}
+
+func (s *Server) toolRegistry() *ToolRegistry {
+	s.toolsOnce.Do(func() {
+		s.tools = s.registerTools()
+	})
+	return s.tools
+}
+
+// registerTools declara cada herramienta con sus argumentos tipados y su implementación
+func (s *Server) registerTools() *ToolRegistry {
+	r := newToolRegistry()
+
+	registerTool(r, Tool{
+		Name:        "list_students",
+		Title:       "Listar estudiantes",
+		Description: "Lista todos los estudiantes en la base de datos, o los de un grupo",
+		Annotations: readOnlyTool("Listar estudiantes"),
+	}, func(ctx context.Context, req *ToolRequest, args groupFilterArgs) (interface{}, error) {
+		return s.listStudents(ctx, req.Role, args.Group, req.Progress)
+	})
+
+	registerTool(r, Tool{
+		Name:        "get_student_by_name",
+		Title:       "Buscar estudiante por nombre",
+		Description: "Busca un estudiante por su nombre o su id. Si hay varios con el mismo nombre, devuelve los candidatos para elegir uno por su id",
+		Annotations: readOnlyTool("Buscar estudiante por nombre"),
+	}, func(ctx context.Context, req *ToolRequest, args studentRefArgs) (interface{}, error) {
+		return s.getStudent(ctx, req.Session, req.Role, args.ref())
+	})
+
+	registerTool(r, Tool{
+		Name:        "get_student_grades",
+		Title:       "Notas de un estudiante",
+		Description: "Obtiene las notas de un estudiante específico, las actuales o las que tenía en una fecha",
+		Annotations: readOnlyTool("Notas de un estudiante"),
+	}, func(ctx context.Context, req *ToolRequest, args studentGradesArgs) (interface{}, error) {
+		period, err := newPeriod(args.Year, args.Term, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if args.AsOf == "" {
+			return s.getStudentGrades(ctx, req.Session, req.Role, args.ref(), period)
+		}
+		if !period.IsZero() {
+			return nil, &InvalidParamsError{Message: "argumento '/as_of': no se puede combinar con 'term'", Pointer: "/as_of"}
+		}
+		asOf, err := parseAsOf(args.AsOf)
+		if err != nil {
+			return nil, err
+		}
+		return s.getStudentGradesAsOf(ctx, req.Session, req.Role, args.ref(), asOf)
+	})
+
+	registerTool(r, Tool{
+		Name:        "get_grade_history",
+		Title:       "Historial de notas",
+		Description: "Muestra la evolución de las notas de un estudiante: cada cambio con su versión, fecha, autor y nota anterior",
+		Annotations: readOnlyTool("Historial de notas"),
+	}, func(ctx context.Context, req *ToolRequest, args gradeHistoryArgs) (interface{}, error) {
+		return s.getGradeHistory(ctx, req.Session, req.Role, args.ref(), args.Subject)
+	})
+
+	registerTool(r, Tool{
+		Name:        "get_subject_grades",
+		Title:       "Notas de una asignatura",
+		Description: "Obtiene todas las notas de una asignatura específica",
+		Annotations: readOnlyTool("Notas de una asignatura"),
+	}, func(ctx context.Context, req *ToolRequest, args subjectTermArgs) (interface{}, error) {
+		period, err := newPeriod(args.Year, args.Term, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return s.getSubjectGrades(ctx, req.Role, s.resolveSubject(ctx, args.Subject), args.Group, period, req.Progress)
+	})
+
+	registerTool(r, Tool{
+		Name:        "get_statistics",
+		Title:       "Estadísticas",
+		Description: "Calcula la media, la nota mínima y máxima y el porcentaje de aprobados de cada asignatura, de todos los estudiantes o de un grupo",
+		Annotations: readOnlyTool("Estadísticas"),
+	}, func(ctx context.Context, req *ToolRequest, args statisticsArgs) (interface{}, error) {
+		period, err := newPeriod(args.Year, args.Term, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		subject := args.Subject
+		if subject != "" {
+			subject = s.resolveSubject(ctx, subject)
+		}
+		return s.getStatistics(ctx, req.Role, subject, args.Group, period, req.Progress)
+	})
+
+	registerTool(r, Tool{
+		Name:        "list_subjects",
+		Title:       "Listar asignaturas",
+		Description: "Lista las asignaturas del catálogo (código, nombre, departamento, profesor, créditos y alias) y cuántos estudiantes tienen nota en cada una, además de las asignaturas de los datos que no están en el catálogo",
+		Annotations: readOnlyTool("Listar asignaturas"),
+	}, func(ctx context.Context, req *ToolRequest, _ struct{}) (interface{}, error) {
+		return s.listSubjects(ctx)
+	})
+
+	registerTool(r, Tool{
+		Name:        "save_subject",
+		Title:       "Guardar asignatura",
+		Description: "Añade una asignatura al catálogo o actualiza la existente con el mismo código",
+		Annotations: writeTool("Guardar asignatura", false, true),
+	}, func(ctx context.Context, req *ToolRequest, args saveSubjectArgs) (interface{}, error) {
+		return s.saveSubject(ctx, req, Subject{
+			Code:       args.Code,
+			Name:       args.Name,
+			Department: args.Department,
+			Teacher:    args.Teacher,
+			Credits:    args.Credits,
+			Aliases:    args.Aliases,
+		})
+	})
+
+	registerTool(r, Tool{
+		Name:        "list_groups",
+		Title:       "Listar grupos",
+		Description: "Lista los grupos de clase con su curso, su tutor y el número de estudiantes",
+		Annotations: readOnlyTool("Listar grupos"),
+	}, func(ctx context.Context, req *ToolRequest, _ struct{}) (interface{}, error) {
+		return s.listGroups(ctx)
+	})
+
+	registerTool(r, Tool{
+		Name:        "create_group",
+		Title:       "Crear grupo",
+		Description: "Crea un grupo de clase (por ejemplo 2º ESO B) con su tutor",
+		Annotations: writeTool("Crear grupo", false, false),
+	}, func(ctx context.Context, req *ToolRequest, args createGroupArgs) (interface{}, error) {
+		return s.createGroup(ctx, req, args.Name, args.Year, args.Tutor)
+	})
+
+	registerTool(r, Tool{
+		Name:        "enroll_student",
+		Title:       "Matricular estudiante",
+		Description: "Matricula a un estudiante en un grupo",
+		Annotations: writeTool("Matricular estudiante", false, false),
+	}, func(ctx context.Context, req *ToolRequest, args enrollmentArgs) (interface{}, error) {
+		return s.enrollStudent(ctx, req, args.Group, studentRef{ID: args.StudentID, Name: args.Student}, true)
+	})
+
+	registerTool(r, Tool{
+		Name:        "unenroll_student",
+		Title:       "Dar de baja de un grupo",
+		Description: "Da de baja a un estudiante de un grupo",
+		Annotations: writeTool("Dar de baja de un grupo", true, false),
+	}, func(ctx context.Context, req *ToolRequest, args enrollmentArgs) (interface{}, error) {
+		return s.enrollStudent(ctx, req, args.Group, studentRef{ID: args.StudentID, Name: args.Student}, false)
+	})
+
+	registerTool(r, Tool{
+		Name:        "calculate_student_average",
+		Title:       "Media de un estudiante",
+		Description: "Calcula el promedio de notas de un estudiante",
+		Annotations: readOnlyTool("Media de un estudiante"),
+	}, func(ctx context.Context, req *ToolRequest, args studentTermArgs) (interface{}, error) {
+		period, err := newPeriod(args.Year, args.Term, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return s.calculateStudentAverage(ctx, req.Session, req.Role, args.ref(), period)
+	})
+
+	registerTool(r, Tool{
+		Name:        "add_student",
+		Title:       "Añadir estudiante",
+		Description: "Añade un nuevo estudiante a la base de datos",
+		Annotations: writeTool("Añadir estudiante", false, false),
+	}, func(ctx context.Context, req *ToolRequest, args addStudentArgs) (interface{}, error) {
+		return s.addStudent(ctx, req, args.Name, args.Subjects, args.Profile, args.AllowDuplicate)
+	})
+
+	registerTool(r, Tool{
+		Name:        "update_student",
+		Title:       "Modificar estudiante",
+		Description: "Cambia el nombre o los datos personales de un estudiante (fecha de nacimiento, número de expediente, correo, tutores legales, observaciones)",
+		Annotations: writeTool("Modificar estudiante", true, true),
+	}, func(ctx context.Context, req *ToolRequest, args updateStudentArgs) (interface{}, error) {
+		return s.updateStudent(ctx, req, studentRef{ID: args.ID, Name: args.Name}, args.NewName, args.Profile, args.Clear)
+	})
+
+	registerTool(r, Tool{
+		Name:        "set_grade",
+		Title:       "Cambiar nota",
+		Description: "Pone o corrige la nota de un estudiante en una asignatura; la nota anterior queda en el historial",
+		Annotations: writeTool("Cambiar nota", false, true),
+	}, func(ctx context.Context, req *ToolRequest, args setGradeArgs) (interface{}, error) {
+		subject, err := s.canonicalSubject(ctx, args.Subject, "/subject")
+		if err != nil {
+			return nil, err
+		}
+		return s.setGrade(ctx, req, args.ref(), subject, args.Grade)
+	})
+
+	registerTool(r, Tool{
+		Name:        "record_assessment",
+		Title:       "Registrar evaluación",
+		Description: "Registra una evaluación (examen, trabajo, proyecto...) de un estudiante en una asignatura y recalcula la nota como media ponderada de sus evaluaciones",
+		Annotations: writeTool("Registrar evaluación", false, false),
+	}, func(ctx context.Context, req *ToolRequest, args recordAssessmentArgs) (interface{}, error) {
+		assessment, err := args.assessment(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		subject, err := s.canonicalSubject(ctx, args.Subject, "/subject")
+		if err != nil {
+			return nil, err
+		}
+		return s.recordAssessment(ctx, req, args.ref(), subject, assessment)
+	})
+
+	registerTool(r, Tool{
+		Name:        "close_term",
+		Title:       "Cerrar evaluación",
+		Description: "Cierra una evaluación (1, 2, 3 o final) de un curso: guarda las notas de cada estudiante en ese periodo como definitivas. La final es la media de las tres evaluaciones",
+		Annotations: writeTool("Cerrar evaluación", false, true),
+	}, func(ctx context.Context, req *ToolRequest, args closeTermArgs) (interface{}, error) {
+		period, err := newPeriod(args.Year, args.Term, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return s.closeTerm(ctx, req, period)
+	})
+
+	registerTool(r, Tool{
+		Name:        "list_assessments",
+		Title:       "Evaluaciones de un estudiante",
+		Description: "Lista las evaluaciones de un estudiante con la nota que resulta en cada asignatura",
+		Annotations: readOnlyTool("Evaluaciones de un estudiante"),
+	}, func(ctx context.Context, req *ToolRequest, args listAssessmentsArgs) (interface{}, error) {
+		return s.listAssessments(ctx, req.Session, req.Role, args.ref(), args.Subject)
+	})
+
+	registerTool(r, Tool{
+		Name:        "record_attendance",
+		Title:       "Registrar asistencia",
+		Description: "Registra si un estudiante asistió, faltó o llegó tarde a una asignatura un día; si ya había un registro, lo corrige",
+		Annotations: writeTool("Registrar asistencia", false, true),
+	}, func(ctx context.Context, req *ToolRequest, args recordAttendanceArgs) (interface{}, error) {
+		date, err := attendanceDate(args.Date, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		subject, err := s.canonicalSubject(ctx, args.Subject, "/subject")
+		if err != nil {
+			return nil, err
+		}
+		return s.recordAttendance(ctx, req, args.ref(), subject, date, args.Status, args.Note)
+	})
+
+	registerTool(r, Tool{
+		Name:        "get_absences",
+		Title:       "Consultar faltas",
+		Description: "Devuelve las faltas y retrasos de un estudiante, de un grupo o de todos en un periodo, con el detalle de cada día",
+		Annotations: readOnlyTool("Consultar faltas"),
+	}, func(ctx context.Context, req *ToolRequest, args absencesArgs) (interface{}, error) {
+		if args.Subject != "" {
+			args.Subject = s.resolveSubject(ctx, args.Subject)
+		}
+		return s.getAbsences(ctx, req.Session, req.Role, args)
+	})
+
+	registerTool(r, Tool{
+		Name:        "flag_absences",
+		Title:       "Estudiantes con muchas faltas",
+		Description: "Señala a los estudiantes que alcanzan un número de faltas en un periodo, opcionalmente contando los retrasos",
+		Annotations: readOnlyTool("Estudiantes con muchas faltas"),
+	}, func(ctx context.Context, req *ToolRequest, args flagAbsencesArgs) (interface{}, error) {
+		if args.Subject != "" {
+			args.Subject = s.resolveSubject(ctx, args.Subject)
+		}
+		return s.flagAbsences(ctx, req.Role, args)
+	})
+
+	registerTool(r, Tool{
+		Name:        "list_recent_changes",
+		Title:       "Cambios recientes",
+		Description: "Lista los últimos cambios hechos con herramientas de escritura, con su id para poder deshacerlos con revert_change",
+		Annotations: readOnlyTool("Cambios recientes"),
+	}, func(ctx context.Context, req *ToolRequest, args recentChangesArgs) (interface{}, error) {
+		return s.listRecentChanges(ctx, req.Session, req.Role, args.Student, args.Limit)
+	})
+
+	registerTool(r, Tool{
+		Name:        "revert_change",
+		Title:       "Deshacer cambio",
+		Description: "Deshace un cambio (inserción, modificación o borrado) por su id; se niega si el estudiante ha cambiado después",
+		Annotations: writeTool("Deshacer cambio", true, false),
+	}, func(ctx context.Context, req *ToolRequest, args revertChangeArgs) (interface{}, error) {
+		return s.revertChange(ctx, req, args.ChangeID)
+	})
+
+	registerTool(r, Tool{
+		Name:        "get_audit_log",
+		Title:       "Registro de auditoría",
+		Description: "Consulta el registro de cambios hechos con herramientas de escritura, filtrando por estudiante, autor o periodo",
+		Annotations: readOnlyTool("Registro de auditoría"),
+	}, func(ctx context.Context, req *ToolRequest, args auditLogArgs) (interface{}, error) {
+		return s.getAuditLog(ctx, req.Session, req.Role, args.query())
+	})
+
+	registerTool(r, Tool{
+		Name:        "generate_report_card",
+		Title:       "Generar boletín",
+		Description: "Genera el boletín de un estudiante: notas, contexto de la clase y un comentario redactado por el modelo del cliente (o por plantilla si no soporta sampling)",
+		Annotations: readOnlyTool("Generar boletín"),
+	}, func(ctx context.Context, req *ToolRequest, args studentRefArgs) (interface{}, error) {
+		return s.generateReportCard(ctx, req.Session, req.Role, args.ref())
+	})
+
+	return r
 }
 
 // Implementación de las herramientas
-func (s *Server) listStudents() (interface{}, error) {
-	cursor, err := s.collection.Find(context.TODO(), bson.M{})
+func (s *Server) listStudents(ctx context.Context, role Role, group string, progress *ProgressReporter) (interface{}, error) {
+	filter, err := s.groupFilter(ctx, group)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	total := s.countStudents(ctx, progress)
+
+	cursor, err := s.collection.Find(ctx, role.restrict(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
 	var students []Student
-	if err = cursor.All(context.TODO(), &students); err != nil {
+	for cursor.Next(ctx) {
+		var student Student
+		if err := cursor.Decode(&student); err != nil {
+			return nil, err
+		}
+		if student, visible := role.redact(student); visible {
+			students = append(students, student)
+		}
+
+		if len(students)%progressStep == 0 {
+			progress.Report(float64(len(students)), total, fmt.Sprintf("%d estudiantes leídos", len(students)))
+		}
+	}
+	if err := cursor.Err(); err != nil {
 		return nil, err
 	}
 
+	progress.Report(float64(len(students)), total, "Listado completado")
 	return students, nil
 }
 
-func (s *Server) getStudentByName(name string) (interface{}, error) {
-	var student Student
-	err := s.collection.FindOne(context.TODO(), bson.M{"name": name}).Decode(&student)
+// countStudents obtiene el total para las notificaciones de progreso. Solo
+// consulta la base de datos si el cliente pidió progreso; 0 significa desconocido.
+func (s *Server) countStudents(ctx context.Context, progress *ProgressReporter) float64 {
+	if progress == nil {
+		return 0
+	}
+	count, err := s.collection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return 0
+	}
+	return float64(count)
+}
+
+func (s *Server) getStudent(ctx context.Context, sess *Session, role Role, ref studentRef) (interface{}, error) {
+	student, err := s.findStudent(ctx, sess, role, ref)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("estudiante '%s' no encontrado", name)
-		}
 		return nil, err
 	}
 
 	return student, nil
 }
 
-func (s *Server) getStudentGrades(name string) (interface{}, error) {
-	var student Student
-	err := s.collection.FindOne(context.TODO(), bson.M{"name": name}).Decode(&student)
+func (s *Server) getStudentGrades(ctx context.Context, sess *Session, role Role, ref studentRef, period Period) (interface{}, error) {
+	student, err := s.findStudent(ctx, sess, role, ref)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("estudiante '%s' no encontrado", name)
-		}
 		return nil, err
 	}
 
-	return map[string]interface{}{
-		"student": name,
-		"grades":  student.Subjects,
-	}, nil
+	grades, closed := student.gradesFor(period)
+	result := map[string]interface{}{
+		"student": student.Name,
+		"grades":  grades,
+	}
+	if !period.IsZero() {
+		result["year"] = period.Year
+		result["term"] = period.Term
+		result["closed"] = closed
+	}
+	return result, nil
 }
 
-func (s *Server) getSubjectGrades(subject string) (interface{}, error) {
-	cursor, err := s.collection.Find(context.TODO(), bson.M{})
+// getStudentGradesAsOf reconstruye las notas de un estudiante en una fecha
+// a partir del historial
+func (s *Server) getStudentGradesAsOf(ctx context.Context, sess *Session, role Role, ref studentRef, asOf time.Time) (interface{}, error) {
+	student, err := s.findStudent(ctx, sess, role, ref)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.gradeHistory(ctx, student.ID, "")
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+
+	var visible []GradeEvent
+	for _, event := range events {
+		if role.canSeeSubject(event.Subject) {
+			visible = append(visible, event)
+		}
+	}
+	grades, withoutHistory := gradesAsOf(student.Subjects, visible, asOf)
+
+	result := map[string]interface{}{
+		"student": student.Name,
+		"as_of":   asOf.UTC().Format(time.RFC3339),
+		"grades":  grades,
+	}
+	if len(withoutHistory) > 0 {
+		result["without_history"] = withoutHistory
+	}
+	return result, nil
+}
+
+func (s *Server) getSubjectGrades(ctx context.Context, role Role, subject, group string, period Period, progress *ProgressReporter) (interface{}, error) {
+	if !role.canSeeSubject(subject) {
+		return nil, fmt.Errorf("%w: no impartes %s", errForbidden, subject)
+	}
+	filter, err := s.groupFilter(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	total := s.countStudents(ctx, progress)
+
+	cursor, err := s.collection.Find(ctx, role.restrict(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
 	var results []map[string]interface{}
-	for cursor.Next(context.TODO()) {
+	scanned := 0
+	for cursor.Next(ctx) {
+		scanned++
+		if scanned%progressStep == 0 {
+			progress.Report(float64(scanned), total, fmt.Sprintf("%d estudiantes revisados", scanned))
+		}
+
 		var student Student
 		if err := cursor.Decode(&student); err != nil {
 			continue
 		}
+		student, visible := role.redact(student)
+		if !visible {
+			continue
+		}
 
-		if grade, exists := student.Subjects[subject]; exists {
+		grades, _ := student.gradesFor(period)
+		if grade, exists := grades[subject]; exists {
 			results = append(results, map[string]interface{}{
 				"student": student.Name,
 				"grade":   grade,
@@ -238,126 +672,239 @@ func (s *Server) getSubjectGrades(subject string) (interface{}, error) {
 		}
 	}
 
-	return map[string]interface{}{
+	// Un fallo del cursor (incluida la cancelación) no debe devolver datos parciales
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	progress.Report(float64(scanned), total, "Consulta completada")
+
+	result := map[string]interface{}{
 		"subject": subject,
 		"grades":  results,
-	}, nil
+	}
+	if !period.IsZero() {
+		result["year"] = period.Year
+		result["term"] = period.Term
+	}
+	return result, nil
 }
 
-func (s *Server) calculateStudentAverage(name string) (interface{}, error) {
-	var student Student
-	err := s.collection.FindOne(context.TODO(), bson.M{"name": name}).Decode(&student)
+func (s *Server) calculateStudentAverage(ctx context.Context, sess *Session, role Role, ref studentRef, period Period) (interface{}, error) {
+	student, err := s.findStudent(ctx, sess, role, ref)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("estudiante '%s' no encontrado", name)
-		}
 		return nil, err
 	}
+	grades, _ := student.gradesFor(period)
 
-	if len(student.Subjects) == 0 {
+	if len(grades) == 0 {
 		return map[string]interface{}{
-			"student": name,
+			"student": student.Name,
 			"average": 0,
 			"message": "No hay notas registradas",
 		}, nil
 	}
 
 	var total float64
-	for _, grade := range student.Subjects {
+	for _, grade := range grades {
 		total += grade
 	}
-	average := total / float64(len(student.Subjects))
+	average := total / float64(len(grades))
 
-	return map[string]interface{}{
-		"student":      name,
+	result := map[string]interface{}{
+		"student":      student.Name,
 		"average":      average,
-		"total_grades": len(student.Subjects),
-	}, nil
+		"total_grades": len(grades),
+	}
+	if !period.IsZero() {
+		result["year"] = period.Year
+		result["term"] = period.Term
+	}
+	return result, nil
 }
 
-func (s *Server) addStudent(name string, subjects map[string]interface{}) (interface{}, error) {
-	// Convertir subjects a map[string]float64
-	convertedSubjects := make(map[string]float64)
-	for subject, grade := range subjects {
-		switch v := grade.(type) {
-		case float64:
-			convertedSubjects[subject] = v
-		case int:
-			convertedSubjects[subject] = float64(v)
-		case string:
-			if f, err := strconv.ParseFloat(v, 64); err == nil {
-				convertedSubjects[subject] = f
-			} else {
-				return nil, fmt.Errorf("nota inválida para %s: %s", subject, v)
-			}
-		default:
-			return nil, fmt.Errorf("tipo de nota inválido para %s", subject)
+// knownSubjects devuelve las asignaturas que aparecen en algún estudiante
+func (s *Server) knownSubjects(ctx context.Context) ([]string, error) {
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"subject": bson.M{"$objectToArray": "$subjects"}}}},
+		{{Key: "$unwind", Value: "$subject"}},
+		{{Key: "$group", Value: bson.M{"_id": "$subject.k"}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Subject string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	subjects := make([]string, len(rows))
+	for i, row := range rows {
+		subjects[i] = row.Subject
+	}
+	return subjects, nil
+}
+
+func (s *Server) addStudent(ctx context.Context, req *ToolRequest, name string, subjects map[string]float64, profile *Profile, allowDuplicate bool) (interface{}, error) {
+	sess, role := req.Session, req.Role
+
+	// Antes de pedir las notas, por si ya existe
+	if err := s.checkDuplicates(ctx, role, name, profile, allowDuplicate); err != nil {
+		return nil, err
+	}
+
+	// Sin notas, se las pedimos al usuario si el cliente lo permite
+	if len(subjects) == 0 {
+		if !sess.supportsElicitation() {
+			return nil, fmt.Errorf("parámetro 'subjects' requerido")
+		}
+
+		known, err := s.knownSubjects(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Si hay catálogo, se ofrecen sus asignaturas
+		catalog, err := s.subjectCatalog(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if codes := catalogCodes(catalog); len(codes) > 0 {
+			known = codes
+		}
+		// Un profesor solo puede poner notas de sus asignaturas
+		if role.Name == roleTeacher {
+			known = role.Subjects
+		}
+		if len(known) == 0 {
+			return nil, fmt.Errorf("no hay asignaturas registradas: indica 'subjects' explícitamente")
+		}
+
+		subjects, err = sess.elicitGrades(ctx, name, known)
+		if err != nil {
+			return nil, err
+		}
+		if len(subjects) == 0 {
+			return nil, fmt.Errorf("no se indicó ninguna nota para %s", name)
 		}
 	}
 
+	// Las asignaturas se guardan con su código del catálogo
+	subjects, err := s.canonicalGrades(ctx, subjects)
+	if err != nil {
+		return nil, err
+	}
+
+	// También las notas elicitadas, por si el cliente devolvió otras asignaturas
+	if err := role.checkGradesWrite(subjects); err != nil {
+		return nil, err
+	}
+
 	student := Student{
-		Name:     name,
-		Subjects: convertedSubjects,
+		Name:         name,
+		Subjects:     subjects,
+		GradePeriods: make(map[string]Period, len(subjects)),
+	}
+	for subject := range subjects {
+		student.GradePeriods[subject] = periodOf(time.Now())
+	}
+	if !profile.isEmpty() {
+		student.Profile = profile
+	}
+	// Dos altas simultáneas con el mismo nombre pasan las dos checkDuplicates;
+	// el índice único de name_key rechaza la segunda
+	if !allowDuplicate {
+		student.NameKey = subjectKey(name)
 	}
 
-	result, err := s.collection.InsertOne(context.TODO(), student)
+	result, err := s.collection.InsertOne(ctx, student)
+	if mongo.IsDuplicateKeyError(err) {
+		if strings.Contains(err.Error(), "name_key") {
+			return nil, &StudentMatchError{Message: fmt.Sprintf("ya hay un estudiante llamado '%s'; si es otra persona, repite la llamada con allow_duplicate", name)}
+		}
+		return nil, fmt.Errorf("el número de expediente %s ya existe", profile.StudentNumber)
+	}
 	if err != nil {
 		return nil, err
 	}
+	student.ID, _ = result.InsertedID.(primitive.ObjectID)
+	req.RecordChange(Change{StudentID: student.ID, StudentName: name, After: &student})
 
 	return map[string]interface{}{
 		"message":    "Estudiante añadido exitosamente",
 		"student_id": result.InsertedID,
 		"name":       name,
-		"subjects":   convertedSubjects,
+		"subjects":   subjects,
+		"profile":    student.Profile,
 	}, nil
 }
 
-func (s *Server) handleToolCall(toolName string, params map[string]interface{}) (interface{}, error) {
-	switch toolName {
-	case "list_students":
-		return s.listStudents()
-	case "get_student_by_name":
-		name, ok := params["name"].(string)
-		if !ok {
-			return nil, fmt.Errorf("parámetro 'name' requerido")
-		}
-		return s.getStudentByName(name)
-	case "get_student_grades":
-		name, ok := params["name"].(string)
-		if !ok {
-			return nil, fmt.Errorf("parámetro 'name' requerido")
-		}
-		return s.getStudentGrades(name)
-	case "get_subject_grades":
-		subject, ok := params["subject"].(string)
-		if !ok {
-			return nil, fmt.Errorf("parámetro 'subject' requerido")
-		}
-		return s.getSubjectGrades(subject)
-	case "calculate_student_average":
-		name, ok := params["name"].(string)
-		if !ok {
-			return nil, fmt.Errorf("parámetro 'name' requerido")
-		}
-		return s.calculateStudentAverage(name)
-	case "add_student":
-		name, ok := params["name"].(string)
-		if !ok {
-			return nil, fmt.Errorf("parámetro 'name' requerido")
-		}
-		subjects, ok := params["subjects"].(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("parámetro 'subjects' requerido")
-		}
-		return s.addStudent(name, subjects)
-	default:
-		return nil, fmt.Errorf("herramienta desconocida: %s", toolName)
+// setGrade pone la nota de una asignatura. La actualización solo se aplica si
+// la nota no cambió desde que la leímos, para que el historial no pierda una
+// escritura concurrente.
+func (s *Server) setGrade(ctx context.Context, req *ToolRequest, ref studentRef, subject string, grade float64) (interface{}, error) {
+	if err := req.Role.checkGradesWrite(map[string]float64{subject: grade}); err != nil {
+		return nil, err
+	}
+
+	found, err := s.findStudent(ctx, req.Session, req.Role, ref)
+	if err != nil {
+		return nil, err
+	}
+	// El rol puede ocultar asignaturas: el cambio se registra con el documento completo
+	var before Student
+	if err := s.collection.FindOne(ctx, bson.M{"_id": found.ID}).Decode(&before); err != nil {
+		return nil, err
 	}
+
+	if len(before.Assessments[subject]) > 0 {
+		return nil, fmt.Errorf("la nota de %s en %s se calcula a partir de sus evaluaciones; usa record_assessment", before.Name, subject)
+	}
+
+	field := "subjects." + subject
+	filter := bson.M{"_id": before.ID}
+	previous, hadPrevious := before.Subjects[subject]
+	if hadPrevious {
+		filter[field] = previous
+	} else {
+		filter[field] = bson.M{"$exists": false}
+	}
+
+	// La nota cuenta en la evaluación en la que se pone
+	period := periodOf(time.Now())
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{field: grade, "grade_periods." + subject: period}})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, fmt.Errorf("la nota de %s en %s ha cambiado mientras se actualizaba; vuelve a intentarlo", before.Name, subject)
+	}
+
+	after := before.clone()
+	after.Subjects[subject] = grade
+	after.GradePeriods[subject] = period
+	req.RecordChange(Change{StudentID: before.ID, StudentName: before.Name, Before: &before, After: &after})
+
+	response := map[string]interface{}{
+		"message": "Nota actualizada",
+		"student": before.Name,
+		"subject": subject,
+		"grade":   grade,
+	}
+	if hadPrevious {
+		response["previous"] = previous
+	}
+	return response, nil
 }
 
 // ARREGLADA: Manejo de mensajes para ambos modos (TCP y stdio)
-func (s *Server) processMessage(message []byte) []byte {
+// processMessage atiende un mensaje y devuelve la respuesta serializada, o nada
+// si el mensaje era una notificación. ctx se cancela si el cliente cancela la
+// petición o se cierra la sesión.
+func (s *Server) processMessage(ctx context.Context, sess *Session, message []byte) []byte {
 	var msg MCPMessage
 	if err := json.Unmarshal(message, &msg); err != nil {
 		// Si no podemos parsear el mensaje, usamos un ID por defecto
@@ -383,22 +930,85 @@ func (s *Server) processMessage(message []byte) []byte {
 		response.ID = "unknown"
 	}
 
+	// Respuesta a una petición enviada por el servidor (por ejemplo un ping)
+	if msg.Method == "" && msg.ID != nil && (msg.Result != nil || msg.Error != nil) {
+		if !sess.handleResponse(msg) {
+			sess.logger.Debug("Respuesta inesperada del cliente", "id", msg.ID)
+		}
+		return []byte{}
+	}
+
+	// Ninguna petición salvo initialize y ping se atiende antes de inicializar la sesión
+	if msg.Method != "initialize" && msg.Method != "ping" && !isNotification(msg.Method) && !sess.acceptsRequests() {
+		response.Error = &MCPError{
+			Code:    -32002,
+			Message: "Servidor no inicializado: se requiere initialize antes de " + msg.Method,
+		}
+		responseBytes, _ := json.Marshal(response)
+		return responseBytes
+	}
+
 	switch msg.Method {
 	case "initialize":
+		var params InitializeParams
+		if err := decodeParams(msg.Params, &params); err != nil {
+			response.Error = &MCPError{
+				Code:    -32602,
+				Message: "Parámetros de initialize inválidos: " + err.Error(),
+			}
+			break
+		}
+
+		if err := s.authenticate(sess, params); err != nil {
+			slog.Warn("Autenticación fallida", "remote", sess.remoteAddr, "cliente", params.ClientInfo.Name, "motivo", err)
+			response.Error = &MCPError{
+				Code:    -32001,
+				Message: err.Error(),
+			}
+			// serveSession deja de leer y el transporte cierra la conexión
+			sess.cancel()
+			break
+		}
+
+		version, err := sess.initialize(params)
+		if err != nil {
+			response.Error = &MCPError{
+				Code:    -32600,
+				Message: err.Error(),
+			}
+			break
+		}
+
 		response.Result = map[string]interface{}{
-			"protocolVersion": "2024-11-05",
+			"protocolVersion": version,
 			"capabilities": map[string]interface{}{
-				"tools": map[string]interface{}{},
+				"tools":   map[string]interface{}{"listChanged": true},
+				"logging": map[string]interface{}{},
 			},
 			"serverInfo": map[string]interface{}{
-				"name":    "mongodb-student-server",
-				"version": "1.0.0",
+				"name":    serverName,
+				"version": serverVersion,
 			},
 		}
 
+	case "ping":
+		response.Result = map[string]interface{}{}
+
+	case "logging/setLevel":
+		var params struct {
+			Level string `json:"level"`
+		}
+		if err := decodeParams(msg.Params, &params); err != nil {
+			response.Error = &MCPError{Code: -32602, Message: "Parámetros inválidos: " + err.Error()}
+		} else if err := sess.setLogLevel(params.Level); err != nil {
+			response.Error = &MCPError{Code: -32602, Message: err.Error()}
+		} else {
+			response.Result = map[string]interface{}{}
+		}
+
 	case "tools/list":
 		response.Result = map[string]interface{}{
-			"tools": s.getTools(),
+			"tools": s.listTools(sess),
 		}
 
 	case "tools/call":
@@ -416,35 +1026,94 @@ func (s *Server) processMessage(message []byte) []byte {
 					Message: "Nombre de herramienta requerido",
 				}
 			} else {
+				tool, enabled := s.findTool(sess, toolName)
+				if !enabled {
+					response.Error = &MCPError{
+						Code:    -32602,
+						Message: "Herramienta no disponible: " + toolName,
+					}
+					break
+				}
+
 				arguments, _ := params["arguments"].(map[string]interface{})
 				if arguments == nil {
 					arguments = make(map[string]interface{})
 				}
 
-				result, err := s.handleToolCall(toolName, arguments)
-				if err != nil {
+				sess.logger.Debug("Llamada a herramienta", "herramienta", toolName)
+				req := &ToolRequest{
+					Session:  sess,
+					Progress: newProgressReporter(sess, params),
+					Role:     s.requestRole(ctx, sess),
+				}
+				result, err := s.toolRegistry().call(ctx, req, toolName, arguments)
+
+				var invalidParams *InvalidParamsError
+				var studentMatch *StudentMatchError
+				if !tool.isReadOnly() && !errors.As(err, &invalidParams) {
+					s.recordAudit(ctx, req, toolName, arguments, err)
+					s.recordGradeHistory(ctx, req, toolName)
+				}
+
+				if errors.As(err, &invalidParams) {
+					response.Error = &MCPError{
+						Code:    -32602,
+						Message: err.Error(),
+					}
+					if invalidParams.Pointer != "" {
+						response.Error.Data = map[string]interface{}{"pointer": invalidParams.Pointer}
+					}
+				} else if errors.As(err, &studentMatch) {
+					// -32002 es el de sesión no inicializada
+					response.Error = &MCPError{
+						Code:    -32003,
+						Message: err.Error(),
+						Data:    map[string]interface{}{"candidates": studentMatch.Candidates},
+					}
+				} else if errors.Is(err, errForbidden) {
+					identity, _ := sess.Identity()
+					sess.logger.Warn("Acceso denegado", "herramienta", toolName, "sujeto", identity.Subject, "error", err)
+					response.Error = &MCPError{
+						Code:    -32001,
+						Message: err.Error(),
+					}
+				} else if err != nil {
+					sess.logger.Warn("Error ejecutando herramienta", "herramienta", toolName, "error", err)
 					response.Error = &MCPError{
 						Code:    -32603,
 						Message: err.Error(),
 					}
 				} else {
-					response.Result = map[string]interface{}{
-						"content": []map[string]interface{}{
-							{
-								"type": "text",
-								"text": fmt.Sprintf("%+v", result),
-							},
-						},
-					}
+					response.Result = toolResult(sess, result)
 				}
 			}
 		}
 
+	case "notifications/cancelled":
+		var params struct {
+			RequestID interface{} `json:"requestId"`
+			Reason    string      `json:"reason"`
+		}
+		if err := decodeParams(msg.Params, &params); err == nil && params.RequestID != nil {
+			if sess.cancelRequest(params.RequestID) {
+				sess.logger.Info("Petición cancelada por el cliente", "id", params.RequestID, "motivo", params.Reason)
+			}
+		}
+		return []byte{}
+
 	case "notifications/initialized":
 		// Notificación de inicialización - no necesita respuesta
+		if sess.markInitialized() {
+			info := sess.ClientInfo()
+			sess.logger.Info("Sesión inicializada", "cliente", info.Name, "version_cliente", info.Version, "protocolo", sess.ProtocolVersion())
+		}
 		return []byte{}
 
 	default:
+		// Las notificaciones desconocidas se ignoran: nunca llevan respuesta
+		if isNotification(msg.Method) {
+			return []byte{}
+		}
 		response.Error = &MCPError{
 			Code:    -32601,
 			Message: "Método no encontrado: " + msg.Method,
@@ -455,59 +1124,153 @@ func (s *Server) processMessage(message []byte) []byte {
 	return responseBytes
 }
 
-// NUEVA FUNCIÓN: Manejo de stdin/stdout para Claude Desktop
-func (s *Server) handleStdio() {
-	scanner := bufio.NewScanner(os.Stdin)
+// toolResult construye la respuesta de tools/call. Las sesiones que negociaron
+// 2025-06-18 o posterior reciben además el resultado como structuredContent.
+func toolResult(sess *Session, result interface{}) map[string]interface{} {
+	if !sess.supportsStructuredContent() {
+		return map[string]interface{}{
+			"content": []map[string]interface{}{
+				{
+					"type": "text",
+					"text": fmt.Sprintf("%+v", result),
+				},
+			},
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		data = []byte("null")
+	}
 
+	// structuredContent debe ser un objeto JSON
+	var structured interface{} = json.RawMessage(data)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		structured = map[string]interface{}{"result": json.RawMessage(data)}
+	}
+
+	return map[string]interface{}{
+		"content": []map[string]interface{}{
+			{
+				"type": "text",
+				"text": string(data),
+			},
+		},
+		"structuredContent": structured,
+	}
+}
+
+func isNotification(method string) bool {
+	return strings.HasPrefix(method, "notifications/")
+}
+
+// serveSession lee mensajes línea a línea. Las peticiones se atienden en
+// paralelo; initialize y las notificaciones se procesan en orden para que el
+// ciclo de vida y las cancelaciones se apliquen antes de leer el siguiente
+// mensaje. La usan stdio y TCP para que ambos transportes se comporten igual.
+func (s *Server) serveSession(sess *Session, r io.Reader) error {
+	s.addSession(sess)
+	defer s.removeSession(sess)
+
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Bytes()
 		if len(line) == 0 {
 			continue
 		}
+		sess.touch()
 
-		// Procesar mensaje
-		response := s.processMessage(line)
-
-		// Solo enviar respuesta si no está vacía
-		if len(response) > 0 {
-			fmt.Printf("%s\n", response)
+		// Los errores de parsing los reporta processMessage
+		var header struct {
+			ID     interface{} `json:"id"`
+			Method string      `json:"method"`
+		}
+		json.Unmarshal(line, &header)
 
-			// Flush stdout para asegurar que se envíe inmediatamente
-			os.Stdout.Sync()
+		if header.ID == nil || header.Method == "" || header.Method == "initialize" {
+			if err := sess.write(s.processMessage(sess.ctx, sess, line)); err != nil {
+				sess.close()
+				return err
+			}
+			// La sesión se cerró al procesar el mensaje (autenticación fallida)
+			if sess.ctx.Err() != nil {
+				break
+			}
+			continue
 		}
+
+		// El scanner reutiliza su buffer
+		message := append([]byte(nil), line...)
+		ctx, done := sess.beginRequest(header.ID)
+		go func() {
+			defer done()
+			response := s.processMessage(ctx, sess, message)
+			// Una petición cancelada no lleva respuesta
+			if ctx.Err() != nil {
+				return
+			}
+			sess.write(response)
+		}()
 	}
 
-	if err := scanner.Err(); err != nil && err != io.EOF {
-		// En modo stdio no podemos usar log porque contamina stdout
-		// Solo salir silenciosamente si hay error
-		os.Exit(1)
+	// Sin entrada no llegan más respuestas del cliente: las herramientas que
+	// esperan una elicitación o un sampling fallan en lugar de bloquearse
+	sess.closeInput()
+
+	err := scanner.Err()
+	if err == nil {
+		// Fin de la entrada: dejamos terminar lo pendiente antes de cerrar
+		sess.requests.Wait()
 	}
+	sess.close()
+	return err
+}
+
+// syncWriter hace flush de stdout tras cada mensaje
+type syncWriter struct {
+	file *os.File
 }
 
-// MODIFICADA: Usar la función compartida processMessage
-func (s *Server) handleMessage(conn net.Conn, message []byte) {
-	response := s.processMessage(message)
-	if len(response) > 0 {
-		conn.Write(response)
-		conn.Write([]byte("\n"))
+func (w syncWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	if err == nil {
+		w.file.Sync()
+	}
+	return n, err
+}
+
+// NUEVA FUNCIÓN: Manejo de stdin/stdout para Claude Desktop
+func (s *Server) handleStdio() {
+	sess := newSession(transportStdio, "", syncWriter{os.Stdout})
+
+	// El log va a stderr o LOG_FILE, nunca a stdout
+	if err := s.serveSession(sess, os.Stdin); err != nil && err != io.EOF {
+		slog.Error("Error leyendo de stdin", "error", err)
+		os.Exit(1)
 	}
 }
 
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
-	log.Printf("Nueva conexión desde %s", conn.RemoteAddr())
+	slog.Info("Nueva conexión", "remote", conn.RemoteAddr().String())
 
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(line) > 0 {
-			s.handleMessage(conn, line)
+	sess := newSession(transportTCP, conn.RemoteAddr().String(), conn)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := s.acceptTLS(sess, tlsConn); err != nil {
+			slog.Warn("Autenticación fallida", "remote", sess.remoteAddr, "motivo", err)
+			return
 		}
 	}
+	if s.auth.required() {
+		defer s.closeUnauthenticated(sess, conn).Stop()
+	}
+	// Cerrar la conexión termina serveSession, que libera la sesión
+	go sess.keepalive(s.keepalive, func() { conn.Close() })
 
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error leyendo de la conexión: %v", err)
+	if err := s.serveSession(sess, conn); err != nil {
+		sess.logger.Error("Error leyendo de la conexión", "error", err)
 	}
+	slog.Info("Conexión cerrada", "remote", conn.RemoteAddr().String(), "cliente", sess.ClientInfo().Name)
 }
 
 func main() {
@@ -535,49 +1298,124 @@ func main() {
 	mode := getEnv("MCP_MODE", "auto")
 	isStdio := mode == "stdio" || (mode == "auto" && isStdioMode())
 
-	// Solo mostrar logs en modo TCP para no contaminar stdio
-	if !isStdio {
-		log.Printf("Conectando a MongoDB: %s", mongoURI)
+	// El logger escribe en stderr o LOG_FILE, así que es seguro también en modo stdio
+	logger, logCloser, err := newLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error configurando el log: %v\n", err)
+		os.Exit(1)
 	}
+	defer logCloser.Close()
+	slog.SetDefault(logger)
+
+	transport := transportTCP
+	if isStdio {
+		transport = transportStdio
+	} else if mode == "http" {
+		transport = transportHTTP
+	}
+	slog.Info("Conectando a MongoDB", "uri", mongoURI, "modo", transport)
 
 	// Crear el servidor
 	server, err := NewServer(mongoURI, dbName, collectionName)
 	if err != nil {
-		if !isStdio {
-			log.Fatalf("Error conectando a MongoDB: %v", err)
-		} else {
-			// En modo stdio, salir silenciosamente
+		slog.Error("Error conectando a MongoDB", "uri", mongoURI, "error", err)
+		logCloser.Close()
+		os.Exit(1)
+	}
+	defer server.Close()
+
+	slog.Info("Conectado a MongoDB", "uri", mongoURI, "base_de_datos", dbName, "coleccion", collectionName)
+
+	// Por defecto la auditoría va a MongoDB; AUDIT_FILE la lleva a un fichero JSONL
+	if path := os.Getenv("AUDIT_FILE"); path != "" {
+		audit, err := openFileAuditLog(path)
+		if err != nil {
+			slog.Error("Error en la configuración de auditoría", "error", err)
 			os.Exit(1)
 		}
+		defer audit.Close()
+		server.audit = audit
+		slog.Info("Auditoría en fichero", "fichero", path)
 	}
-	defer server.Close()
 
-	if !isStdio {
-		log.Printf("Conectado a MongoDB: %s", mongoURI)
-		log.Printf("Base de datos: %s, Colección: %s", dbName, collectionName)
+	// Herramientas permitidas en este transporte
+	server.setToolPolicy(transport, loadToolPolicy(transport))
+
+	// Roles de las identidades autenticadas
+	server.roles, err = loadRoleBindings()
+	if err != nil {
+		slog.Error("Error en la configuración de roles", "error", err)
+		os.Exit(1)
 	}
+	if server.roles != nil {
+		slog.Info("Control de acceso por roles activado", "identidades", len(server.roles))
+	}
+
+	// SIGUSR1 alterna el modo solo lectura sin reiniciar el servidor
+	server.watchSignals()
 
 	if isStdio {
 		// Modo stdio para Claude Desktop
 		server.handleStdio()
+	} else if transport == transportHTTP {
+		// Transporte Streamable HTTP, con autorización OAuth opcional
+		oauth, err := loadOAuthConfig(fmt.Sprintf("http://localhost:%s%s", port, httpEndpointPath))
+		if err != nil {
+			slog.Error("Error en la configuración de OAuth", "error", err)
+			os.Exit(1)
+		}
+		if oauth == nil {
+			slog.Warn("El transporte HTTP no tiene autorización: cualquiera que alcance el puerto tiene acceso")
+		} else {
+			slog.Info("Autorización OAuth activada", "emisor", oauth.Issuer, "audiencia", oauth.Audience)
+		}
+
+		server.keepalive = loadKeepaliveConfig()
+		if server.keepalive.Interval > 0 {
+			slog.Info("Keepalive activado", "intervalo", server.keepalive.Interval, "timeout", server.keepalive.Timeout)
+		}
+
+		slog.Info("Servidor MCP escuchando por HTTP", "interfaz", getEnv("HTTP_HOST", defaultHTTPHost), "puerto", port, "ruta", httpEndpointPath)
+		if err := server.serveHTTP(port, oauth); err != nil {
+			slog.Error("Error en el servidor HTTP", "puerto", port, "error", err)
+			os.Exit(1)
+		}
 	} else {
 		// Modo TCP para pruebas directas
-		log.Printf("Iniciando servidor MCP en puerto %s...", port)
+		slog.Info("Iniciando servidor MCP", "puerto", port)
+
+		server.auth, err = loadAuthConfig()
+		if err != nil {
+			slog.Error("Error en la configuración de autenticación", "error", err)
+			os.Exit(1)
+		}
+		if !server.auth.required() {
+			slog.Warn("El transporte TCP no tiene autenticación: cualquiera que alcance el puerto tiene acceso")
+		}
+
+		server.keepalive = loadKeepaliveConfig()
+		if server.keepalive.Interval > 0 {
+			slog.Info("Keepalive activado", "intervalo", server.keepalive.Interval, "timeout", server.keepalive.Timeout)
+		}
 
 		// Crear el listener TCP
 		listener, err := net.Listen("tcp", ":"+port)
 		if err != nil {
-			log.Fatalf("Error creando listener: %v", err)
+			slog.Error("Error creando listener", "puerto", port, "error", err)
+			os.Exit(1)
 		}
 		defer listener.Close()
+		if server.auth.TLS != nil {
+			listener = tls.NewListener(listener, server.auth.TLS)
+		}
 
-		log.Printf("Servidor MCP escuchando en puerto %s", port)
+		slog.Info("Servidor MCP escuchando", "puerto", port, "tls", server.auth.TLS != nil, "token", server.auth.Token != "")
 
 		// Aceptar conexiones
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
-				log.Printf("Error aceptando conexión: %v", err)
+				slog.Error("Error aceptando conexión", "error", err)
 				continue
 			}
 
@@ -25,7 +25,8 @@ func sendMCPMessage(conn net.Conn, message MCPMessage) (MCPMessage, error) {
 		return MCPMessage{}, err
 	}
 
-	_, err = conn.Write(data)
+	// Un mensaje por línea
+	_, err = conn.Write(append(data, '\n'))
 	if err != nil {
 		return MCPMessage{}, err
 	}
@@ -42,6 +43,33 @@ func sendMCPMessage(conn net.Conn, message MCPMessage) (MCPMessage, error) {
 	return response, err
 }
 
+// Test helper para abrir la sesión: initialize y notifications/initialized,
+// sin los que el servidor rechaza el resto de peticiones con -32002
+func initializeSession(t *testing.T, conn net.Conn) {
+	response, err := sendMCPMessage(conn, MCPMessage{
+		JsonRPC: "2.0",
+		ID:      1,
+		Method:  "initialize",
+		Params: map[string]interface{}{
+			"protocolVersion": "2024-11-05",
+			"capabilities":    map[string]interface{}{},
+			"clientInfo":      map[string]interface{}{"name": "main_test", "version": "1.0"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error inicializando: %v", err)
+	}
+	if response.Error != nil {
+		t.Fatalf("Error en la inicialización: %v", response.Error)
+	}
+
+	// La notificación no tiene respuesta
+	data, _ := json.Marshal(MCPMessage{JsonRPC: "2.0", Method: "notifications/initialized"})
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		t.Fatalf("Error enviando notifications/initialized: %v", err)
+	}
+}
+
 func TestServerInitialize(t *testing.T) {
 	// Esta prueba requiere que el servidor esté ejecutándose
 	t.Skip("Requiere servidor ejecutándose - ejecutar manualmente")
@@ -53,7 +81,9 @@ func TestServerInitialize(t *testing.T) {
 		JsonRPC: "2.0",
 		ID:      1,
 		Method:  "initialize",
-		Params:  map[string]interface{}{},
+		Params: map[string]interface{}{
+			"protocolVersion": "2024-11-05",
+		},
 	}
 
 	response, err := sendMCPMessage(conn, initMessage)
@@ -81,6 +111,7 @@ func TestListTools(t *testing.T) {
 
 	conn := connectToServer(t, "8080")
 	defer conn.Close()
+	initializeSession(t, conn)
 
 	message := MCPMessage{
 		JsonRPC: "2.0",
@@ -111,9 +142,29 @@ func TestListTools(t *testing.T) {
 		"list_students",
 		"get_student_by_name",
 		"get_student_grades",
+		"get_grade_history",
 		"get_subject_grades",
 		"calculate_student_average",
 		"add_student",
+		"set_grade",
+		"record_assessment",
+		"list_assessments",
+		"close_term",
+		"get_statistics",
+		"list_groups",
+		"create_group",
+		"enroll_student",
+		"unenroll_student",
+		"list_recent_changes",
+		"revert_change",
+		"get_audit_log",
+		"generate_report_card",
+		"list_subjects",
+		"save_subject",
+		"record_attendance",
+		"get_absences",
+		"flag_absences",
+		"update_student",
 	}
 
 	if len(tools) != len(expectedTools) {
@@ -203,16 +254,7 @@ func TestIntegrationListStudents(t *testing.T) {
 	defer conn.Close()
 
 	// Primero inicializar
-	initMsg := MCPMessage{
-		JsonRPC: "2.0",
-		ID:      1,
-		Method:  "initialize",
-	}
-
-	_, err := sendMCPMessage(conn, initMsg)
-	if err != nil {
-		t.Fatalf("Error inicializando: %v", err)
-	}
+	initializeSession(t, conn)
 
 	// Luego llamar a list_students
 	listMsg := MCPMessage{
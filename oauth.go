@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scopes que controlan qué herramientas puede usar un access token
+const (
+	scopeRead  = "students:read"
+	scopeWrite = "students:write"
+)
+
+const (
+	// Margen para diferencias de reloj con el emisor al comprobar exp y nbf
+	tokenClockSkew = time.Minute
+	// Ruta de los metadatos del recurso protegido (RFC 9728)
+	protectedResourcePath = "/.well-known/oauth-protected-resource"
+)
+
+// OAuthConfig valida los access token del transporte HTTP según la
+// especificación de autorización de MCP: el servidor es un recurso protegido
+// que acepta JWT emitidos por Issuer para Audience.
+type OAuthConfig struct {
+	Issuer   string
+	Audience string
+	// URL pública del endpoint MCP, anunciada en los metadatos
+	Resource string
+
+	keys *keySet
+	now  func() time.Time
+}
+
+// loadOAuthConfig lee OAUTH_ISSUER, OAUTH_AUDIENCE, OAUTH_RESOURCE y
+// OAUTH_JWKS_FILE. Devuelve nil si no hay emisor configurado. Las claves se
+// leen del fichero local o, si no se indica, se descubren a partir del emisor.
+func loadOAuthConfig(defaultResource string) (*OAuthConfig, error) {
+	issuer := os.Getenv("OAUTH_ISSUER")
+	if issuer == "" {
+		if os.Getenv("OAUTH_JWKS_FILE") != "" {
+			return nil, errors.New("OAUTH_JWKS_FILE requiere OAUTH_ISSUER")
+		}
+		return nil, nil
+	}
+
+	cfg := &OAuthConfig{
+		Issuer:   issuer,
+		Resource: getEnv("OAUTH_RESOURCE", defaultResource),
+		keys:     &keySet{},
+		now:      time.Now,
+	}
+	cfg.Audience = getEnv("OAUTH_AUDIENCE", cfg.Resource)
+
+	if file := os.Getenv("OAUTH_JWKS_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error leyendo OAUTH_JWKS_FILE: %v", err)
+		}
+		if cfg.keys.keys, err = parseJWKS(data); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+
+	jwksURI, err := discoverJWKS(issuer)
+	if err != nil {
+		return nil, err
+	}
+	cfg.keys.fetch = func() ([]byte, error) { return httpGet(jwksURI) }
+	data, err := cfg.keys.fetch()
+	if err != nil {
+		return nil, fmt.Errorf("error descargando el JWKS de %s: %v", jwksURI, err)
+	}
+	cfg.keys.fetched = time.Now()
+	if cfg.keys.keys, err = parseJWKS(data); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// discoverJWKS obtiene jwks_uri de los metadatos del servidor de
+// autorización (RFC 8414) o, si no los publica, de los de OpenID Connect
+func discoverJWKS(issuer string) (string, error) {
+	base := strings.TrimSuffix(issuer, "/")
+	for _, path := range []string{"/.well-known/oauth-authorization-server", "/.well-known/openid-configuration"} {
+		data, err := httpGet(base + path)
+		if err != nil {
+			continue
+		}
+		var metadata struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if json.Unmarshal(data, &metadata) == nil && metadata.JWKSURI != "" {
+			if metadata.Issuer != "" && metadata.Issuer != issuer {
+				return "", fmt.Errorf("los metadatos de %s anuncian otro emisor: %s", issuer, metadata.Issuer)
+			}
+			return metadata.JWKSURI, nil
+		}
+	}
+	return "", fmt.Errorf("no se encontraron los metadatos del emisor %s", issuer)
+}
+
+var metadataClient = &http.Client{Timeout: 10 * time.Second}
+
+func httpGet(url string) ([]byte, error) {
+	resp, err := metadataClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// verify valida un access token y devuelve la identidad que representa
+func (cfg *OAuthConfig) verify(token string) (Identity, error) {
+	claims, err := parseJWT(token, cfg.keys)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	now := cfg.now()
+	switch {
+	case claims.Issuer != cfg.Issuer:
+		return Identity{}, fmt.Errorf("emisor no válido: %q", claims.Issuer)
+	case !claims.Audience.contains(cfg.Audience):
+		return Identity{}, errors.New("el token no está emitido para este servidor")
+	case claims.ExpiresAt == 0:
+		return Identity{}, errors.New("el token no tiene caducidad")
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(tokenClockSkew)):
+		return Identity{}, errors.New("el token ha caducado")
+	case claims.NotBefore != 0 && now.Add(tokenClockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return Identity{}, errors.New("el token aún no es válido")
+	}
+
+	subject := claims.Subject
+	if subject == "" {
+		subject = claims.ClientID
+	}
+	return Identity{Subject: subject, Method: authOAuth, Scopes: strings.Fields(claims.Scope)}, nil
+}
+
+// requiredScope es el scope necesario para usar una herramienta
+func requiredScope(tool Tool) string {
+	if tool.isReadOnly() {
+		return scopeRead
+	}
+	return scopeWrite
+}
+
+// allows indica si la identidad puede usar la herramienta. Solo las
+// identidades OAuth están limitadas por scopes.
+func (identity Identity) allows(tool Tool) bool {
+	return identity.Method != authOAuth || containsString(identity.Scopes, requiredScope(tool))
+}
+
+// hasAnyScope indica si el token sirve para alguna herramienta
+func (identity Identity) hasAnyScope() bool {
+	return containsString(identity.Scopes, scopeRead) || containsString(identity.Scopes, scopeWrite)
+}
+
+// metadataURL es la URL de los metadatos del recurso protegido que se anuncia
+// en WWW-Authenticate
+func (cfg *OAuthConfig) metadataURL() string {
+	resource := strings.TrimSuffix(cfg.Resource, "/")
+	if i := strings.Index(resource, "://"); i >= 0 {
+		if j := strings.Index(resource[i+3:], "/"); j >= 0 {
+			return resource[:i+3+j] + protectedResourcePath + resource[i+3+j:]
+		}
+	}
+	return resource + protectedResourcePath
+}
+
+// serveMetadata publica los metadatos del recurso protegido (RFC 9728)
+func (cfg *OAuthConfig) serveMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"resource":                 cfg.Resource,
+		"authorization_servers":    []string{cfg.Issuer},
+		"scopes_supported":         []string{scopeRead, scopeWrite},
+		"bearer_methods_supported": []string{"header"},
+		"resource_name":            serverName,
+	})
+}
+
+// challenge responde 401 o 403 con la cabecera WWW-Authenticate que indica al
+// cliente dónde obtener un token válido
+func (cfg *OAuthConfig) challenge(w http.ResponseWriter, status int, code, description string) {
+	value := fmt.Sprintf(`Bearer resource_metadata=%q`, cfg.metadataURL())
+	if code != "" {
+		value += fmt.Sprintf(`, error=%q, error_description=%q`, code, description)
+	}
+	if status == http.StatusForbidden {
+		value += fmt.Sprintf(`, scope=%q`, scopeRead+" "+scopeWrite)
+	}
+	w.Header().Set("WWW-Authenticate", value)
+	http.Error(w, description, status)
+}
+
+// authorize comprueba la cabecera Authorization de una petición HTTP. Si
+// falla ya ha respondido al cliente y devuelve false.
+func (cfg *OAuthConfig) authorize(w http.ResponseWriter, r *http.Request) (Identity, bool) {
+	header := r.Header.Get("Authorization")
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found || token == "" {
+		cfg.challenge(w, http.StatusUnauthorized, "", "se requiere un access token")
+		return Identity{}, false
+	}
+
+	identity, err := cfg.verify(token)
+	if err != nil {
+		slog.Warn("Autenticación fallida", "remote", r.RemoteAddr, "motivo", err)
+		cfg.challenge(w, http.StatusUnauthorized, "invalid_token", err.Error())
+		return Identity{}, false
+	}
+	if !identity.hasAnyScope() {
+		slog.Warn("Autenticación fallida", "remote", r.RemoteAddr, "sujeto", identity.Subject, "motivo", "scopes insuficientes", "scopes", identity.Scopes)
+		cfg.challenge(w, http.StatusForbidden, "insufficient_scope", "el token no incluye ningún scope de este servidor")
+		return Identity{}, false
+	}
+	return identity, true
+}
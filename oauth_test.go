@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer es un servidor de autorización mínimo: publica sus metadatos y
+// su JWKS y firma tokens con una clave RSA generada para el test
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	kid string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{key: key, kid: "clave-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer.URL,
+			"jwks_uri": issuer.URL + "/jwks.json",
+		})
+	})
+	mux.HandleFunc("/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(issuer.jwks())
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func (issuer *testIssuer) jwks() []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": issuer.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(issuer.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(issuer.key.E)).Bytes()),
+		}},
+	})
+	return data
+}
+
+// token firma un access token con claims por defecto válidos para audience
+func (issuer *testIssuer) token(audience, scope string, overrides map[string]interface{}) string {
+	claims := map[string]interface{}{
+		"iss":   issuer.URL,
+		"sub":   "profesora.garcia",
+		"aud":   audience,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": scope,
+	}
+	for k, v := range overrides {
+		claims[k] = v
+	}
+	return signJWT(map[string]string{"alg": "RS256", "kid": issuer.kid}, claims, func(digest []byte) []byte {
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, issuer.key, crypto.SHA256, digest)
+		return sig
+	})
+}
+
+func signJWT(header map[string]string, claims map[string]interface{}, sign func(digest []byte) []byte) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(digest[:]))
+}
+
+func newTestOAuthConfig(t *testing.T, issuer *testIssuer) *OAuthConfig {
+	t.Helper()
+	t.Setenv("OAUTH_ISSUER", issuer.URL)
+	t.Setenv("OAUTH_RESOURCE", "https://mcp.ies.example/mcp")
+
+	cfg, err := loadOAuthConfig("http://localhost:8080/mcp")
+	if err != nil {
+		t.Fatalf("Error cargando la configuración OAuth: %v", err)
+	}
+	return cfg
+}
+
+func TestOAuthVerifyToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	cfg := newTestOAuthConfig(t, issuer)
+	audience := "https://mcp.ies.example/mcp"
+
+	identity, err := cfg.verify(issuer.token(audience, "students:read otro:scope", nil))
+	if err != nil {
+		t.Fatalf("Token válido rechazado: %v", err)
+	}
+	if identity.Subject != "profesora.garcia" || identity.Method != authOAuth || !identity.hasAnyScope() {
+		t.Errorf("Identidad incorrecta: %+v", identity)
+	}
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := signJWT(map[string]string{"alg": "RS256", "kid": issuer.kid}, map[string]interface{}{
+		"iss": issuer.URL, "aud": audience, "exp": time.Now().Add(time.Hour).Unix(),
+	}, func(digest []byte) []byte {
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, otherKey, crypto.SHA256, digest)
+		return sig
+	})
+	unsigned := signJWT(map[string]string{"alg": "none"}, map[string]interface{}{
+		"iss": issuer.URL, "aud": audience, "exp": time.Now().Add(time.Hour).Unix(),
+	}, func([]byte) []byte { return nil })
+
+	rejected := map[string]string{
+		"otra audiencia": issuer.token("https://otro.example", "students:read", nil),
+		"otro emisor":    issuer.token(audience, "students:read", map[string]interface{}{"iss": "https://otro.example"}),
+		"caducado":       issuer.token(audience, "students:read", map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}),
+		"sin caducidad":  issuer.token(audience, "students:read", map[string]interface{}{"exp": nil}),
+		"aún no válido":  issuer.token(audience, "students:read", map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()}),
+		"firma falsa":    forged,
+		"alg none":       unsigned,
+		"no es un JWT":   "abc",
+	}
+	for name, token := range rejected {
+		if _, err := cfg.verify(token); err == nil {
+			t.Errorf("%s: el token debería rechazarse", name)
+		}
+	}
+}
+
+func TestOAuthES256AndAudienceList(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}},
+	})
+	keys, err := parseJWKS(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &OAuthConfig{Issuer: "https://emisor", Audience: "mcp", keys: &keySet{keys: keys}, now: time.Now}
+
+	// Sin kid vale la única clave del JWKS
+	token := signJWT(map[string]string{"alg": "ES256"}, map[string]interface{}{
+		"iss":       "https://emisor",
+		"aud":       []string{"otro", "mcp"},
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"client_id": "asistente-alumnos",
+		"scope":     "students:read",
+	}, func(digest []byte) []byte {
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest)
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	})
+
+	identity, err := cfg.verify(token)
+	if err != nil {
+		t.Fatalf("Token ES256 rechazado: %v", err)
+	}
+	if identity.Subject != "asistente-alumnos" {
+		t.Errorf("Sin sub la identidad es el client_id: %+v", identity)
+	}
+}
+
+func TestScopesLimitTools(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportHTTP, "", io.Discard)
+	sess.setIdentity(Identity{Subject: "asistente", Method: authOAuth, Scopes: []string{scopeRead}})
+
+	if _, enabled := s.findTool(sess, "list_students"); !enabled {
+		t.Error("students:read permite las herramientas de consulta")
+	}
+	if _, enabled := s.findTool(sess, "add_student"); enabled {
+		t.Error("add_student requiere students:write")
+	}
+
+	sess.setIdentity(Identity{Subject: "asistente", Method: authOAuth, Scopes: []string{scopeWrite}})
+	if _, enabled := s.findTool(sess, "add_student"); !enabled {
+		t.Error("students:write permite add_student")
+	}
+}
+
+func TestProtectedResourceMetadata(t *testing.T) {
+	issuer := newTestIssuer(t)
+	cfg := newTestOAuthConfig(t, issuer)
+
+	if got := cfg.metadataURL(); got != "https://mcp.ies.example/.well-known/oauth-protected-resource/mcp" {
+		t.Errorf("URL de metadatos incorrecta: %s", got)
+	}
+
+	recorder := httptest.NewRecorder()
+	cfg.serveMetadata(recorder, httptest.NewRequest(http.MethodGet, protectedResourcePath, nil))
+
+	var metadata struct {
+		Resource             string   `json:"resource"`
+		AuthorizationServers []string `json:"authorization_servers"`
+		ScopesSupported      []string `json:"scopes_supported"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &metadata)
+	if metadata.Resource != cfg.Resource || len(metadata.AuthorizationServers) != 1 || metadata.AuthorizationServers[0] != issuer.URL {
+		t.Errorf("Metadatos incorrectos: %s", recorder.Body.String())
+	}
+	if strings.Join(metadata.ScopesSupported, " ") != "students:read students:write" {
+		t.Errorf("Scopes incorrectos: %v", metadata.ScopesSupported)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ToolPolicy limita las herramientas que ve y puede llamar una sesión según
+// su transporte. Una lista Allow vacía permite todas; Deny tiene prioridad.
+type ToolPolicy struct {
+	ReadOnly bool
+	Allow    []string
+	Deny     []string
+}
+
+// loadToolPolicy lee la política de un transporte de <TRANSPORTE>_READ_ONLY,
+// <TRANSPORTE>_TOOLS_ALLOW y <TRANSPORTE>_TOOLS_DENY (por ejemplo
+// TCP_READ_ONLY=true). Si no están definidas se usan READ_ONLY, TOOLS_ALLOW y
+// TOOLS_DENY, comunes a todos los transportes.
+func loadToolPolicy(transport string) ToolPolicy {
+	prefix := strings.ToUpper(transport) + "_"
+	return ToolPolicy{
+		ReadOnly: boolFromEnv(prefix+"READ_ONLY", "READ_ONLY"),
+		Allow:    listFromEnv(prefix+"TOOLS_ALLOW", "TOOLS_ALLOW"),
+		Deny:     listFromEnv(prefix+"TOOLS_DENY", "TOOLS_DENY"),
+	}
+}
+
+// lookupEnv devuelve la primera variable definida de keys
+func lookupEnv(keys ...string) (string, string) {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return key, value
+		}
+	}
+	return "", ""
+}
+
+func boolFromEnv(keys ...string) bool {
+	key, value := lookupEnv(keys...)
+	if value == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Valor de configuración inválido, usando el valor por defecto", "variable", key, "valor", value, "defecto", false)
+		return false
+	}
+	return b
+}
+
+// listFromEnv interpreta una lista de nombres separados por comas
+func listFromEnv(keys ...string) []string {
+	_, value := lookupEnv(keys...)
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// permits indica si la política deja usar la herramienta
+func (p ToolPolicy) permits(tool Tool) bool {
+	if p.ReadOnly && !tool.isReadOnly() {
+		return false
+	}
+	if containsString(p.Deny, tool.Name) {
+		return false
+	}
+	return len(p.Allow) == 0 || containsString(p.Allow, tool.Name)
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// setToolPolicy fija la política de un transporte. Los nombres que no
+// corresponden a ninguna herramienta se avisan en el log porque suelen ser
+// erratas en la configuración.
+func (s *Server) setToolPolicy(transport string, policy ToolPolicy) {
+	for _, name := range append(append([]string{}, policy.Allow...), policy.Deny...) {
+		if _, ok := s.toolRegistry().lookup(name); !ok {
+			slog.Warn("Herramienta desconocida en la política", "transporte", transport, "herramienta", name)
+		}
+	}
+
+	s.mu.Lock()
+	if s.policies == nil {
+		s.policies = map[string]ToolPolicy{}
+	}
+	s.policies[transport] = policy
+	s.mu.Unlock()
+
+	if policy.ReadOnly || len(policy.Allow) > 0 || len(policy.Deny) > 0 {
+		slog.Info("Política de herramientas", "transporte", transport, "solo_lectura", policy.ReadOnly, "permitidas", policy.Allow, "denegadas", policy.Deny)
+	}
+}
+
+// toolPolicy devuelve la política del transporte de la sesión; sin política
+// configurada se permiten todas las herramientas
+func (s *Server) toolPolicy(sess *Session) ToolPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policies[sess.transport]
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Versión del formato de los documentos de estudiantes. Los documentos sin
+// schema_version son de la versión 1; al leerlos se migran en memoria y se
+// guardan con la versión actual la próxima vez que se reemplazan.
+//
+//	1: nombre y notas
+//	2: datos personales en "profile"
+const studentSchemaVersion = 2
+
+// Profile son los datos personales de un estudiante; todos son opcionales
+type Profile struct {
+	BirthDate     string     `bson:"birth_date,omitempty" json:"birth_date,omitempty" description:"Fecha de nacimiento (AAAA-MM-DD)" jsonschema:"format=date"`
+	StudentNumber string     `bson:"student_number,omitempty" json:"student_number,omitempty" description:"Número de expediente" jsonschema:"maxLength=50"`
+	Email         string     `bson:"email,omitempty" json:"email,omitempty" description:"Correo electrónico del estudiante" jsonschema:"maxLength=200,format=email"`
+	Guardians     []Guardian `bson:"guardians,omitempty" json:"guardians,omitempty" description:"Padres o tutores legales" jsonschema:"maxItems=4"`
+	// Observaciones internas; el propio estudiante no las ve
+	Notes string `bson:"notes,omitempty" json:"notes,omitempty" description:"Observaciones" jsonschema:"maxLength=2000"`
+	// Sube con cada update_student; las escrituras la exigen en el filtro
+	// para no pisar un cambio simultáneo del perfil
+	Version int `bson:"version,omitempty" json:"-"`
+}
+
+// Guardian es un padre, madre o tutor legal
+type Guardian struct {
+	Name         string `bson:"name" json:"name" description:"Nombre" jsonschema:"minLength=1,maxLength=200"`
+	Relationship string `bson:"relationship,omitempty" json:"relationship,omitempty" description:"Parentesco (madre, padre, tutor legal...)" jsonschema:"maxLength=50"`
+	Phone        string `bson:"phone,omitempty" json:"phone,omitempty" description:"Teléfono" jsonschema:"maxLength=30"`
+	Email        string `bson:"email,omitempty" json:"email,omitempty" description:"Correo electrónico" jsonschema:"maxLength=200,format=email"`
+}
+
+// studentMigrations[i] lleva un documento de la versión i+1 a la i+2. Reciben
+// el documento tal como está guardado para recuperar campos que Student ya no
+// tiene.
+var studentMigrations = []func(st *Student, raw bson.Raw) error{
+	migrateProfile,
+}
+
+// migrateProfile (1 → 2) mueve al perfil los datos personales que los
+// documentos anteriores pudieran tener en la raíz, añadidos a mano con los
+// mismos nombres
+func migrateProfile(st *Student, raw bson.Raw) error {
+	if st.Profile != nil {
+		return nil
+	}
+	var legacy Profile
+	if err := bson.Unmarshal(raw, &legacy); err != nil {
+		return fmt.Errorf("perfil: %v", err)
+	}
+	if !legacy.isEmpty() {
+		st.Profile = &legacy
+	}
+	return nil
+}
+
+// migrate lleva el estudiante leído a la versión actual del formato
+func (st *Student) migrate(raw bson.Raw) error {
+	version := st.SchemaVersion
+	if version < 1 {
+		version = 1
+	}
+	if version > studentSchemaVersion {
+		return fmt.Errorf("formato de estudiante %d desconocido; la versión más reciente es %d", version, studentSchemaVersion)
+	}
+	for ; version < studentSchemaVersion; version++ {
+		if err := studentMigrations[version-1](st, raw); err != nil {
+			return fmt.Errorf("migrando a la versión %d: %v", version+1, err)
+		}
+	}
+	st.SchemaVersion = studentSchemaVersion
+	return nil
+}
+
+func (p *Profile) isEmpty() bool {
+	return p == nil || (p.BirthDate == "" && p.StudentNumber == "" && p.Email == "" && len(p.Guardians) == 0 && p.Notes == "")
+}
+
+func (p *Profile) clone() *Profile {
+	if p == nil {
+		return nil
+	}
+	cloned := *p
+	cloned.Guardians = append([]Guardian(nil), p.Guardians...)
+	return &cloned
+}
+
+// version devuelve la versión del perfil; sin perfil es la 0
+func (p *Profile) version() int {
+	if p == nil {
+		return 0
+	}
+	return p.Version
+}
+
+// sameProfile compara dos perfiles; nil y el perfil vacío son iguales
+func sameProfile(a, b *Profile) bool {
+	if a.isEmpty() || b.isEmpty() {
+		return a.isEmpty() && b.isEmpty()
+	}
+	x, y := *a, *b
+	x.Version, y.Version = 0, 0
+	if len(x.Guardians) == 0 && len(y.Guardians) == 0 {
+		x.Guardians, y.Guardians = nil, nil
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// mergeProfile aplica un cambio parcial al perfil: los campos que trae patch
+// sustituyen a los actuales (los tutores, la lista entera) y los de clear se
+// borran
+func mergeProfile(current, patch *Profile, clear []string) *Profile {
+	merged := current.clone()
+	if merged == nil {
+		merged = &Profile{}
+	}
+	if patch != nil {
+		if patch.BirthDate != "" {
+			merged.BirthDate = patch.BirthDate
+		}
+		if patch.StudentNumber != "" {
+			merged.StudentNumber = patch.StudentNumber
+		}
+		if patch.Email != "" {
+			merged.Email = patch.Email
+		}
+		if len(patch.Guardians) > 0 {
+			merged.Guardians = append([]Guardian(nil), patch.Guardians...)
+		}
+		if patch.Notes != "" {
+			merged.Notes = patch.Notes
+		}
+	}
+
+	for _, field := range clear {
+		switch field {
+		case "birth_date":
+			merged.BirthDate = ""
+		case "student_number":
+			merged.StudentNumber = ""
+		case "email":
+			merged.Email = ""
+		case "guardians":
+			merged.Guardians = nil
+		case "notes":
+			merged.Notes = ""
+		}
+	}
+
+	if merged.isEmpty() {
+		return nil
+	}
+	return merged
+}
+
+type updateStudentArgs struct {
+	Name    string   `json:"name,omitempty" description:"Nombre actual del estudiante" jsonschema:"minLength=1,maxLength=200"`
+	ID      string   `json:"id,omitempty" description:"Id del estudiante, en lugar del nombre; necesario si hay varios con el mismo nombre" jsonschema:"pattern=^[0-9a-f]{24}$"`
+	NewName string   `json:"new_name,omitempty" description:"Nuevo nombre" jsonschema:"minLength=1,maxLength=200"`
+	Profile *Profile `json:"profile,omitempty" description:"Datos personales que cambian; los que no se indican se conservan"`
+	Clear   []string `json:"clear,omitempty" description:"Datos personales que se borran" values:"enum=birth_date|student_number|email|guardians|notes"`
+	Reason  string   `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+// updateStudent cambia el nombre o los datos personales de un estudiante. El
+// documento se reescribe entero, así que queda guardado en el formato actual.
+func (s *Server) updateStudent(ctx context.Context, req *ToolRequest, ref studentRef, newName string, patch *Profile, clear []string) (interface{}, error) {
+	if req.Role.Name != roleAdmin {
+		return nil, fmt.Errorf("%w: solo un administrador puede modificar los datos de un estudiante", errForbidden)
+	}
+	if newName == "" && patch.isEmpty() && len(clear) == 0 {
+		return nil, &InvalidParamsError{Message: "argumento '/profile': indica un nombre nuevo o los datos que cambian", Pointer: "/profile"}
+	}
+
+	found, err := s.findStudent(ctx, req.Session, req.Role, ref)
+	if err != nil {
+		return nil, err
+	}
+	var before Student
+	if err := s.collection.FindOne(ctx, bson.M{"_id": found.ID}).Decode(&before); err != nil {
+		return nil, err
+	}
+
+	after := before.clone()
+	if newName != "" && newName != before.Name {
+		// Quien ROLES_FILE asigna por nombre perdería el acceso o lo ganaría sobre otro
+		for _, name := range []string{before.Name, newName} {
+			if subject, bound := s.nameBinding(name); bound {
+				return nil, &InvalidParamsError{
+					Message: fmt.Sprintf("argumento '/new_name': '%s' está asignado por nombre a %s en ROLES_FILE; asígnalo por student_number antes de cambiar el nombre", name, subject),
+					Pointer: "/new_name",
+				}
+			}
+		}
+		after.Name = newName
+		if before.NameKey != "" {
+			after.NameKey = subjectKey(newName)
+		}
+	}
+	after.Profile = mergeProfile(before.Profile, patch, clear)
+	if sameStudent(&before, &after) {
+		return map[string]interface{}{
+			"message": "Sin cambios",
+			"student": before,
+		}, nil
+	}
+
+	// Aunque se borren todos los datos, el perfil conserva su versión
+	if after.Profile == nil {
+		after.Profile = &Profile{}
+	}
+	after.Profile.Version = before.Profile.version() + 1
+
+	// Solo se escriben el nombre y el perfil: las notas y las evaluaciones
+	// cerradas mientras tanto se conservan
+	set := bson.M{"name": after.Name, "name_words": nameWords(after.Name), "profile": after.Profile}
+	if after.NameKey != "" {
+		set["name_key"] = after.NameKey
+	}
+	result, err := s.collection.UpdateOne(ctx, studentStateFilter(&before), bson.M{"$set": set})
+	if mongo.IsDuplicateKeyError(err) {
+		if strings.Contains(err.Error(), "name_key") {
+			return nil, fmt.Errorf("ya hay un estudiante llamado '%s'", after.Name)
+		}
+		return nil, fmt.Errorf("el número de expediente %s ya es de otro estudiante", after.Profile.StudentNumber)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, fmt.Errorf("%s ha cambiado mientras se actualizaba; vuelve a intentarlo", before.Name)
+	}
+	req.RecordChange(Change{StudentID: before.ID, StudentName: after.Name, Before: &before, After: &after})
+
+	return map[string]interface{}{
+		"message": "Estudiante actualizado",
+		"student": after,
+	}, nil
+}
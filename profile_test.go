@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStudentMigrationOnRead(t *testing.T) {
+	// Documento de la versión 1 con datos personales añadidos a mano en la raíz
+	data, _ := bson.Marshal(bson.M{
+		"_id":            primitive.NewObjectID(),
+		"name":           "Ana",
+		"subjects":       bson.M{"matematicas": 8.5},
+		"email":          "ana@example.com",
+		"student_number": "2024-017",
+	})
+
+	var student Student
+	if err := bson.Unmarshal(data, &student); err != nil {
+		t.Fatalf("Error leyendo un documento antiguo: %v", err)
+	}
+	if student.SchemaVersion != studentSchemaVersion {
+		t.Errorf("Versión tras la migración: %d", student.SchemaVersion)
+	}
+	if student.Profile == nil || student.Profile.Email != "ana@example.com" || student.Profile.StudentNumber != "2024-017" {
+		t.Errorf("Los datos de la raíz pasan al perfil: %+v", student.Profile)
+	}
+	if student.Subjects["matematicas"] != 8.5 {
+		t.Errorf("La migración conserva las notas: %v", student.Subjects)
+	}
+
+	// Al guardarlo queda en el formato actual
+	saved, err := bson.Marshal(student)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(saved, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["schema_version"] != int32(studentSchemaVersion) || doc["email"] != nil {
+		t.Errorf("Documento guardado incorrecto: %v", doc)
+	}
+	if profile, _ := doc["profile"].(bson.M); profile["email"] != "ana@example.com" {
+		t.Errorf("El perfil se guarda en 'profile': %v", doc["profile"])
+	}
+
+	// Sin datos personales no hay perfil
+	data, _ = bson.Marshal(bson.M{"name": "Luis", "subjects": bson.M{}})
+	var plain Student
+	if err := bson.Unmarshal(data, &plain); err != nil || plain.Profile != nil {
+		t.Errorf("Un documento sin datos personales no tiene perfil: %+v %v", plain.Profile, err)
+	}
+}
+
+func TestStudentUnknownSchemaVersion(t *testing.T) {
+	data, _ := bson.Marshal(bson.M{"name": "Ana", "subjects": bson.M{}, "schema_version": studentSchemaVersion + 1})
+	var student Student
+	if err := bson.Unmarshal(data, &student); err == nil {
+		t.Error("Una versión posterior a la del servidor es un error")
+	}
+}
+
+func TestMergeProfile(t *testing.T) {
+	current := &Profile{
+		Email:     "ana@example.com",
+		Guardians: []Guardian{{Name: "Rosa", Relationship: "madre"}},
+		Notes:     "Necesita apoyo en lectura",
+	}
+
+	merged := mergeProfile(current, &Profile{BirthDate: "2010-05-04", Email: "ana.lopez@example.com"}, []string{"notes"})
+	if merged.BirthDate != "2010-05-04" || merged.Email != "ana.lopez@example.com" || merged.Notes != "" || len(merged.Guardians) != 1 {
+		t.Errorf("Perfil combinado incorrecto: %+v", merged)
+	}
+	if current.Email != "ana@example.com" || current.Notes == "" {
+		t.Errorf("El perfil original no se modifica: %+v", current)
+	}
+
+	if cleared := mergeProfile(current, nil, []string{"email", "guardians", "notes"}); cleared != nil {
+		t.Errorf("Sin datos no queda perfil: %+v", cleared)
+	}
+	if created := mergeProfile(nil, &Profile{StudentNumber: "17"}, nil); created == nil || created.StudentNumber != "17" {
+		t.Errorf("Perfil nuevo incorrecto: %+v", created)
+	}
+}
+
+func TestSameProfile(t *testing.T) {
+	if !sameProfile(nil, &Profile{Guardians: []Guardian{}}) {
+		t.Error("nil y el perfil vacío son iguales")
+	}
+	a := &Profile{Email: "ana@example.com"}
+	if !sameProfile(a, &Profile{Email: "ana@example.com", Guardians: []Guardian{}}) {
+		t.Error("Una lista de tutores vacía equivale a ninguna")
+	}
+	if sameProfile(a, &Profile{Email: "otra@example.com"}) || sameProfile(a, nil) {
+		t.Error("Perfiles distintos")
+	}
+}
+
+func TestStudentStateFilterChecksProfileVersion(t *testing.T) {
+	student := Student{ID: testAnaID, Name: "Ana", Subjects: map[string]float64{}}
+	if filter := studentStateFilter(&student); !reflect.DeepEqual(filter["profile.version"], bson.M{"$exists": false}) {
+		t.Errorf("Sin versión, el perfil no puede tenerla: %v", filter)
+	}
+	student.Profile = &Profile{Email: "ana@example.com", Version: 3}
+	if filter := studentStateFilter(&student); filter["profile.version"] != 3 {
+		t.Errorf("El filtro exige la versión leída: %v", filter)
+	}
+
+	// La versión no cuenta como un cambio de los datos
+	if !sameProfile(student.Profile, &Profile{Email: "ana@example.com", Version: 4}) {
+		t.Error("Perfiles con los mismos datos y distinta versión son iguales")
+	}
+}
+
+func TestRedactProfile(t *testing.T) {
+	student := Student{
+		ID:       testAnaID,
+		Name:     "Ana",
+		Subjects: map[string]float64{"matematicas": 8},
+		Profile:  &Profile{Email: "ana@example.com", Notes: "Observación interna"},
+	}
+
+	if redacted, _ := testRoles["profesora.garcia"].redact(student); redacted.Profile != nil {
+		t.Errorf("Un profesor no ve los datos personales: %+v", redacted.Profile)
+	}
+	redacted, _ := testRoles["ana"].redact(student)
+	if redacted.Profile == nil || redacted.Profile.Email == "" || redacted.Profile.Notes != "" {
+		t.Errorf("Un estudiante ve su perfil sin observaciones: %+v", redacted.Profile)
+	}
+	if student.Profile.Notes == "" {
+		t.Error("Redactar no modifica el original")
+	}
+	if redacted, _ := adminRole.redact(student); redacted.Profile.Notes == "" {
+		t.Error("Un administrador lo ve todo")
+	}
+}
+
+func TestUpdateStudentValidation(t *testing.T) {
+	s := &Server{}
+	ctx := context.Background()
+
+	teacher := &ToolRequest{Session: sessionFor("profesora.garcia", authMTLS), Role: testRoles["profesora.garcia"]}
+	if _, err := s.updateStudent(ctx, teacher, studentRef{Name: "Ana"}, "", &Profile{Email: "ana@example.com"}, nil); !errors.Is(err, errForbidden) {
+		t.Errorf("Solo un administrador modifica estudiantes: %v", err)
+	}
+
+	admin := &ToolRequest{Session: sessionFor("admin", authMTLS), Role: adminRole}
+	var invalid *InvalidParamsError
+	if _, err := s.updateStudent(ctx, admin, studentRef{Name: "Ana"}, "", &Profile{}, nil); !errors.As(err, &invalid) {
+		t.Errorf("Una modificación sin cambios es un argumento inválido: %v", err)
+	}
+}
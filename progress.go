@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Cada cuántos documentos informan de su avance las herramientas que recorren
+// la colección completa
+const progressStep = 100
+
+// ProgressReporter envía notifications/progress para una petición que trae
+// _meta.progressToken. Un reporter nil no hace nada, así que las herramientas
+// pueden usarlo sin comprobar si el cliente pidió progreso.
+type ProgressReporter struct {
+	sess  *Session
+	token interface{}
+
+	mu   sync.Mutex
+	last float64
+	sent bool
+}
+
+// newProgressReporter devuelve nil si la petición no pidió progreso
+func newProgressReporter(sess *Session, params interface{}) *ProgressReporter {
+	var request struct {
+		Meta struct {
+			ProgressToken interface{} `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if err := decodeParams(params, &request); err != nil || request.Meta.ProgressToken == nil {
+		return nil
+	}
+
+	return &ProgressReporter{sess: sess, token: request.Meta.ProgressToken}
+}
+
+// Report notifica el avance. total puede ser 0 si se desconoce. El protocolo
+// exige que el progreso crezca, así que los valores repetidos se descartan.
+func (p *ProgressReporter) Report(progress, total float64, message string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	if p.sent && progress <= p.last {
+		p.mu.Unlock()
+		return
+	}
+	p.last = progress
+	p.sent = true
+	p.mu.Unlock()
+
+	params := map[string]interface{}{
+		"progressToken": p.token,
+		"progress":      progress,
+	}
+	if total > 0 {
+		params["total"] = total
+	}
+	// El campo message apareció en 2025-03-26
+	if message != "" && p.sess.atLeast(protocolVersion20250326) {
+		params["message"] = message
+	}
+
+	p.sess.notify("notifications/progress", params)
+}
+
+// notify envía una notificación al cliente por el transporte de la sesión
+func (sess *Session) notify(method string, params interface{}) error {
+	data, err := json.Marshal(MCPMessage{
+		JsonRPC: "2.0",
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+	return sess.write(data)
+}
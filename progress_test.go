@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestProgressReporterRequiresToken(t *testing.T) {
+	sess := newSession(transportStdio, "", &bytes.Buffer{})
+
+	if newProgressReporter(sess, map[string]interface{}{"name": "list_students"}) != nil {
+		t.Error("Sin progressToken no debería crearse un reporter")
+	}
+
+	// Un reporter nil no debe fallar
+	var progress *ProgressReporter
+	progress.Report(1, 2, "sin efecto")
+}
+
+func TestProgressReporterSendsNotifications(t *testing.T) {
+	var out bytes.Buffer
+	sess := newSession(transportStdio, "", &out)
+	sess.initialize(InitializeParams{ProtocolVersion: "2025-06-18"})
+
+	progress := newProgressReporter(sess, map[string]interface{}{
+		"name":  "list_students",
+		"_meta": map[string]interface{}{"progressToken": "tok-1"},
+	})
+	if progress == nil {
+		t.Fatal("Se esperaba un reporter")
+	}
+
+	progress.Report(100, 250, "100 estudiantes leídos")
+	progress.Report(100, 250, "repetido")
+	progress.Report(250, 250, "Listado completado")
+
+	var notifications []map[string]interface{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var msg MCPMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatalf("Notificación inválida: %s", scanner.Bytes())
+		}
+		if msg.Method != "notifications/progress" || msg.ID != nil {
+			t.Errorf("Notificación incorrecta: %+v", msg)
+		}
+		notifications = append(notifications, msg.Params.(map[string]interface{}))
+	}
+
+	if len(notifications) != 2 {
+		t.Fatalf("Se esperaban 2 notificaciones (el valor repetido se descarta), obtenidas %d", len(notifications))
+	}
+	first := notifications[0]
+	if first["progressToken"] != "tok-1" || first["progress"] != 100.0 || first["total"] != 250.0 {
+		t.Errorf("Parámetros incorrectos: %v", first)
+	}
+	if first["message"] != "100 estudiantes leídos" {
+		t.Errorf("Mensaje incorrecto: %v", first["message"])
+	}
+}
+
+func TestProgressMessageOmittedForOldProtocol(t *testing.T) {
+	var out bytes.Buffer
+	sess := newSession(transportStdio, "", &out)
+	sess.initialize(InitializeParams{ProtocolVersion: "2024-11-05"})
+
+	progress := newProgressReporter(sess, map[string]interface{}{
+		"_meta": map[string]interface{}{"progressToken": 5},
+	})
+	progress.Report(1, 0, "no soportado")
+
+	var msg MCPMessage
+	if err := json.Unmarshal(bytes.TrimSpace(out.Bytes()), &msg); err != nil {
+		t.Fatalf("Notificación inválida: %s", out.Bytes())
+	}
+	params := msg.Params.(map[string]interface{})
+	if _, ok := params["message"]; ok {
+		t.Error("message no existe en 2024-11-05")
+	}
+	if _, ok := params["total"]; ok {
+		t.Error("total desconocido no debería enviarse")
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Roles de acceso a los datos
+const (
+	roleAdmin   = "admin"   // acceso completo
+	roleTeacher = "teacher" // notas de sus asignaturas; escribe solo en ellas
+	roleTutor   = "tutor"   // estudiantes de su grupo, solo lectura
+	roleStudent = "student" // sus propios datos, solo lectura
+)
+
+var errForbidden = errors.New("acceso denegado")
+
+// Role es el rol de una identidad y el ámbito de datos que abarca
+type Role struct {
+	Name string `json:"role"`
+	// Asignaturas que imparte un profesor, además de las que tiene asignadas
+	// en el catálogo
+	Subjects []string `json:"subjects,omitempty"`
+	// Estudiantes de un tutor, además de los de los grupos que tutoriza: por
+	// número de expediente o, si no lo tienen, por nombre
+	StudentNumbers []string `json:"student_numbers,omitempty"`
+	Students       []string `json:"students,omitempty"`
+	// El propio estudiante: por número de expediente o por nombre
+	StudentNumber string `json:"student_number,omitempty"`
+	Student       string `json:"student,omitempty"`
+	// Ids de los estudiantes que ve un tutor o un estudiante; se resuelven en
+	// cada llamada (ver requestRole)
+	StudentIDs []primitive.ObjectID `json:"-"`
+}
+
+// adminRole es el rol sin restricciones: el de todas las sesiones cuando no
+// hay ROLES_FILE y el de stdio si no tiene un rol asignado
+var adminRole = Role{Name: roleAdmin}
+
+// loadRoleBindings lee ROLES_FILE: un objeto JSON que asigna a cada sujeto
+// autenticado (el sub del token OAuth, el CN del certificado...) su rol.
+// Devuelve nil si no está definido, y entonces no se aplica RBAC.
+//
+//	{"profesora.garcia": {"role": "teacher", "subjects": ["matematicas"]}}
+func loadRoleBindings() (map[string]Role, error) {
+	file := os.Getenv("ROLES_FILE")
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error leyendo ROLES_FILE: %v", err)
+	}
+	var bindings map[string]Role
+	if err := json.Unmarshal(data, &bindings); err != nil {
+		return nil, fmt.Errorf("ROLES_FILE inválido: %v", err)
+	}
+
+	for subject, role := range bindings {
+		if err := role.validate(); err != nil {
+			return nil, fmt.Errorf("ROLES_FILE, %s: %v", subject, err)
+		}
+	}
+	return bindings, nil
+}
+
+func (role Role) validate() error {
+	switch role.Name {
+	case roleAdmin:
+	case roleTeacher:
+		// Las asignaturas pueden venir del catálogo
+	case roleTutor:
+		// Los estudiantes pueden venir de los grupos que tutoriza
+	case roleStudent:
+		if role.Student == "" && role.StudentNumber == "" {
+			return errors.New("un estudiante necesita 'student_number' o 'student'")
+		}
+	default:
+		return fmt.Errorf("rol desconocido %q", role.Name)
+	}
+	return nil
+}
+
+// roleFor devuelve el rol de la sesión según su identidad. Una identidad sin
+// rol asignado recibe el rol vacío, que no da acceso a nada.
+func (s *Server) roleFor(sess *Session) Role {
+	if s.roles == nil {
+		return adminRole
+	}
+
+	identity, ok := sess.Identity()
+	if !ok {
+		return Role{}
+	}
+	if role, bound := s.roles[identity.Subject]; bound {
+		return role
+	}
+	if identity.Method == authLocal {
+		return adminRole
+	}
+	return Role{}
+}
+
+// boundStudents resuelve los estudiantes que ROLES_FILE asigna por número de
+// expediente o por nombre. Un nombre que tienen varios estudiantes no da
+// acceso a ninguno y se devuelve en ambiguous: hay que asignarlo por número.
+func (s *Server) boundStudents(ctx context.Context, names, numbers []string) (ids []primitive.ObjectID, ambiguous []string, err error) {
+	if s.collection == nil || (len(names) == 0 && len(numbers) == 0) {
+		return nil, nil, nil
+	}
+
+	var clauses bson.A
+	if len(names) > 0 {
+		clauses = append(clauses, bson.M{"name": bson.M{"$in": names}})
+	}
+	if len(numbers) > 0 {
+		clauses = append(clauses, bson.M{"profile.student_number": bson.M{"$in": numbers}})
+	}
+	projection := bson.M{"name": 1, "profile.student_number": 1}
+	cursor, err := s.collection.Find(ctx, bson.M{"$or": clauses}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var found []struct {
+		ID      primitive.ObjectID `bson:"_id"`
+		Name    string             `bson:"name"`
+		Profile struct {
+			StudentNumber string `bson:"student_number"`
+		} `bson:"profile"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, nil, err
+	}
+
+	byName := map[string][]primitive.ObjectID{}
+	for _, student := range found {
+		if student.Profile.StudentNumber != "" && containsString(numbers, student.Profile.StudentNumber) {
+			ids = append(ids, student.ID)
+		}
+		byName[student.Name] = append(byName[student.Name], student.ID)
+	}
+	for _, name := range names {
+		switch matches := byName[name]; len(matches) {
+		case 0:
+		case 1:
+			ids = append(ids, matches[0])
+		default:
+			ambiguous = append(ambiguous, name)
+		}
+	}
+	return ids, ambiguous, nil
+}
+
+// nameBinding devuelve una identidad de ROLES_FILE que se refiere por su
+// nombre a un estudiante llamado así. Mientras la haya, ese nombre no puede
+// repetirse ni cambiar sin que la identidad pierda el acceso.
+func (s *Server) nameBinding(name string) (string, bool) {
+	key := subjectKey(name)
+	for subject, role := range s.roles {
+		if role.Name == roleStudent && role.StudentNumber == "" && subjectKey(role.Student) == key {
+			return subject, true
+		}
+		if role.Name == roleTutor {
+			for _, student := range role.Students {
+				if subjectKey(student) == key {
+					return subject, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// canUse indica si el rol puede usar la herramienta: tutores y estudiantes
+// solo consultan
+func (role Role) canUse(tool Tool) bool {
+	switch role.Name {
+	case roleAdmin, roleTeacher:
+		return true
+	case roleTutor, roleStudent:
+		return tool.isReadOnly()
+	default:
+		return false
+	}
+}
+
+// studentFilter limita en MongoDB los estudiantes que el rol puede ver
+func (role Role) studentFilter() bson.M {
+	switch role.Name {
+	case roleAdmin:
+		return bson.M{}
+	case roleTeacher:
+		// Sin asignaturas ni en ROLES_FILE ni en el catálogo no ve a nadie;
+		// MongoDB rechaza un $or vacío
+		if len(role.Subjects) == 0 {
+			return bson.M{"_id": nil}
+		}
+		taught := make(bson.A, len(role.Subjects))
+		for i, subject := range role.Subjects {
+			taught[i] = bson.M{"subjects." + subject: bson.M{"$exists": true}}
+		}
+		return bson.M{"$or": taught}
+	case roleTutor, roleStudent:
+		ids := role.StudentIDs
+		if ids == nil {
+			ids = []primitive.ObjectID{}
+		}
+		return bson.M{"_id": bson.M{"$in": ids}}
+	default:
+		// Ningún documento tiene un _id nulo
+		return bson.M{"_id": nil}
+	}
+}
+
+// restrict combina filter con el ámbito del rol
+func (role Role) restrict(filter bson.M) bson.M {
+	scope := role.studentFilter()
+	if len(scope) == 0 {
+		return filter
+	}
+	if len(filter) == 0 {
+		return scope
+	}
+	return bson.M{"$and": bson.A{filter, scope}}
+}
+
+// redact devuelve lo que el rol puede ver de un estudiante: los profesores
+// solo ven las notas de sus asignaturas, sin datos personales, y un
+// estudiante no ve las observaciones de su perfil. false si no puede verlo.
+func (role Role) redact(student Student) (Student, bool) {
+	switch role.Name {
+	case roleAdmin:
+		return student, true
+	case roleTeacher:
+		grades := map[string]float64{}
+		var assessments map[string][]Assessment
+		for subject, grade := range student.Subjects {
+			if containsString(role.Subjects, subject) {
+				grades[subject] = grade
+				if list, ok := student.Assessments[subject]; ok {
+					if assessments == nil {
+						assessments = map[string][]Assessment{}
+					}
+					assessments[subject] = list
+				}
+			}
+		}
+		if len(grades) == 0 {
+			return Student{}, false
+		}
+		student.Subjects = grades
+		student.Assessments = assessments
+		student.TermGrades = role.redactTermGrades(student.TermGrades)
+		student.Profile = nil
+		return student, true
+	case roleTutor:
+		if !role.covers(student) {
+			return Student{}, false
+		}
+		return student, true
+	case roleStudent:
+		if !role.covers(student) {
+			return Student{}, false
+		}
+		if student.Profile != nil {
+			student.Profile = student.Profile.clone()
+			student.Profile.Notes = ""
+		}
+		return student, true
+	default:
+		return Student{}, false
+	}
+}
+
+// covers indica si el estudiante está entre los de un tutor o es el propio
+// estudiante
+func (role Role) covers(student Student) bool {
+	for _, id := range role.StudentIDs {
+		if id == student.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// redactTermGrades deja en las notas cerradas solo las asignaturas del profesor
+func (role Role) redactTermGrades(termGrades map[string]map[string]map[string]float64) map[string]map[string]map[string]float64 {
+	if len(termGrades) == 0 {
+		return nil
+	}
+	redacted := map[string]map[string]map[string]float64{}
+	for year, terms := range termGrades {
+		for term, grades := range terms {
+			for subject, grade := range grades {
+				if !containsString(role.Subjects, subject) {
+					continue
+				}
+				if redacted[year] == nil {
+					redacted[year] = map[string]map[string]float64{}
+				}
+				if redacted[year][term] == nil {
+					redacted[year][term] = map[string]float64{}
+				}
+				redacted[year][term][subject] = grade
+			}
+		}
+	}
+	return redacted
+}
+
+// canSeeSubject indica si el rol puede consultar una asignatura completa
+func (role Role) canSeeSubject(subject string) bool {
+	return role.Name != roleTeacher || containsString(role.Subjects, subject)
+}
+
+// checkGradesWrite comprueba que el rol puede poner estas notas
+func (role Role) checkGradesWrite(grades map[string]float64) error {
+	switch role.Name {
+	case roleAdmin:
+		return nil
+	case roleTeacher:
+		var others []string
+		for subject := range grades {
+			if !containsString(role.Subjects, subject) {
+				others = append(others, subject)
+			}
+		}
+		if len(others) > 0 {
+			sort.Strings(others)
+			return fmt.Errorf("%w: no impartes %v", errForbidden, others)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: el rol %q no puede modificar notas", errForbidden, role.Name)
+	}
+}
+
+// checkAttendanceWrite comprueba que el rol puede pasar lista en la asignatura
+func (role Role) checkAttendanceWrite(subject string) error {
+	switch role.Name {
+	case roleAdmin:
+		return nil
+	case roleTeacher:
+		if !containsString(role.Subjects, subject) {
+			return fmt.Errorf("%w: no impartes %s", errForbidden, subject)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: el rol %q no puede registrar la asistencia", errForbidden, role.Name)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ids de los estudiantes de testRoles, como los resolvería requestRole
+var testAnaID, testLuisID = primitive.NewObjectID(), primitive.NewObjectID()
+
+var testRoles = map[string]Role{
+	"profesora.garcia": {Name: roleTeacher, Subjects: []string{"matematicas"}},
+	"tutor.ruiz":       {Name: roleTutor, Students: []string{"Ana", "Luis"}, StudentIDs: []primitive.ObjectID{testAnaID, testLuisID}},
+	"ana":              {Name: roleStudent, StudentNumber: "2024-017", StudentIDs: []primitive.ObjectID{testAnaID}},
+}
+
+func sessionFor(subject, method string) *Session {
+	sess := newSession(transportHTTP, "", io.Discard)
+	sess.setIdentity(Identity{Subject: subject, Method: method})
+	return sess
+}
+
+func TestRoleForIdentity(t *testing.T) {
+	s := &Server{roles: testRoles}
+
+	cases := []struct {
+		sess *Session
+		role string
+	}{
+		{sessionFor("profesora.garcia", authOAuth), roleTeacher},
+		{sessionFor("ana", authMTLS), roleStudent},
+		{sessionFor(authLocal, authLocal), roleAdmin},
+		{sessionFor("desconocido", authOAuth), ""},
+		{newSession(transportTCP, "", io.Discard), ""},
+	}
+	for _, c := range cases {
+		if role := s.roleFor(c.sess); role.Name != c.role {
+			t.Errorf("%v: rol %q, esperado %q", c.sess.identity, role.Name, c.role)
+		}
+	}
+
+	// Sin ROLES_FILE todos tienen acceso completo
+	if role := (&Server{}).roleFor(sessionFor("desconocido", authOAuth)); role.Name != roleAdmin {
+		t.Errorf("Sin roles configurados se esperaba admin: %q", role.Name)
+	}
+}
+
+func TestRoleLimitsTools(t *testing.T) {
+	s := &Server{roles: testRoles}
+
+	cases := []struct {
+		subject  string
+		tool     string
+		expected bool
+	}{
+		{"profesora.garcia", "add_student", true},
+		{"tutor.ruiz", "add_student", false},
+		{"tutor.ruiz", "get_student_grades", true},
+		{"ana", "add_student", false},
+		{"ana", "generate_report_card", true},
+		{"desconocido", "list_students", false},
+	}
+	for _, c := range cases {
+		if _, enabled := s.findTool(sessionFor(c.subject, authMTLS), c.tool); enabled != c.expected {
+			t.Errorf("%s/%s: habilitada = %v", c.subject, c.tool, enabled)
+		}
+	}
+}
+
+func TestRoleRedactsStudents(t *testing.T) {
+	ana := Student{ID: testAnaID, Name: "Ana", Subjects: map[string]float64{"matematicas": 8, "historia": 6}}
+	pedro := Student{ID: primitive.NewObjectID(), Name: "Pedro", Subjects: map[string]float64{"historia": 7}}
+
+	teacher := testRoles["profesora.garcia"]
+	if got, ok := teacher.redact(ana); !ok || !reflect.DeepEqual(got.Subjects, map[string]float64{"matematicas": 8}) {
+		t.Errorf("El profesor solo ve sus asignaturas: %v %v", got.Subjects, ok)
+	}
+	if ana.Subjects["historia"] != 6 {
+		t.Error("redact no debe modificar el original")
+	}
+	if _, ok := teacher.redact(pedro); ok {
+		t.Error("El profesor no ve a quien no tiene notas de sus asignaturas")
+	}
+
+	if _, ok := testRoles["tutor.ruiz"].redact(pedro); ok {
+		t.Error("El tutor solo ve a su grupo")
+	}
+	if got, ok := testRoles["ana"].redact(ana); !ok || len(got.Subjects) != 2 {
+		t.Errorf("La estudiante ve todas sus notas: %v", got.Subjects)
+	}
+	if _, ok := testRoles["ana"].redact(pedro); ok {
+		t.Error("La estudiante no ve a otros")
+	}
+	namesake := Student{ID: primitive.NewObjectID(), Name: "Ana", Subjects: map[string]float64{"historia": 3}}
+	if _, ok := testRoles["ana"].redact(namesake); ok {
+		t.Error("La estudiante no ve a otra con su mismo nombre")
+	}
+	if _, ok := (Role{}).redact(ana); ok {
+		t.Error("Sin rol no se ve nada")
+	}
+}
+
+func TestRoleQueryFilters(t *testing.T) {
+	student := testRoles["ana"]
+	expected := bson.M{"$and": bson.A{bson.M{"name": "Luis"}, bson.M{"_id": bson.M{"$in": []primitive.ObjectID{testAnaID}}}}}
+	if got := student.restrict(bson.M{"name": "Luis"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Filtro incorrecto: %v", got)
+	}
+	if got := adminRole.restrict(bson.M{"name": "Luis"}); !reflect.DeepEqual(got, bson.M{"name": "Luis"}) {
+		t.Errorf("admin no restringe: %v", got)
+	}
+
+	teacher := testRoles["profesora.garcia"].studentFilter()
+	if !reflect.DeepEqual(teacher, bson.M{"$or": bson.A{bson.M{"subjects.matematicas": bson.M{"$exists": true}}}}) {
+		t.Errorf("Filtro de profesor incorrecto: %v", teacher)
+	}
+
+	// Un profesor sin asignaturas no ve a nadie
+	if filter := (Role{Name: roleTeacher}).studentFilter(); !reflect.DeepEqual(filter, bson.M{"_id": nil}) {
+		t.Errorf("Filtro de profesor sin asignaturas incorrecto: %v", filter)
+	}
+
+	if testRoles["profesora.garcia"].canSeeSubject("historia") || !testRoles["profesora.garcia"].canSeeSubject("matematicas") {
+		t.Error("El profesor solo consulta sus asignaturas")
+	}
+}
+
+func TestRoleGradeWrites(t *testing.T) {
+	teacher := testRoles["profesora.garcia"]
+	if err := teacher.checkGradesWrite(map[string]float64{"matematicas": 9}); err != nil {
+		t.Errorf("El profesor puede poner notas de su asignatura: %v", err)
+	}
+	if err := teacher.checkGradesWrite(map[string]float64{"matematicas": 9, "historia": 5}); !errors.Is(err, errForbidden) {
+		t.Errorf("Se esperaba acceso denegado: %v", err)
+	}
+	if err := testRoles["tutor.ruiz"].checkGradesWrite(map[string]float64{"matematicas": 9}); !errors.Is(err, errForbidden) {
+		t.Errorf("El tutor no escribe notas: %v", err)
+	}
+}
+
+func TestLoadRoleBindings(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "roles.json")
+		os.WriteFile(path, []byte(content), 0o600)
+		return path
+	}
+
+	t.Setenv("ROLES_FILE", write(`{"profesora.garcia": {"role": "teacher", "subjects": ["matematicas"]}}`))
+	bindings, err := loadRoleBindings()
+	if err != nil || bindings["profesora.garcia"].Name != roleTeacher {
+		t.Fatalf("Roles mal cargados: %v %v", bindings, err)
+	}
+
+	// Los estudiantes de un tutor pueden venir solo de sus grupos
+	t.Setenv("ROLES_FILE", write(`{"tutor.ruiz": {"role": "tutor"}}`))
+	if _, err := loadRoleBindings(); err != nil {
+		t.Errorf("Un tutor sin 'students' es válido: %v", err)
+	}
+
+	// Un estudiante se asigna por número de expediente o por nombre
+	t.Setenv("ROLES_FILE", write(`{"ana": {"role": "student", "student_number": "2024-017"}}`))
+	if bindings, err := loadRoleBindings(); err != nil || bindings["ana"].StudentNumber != "2024-017" {
+		t.Errorf("Estudiante por número de expediente: %v %v", bindings, err)
+	}
+
+	for _, invalid := range []string{
+		`{"x": {"role": "director"}}`,
+		`{"x": {"role": "student"}}`,
+		`no es json`,
+	} {
+		t.Setenv("ROLES_FILE", write(invalid))
+		if _, err := loadRoleBindings(); err == nil {
+			t.Errorf("%s: debería rechazarse", invalid)
+		}
+	}
+}
+
+func TestNameBinding(t *testing.T) {
+	s := &Server{roles: map[string]Role{
+		"tutor.ruiz": {Name: roleTutor, Students: []string{"Luis Gómez"}},
+		"ana":        {Name: roleStudent, Student: "Ana"},
+		"juan.perez": {Name: roleStudent, Student: "Juan Pérez", StudentNumber: "2024-001"},
+	}}
+
+	if subject, bound := s.nameBinding("luis gomez"); !bound || subject != "tutor.ruiz" {
+		t.Errorf("Luis Gómez está asignado por nombre a tutor.ruiz: %q %v", subject, bound)
+	}
+	if _, bound := s.nameBinding("Ana"); !bound {
+		t.Error("Ana está asignada por nombre")
+	}
+	if _, bound := s.nameBinding("Juan Pérez"); bound {
+		t.Error("Con número de expediente el nombre no cuenta")
+	}
+	if _, bound := (&Server{}).nameBinding("Ana"); bound {
+		t.Error("Sin ROLES_FILE no hay asignaciones")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// ToolRequest agrupa lo que una herramienta puede necesitar de la petición en
+// curso además de sus argumentos
+type ToolRequest struct {
+	Session *Session
+	// nil si el cliente no pidió notificaciones de progreso
+	Progress *ProgressReporter
+	// Rol de la identidad de la sesión; limita los datos que ve la herramienta
+	Role Role
+
+	// Cambios que hizo la herramienta, para la auditoría
+	changes []Change
+}
+
+// RecordChange anota una modificación hecha por la herramienta
+func (req *ToolRequest) RecordChange(change Change) {
+	req.changes = append(req.changes, change)
+}
+
+// InvalidParamsError indica argumentos que no cumplen el esquema; se responde
+// con -32602 en lugar de -32603. Pointer señala el argumento afectado.
+type InvalidParamsError struct {
+	Message string
+	Pointer string
+}
+
+func (e *InvalidParamsError) Error() string {
+	return e.Message
+}
+
+// registeredTool une la definición pública de una herramienta con su
+// esquema validable y su implementación
+type registeredTool struct {
+	Tool
+	schema *JSONSchema
+	call   func(ctx context.Context, req *ToolRequest, arguments map[string]interface{}) (interface{}, error)
+}
+
+// ToolRegistry guarda las herramientas en el orden en que se declaran
+type ToolRegistry struct {
+	tools  []*registeredTool
+	byName map[string]*registeredTool
+}
+
+func newToolRegistry() *ToolRegistry {
+	return &ToolRegistry{byName: map[string]*registeredTool{}}
+}
+
+// registerTool declara una herramienta una sola vez: su esquema de entrada se
+// genera a partir de Args y los argumentos se validan y decodifican en Args
+// antes de llamar a handler
+func registerTool[Args any](r *ToolRegistry, tool Tool, handler func(ctx context.Context, req *ToolRequest, args Args) (interface{}, error)) {
+	if _, exists := r.byName[tool.Name]; exists {
+		panic("herramienta registrada dos veces: " + tool.Name)
+	}
+
+	schema := schemaFor(reflect.TypeOf((*Args)(nil)).Elem())
+	tool.InputSchema = schema
+
+	registered := &registeredTool{
+		Tool:   tool,
+		schema: schema,
+		call: func(ctx context.Context, req *ToolRequest, arguments map[string]interface{}) (interface{}, error) {
+			var args Args
+			if err := decodeParams(arguments, &args); err != nil {
+				return nil, &InvalidParamsError{Message: fmt.Sprintf("argumentos inválidos para %s: %v", tool.Name, err)}
+			}
+			return handler(ctx, req, args)
+		},
+	}
+
+	r.tools = append(r.tools, registered)
+	r.byName[tool.Name] = registered
+}
+
+// Tools devuelve las definiciones públicas en orden de registro
+func (r *ToolRegistry) Tools() []Tool {
+	tools := make([]Tool, len(r.tools))
+	for i, registered := range r.tools {
+		tools[i] = registered.Tool
+	}
+	return tools
+}
+
+func (r *ToolRegistry) lookup(name string) (*registeredTool, bool) {
+	registered, ok := r.byName[name]
+	return registered, ok
+}
+
+// call valida los argumentos contra el esquema y ejecuta la herramienta
+func (r *ToolRegistry) call(ctx context.Context, req *ToolRequest, name string, arguments map[string]interface{}) (interface{}, error) {
+	registered, ok := r.lookup(name)
+	if !ok {
+		return nil, &InvalidParamsError{Message: "herramienta desconocida: " + name}
+	}
+
+	if err := registered.schema.validate(arguments, ""); err != nil {
+		invalid := &InvalidParamsError{Message: err.Error()}
+		if schemaErr, ok := err.(*SchemaError); ok {
+			invalid.Pointer = schemaErr.Pointer
+		}
+		return nil, invalid
+	}
+
+	return registered.call(ctx, req, arguments)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaForArgs(t *testing.T) {
+	schema := schemaFor(reflect.TypeOf(addStudentArgs{}))
+
+	if schema.Type != "object" {
+		t.Fatalf("Tipo incorrecto: %s", schema.Type)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"name"}) {
+		t.Errorf("Solo 'name' es obligatorio: %v", schema.Required)
+	}
+	if schema.Properties["name"].Type != "string" || schema.Properties["name"].Description == "" {
+		t.Errorf("Propiedad 'name' incorrecta: %+v", schema.Properties["name"])
+	}
+
+	subjects := schema.Properties["subjects"]
+	if subjects.Type != "object" || subjects.AdditionalProperties == nil || subjects.AdditionalProperties.Type != "number" {
+		t.Errorf("'subjects' debe ser un objeto de números: %+v", subjects)
+	}
+}
+
+func TestSchemaForEmbeddedArgs(t *testing.T) {
+	schema := schemaFor(reflect.TypeOf(setGradeArgs{}))
+	if !reflect.DeepEqual(schema.Required, []string{"grade", "subject"}) {
+		t.Errorf("name e id no son obligatorios: %v", schema.Required)
+	}
+	if schema.Properties["id"] == nil || schema.Properties["id"].Pattern == "" || schema.Properties["name"] == nil {
+		t.Errorf("Faltan las propiedades del struct incrustado: %+v", schema.Properties)
+	}
+	if _, ok := schema.Properties["studentRefArgs"]; ok {
+		t.Error("El struct incrustado no es una propiedad")
+	}
+
+	// Un campo con el mismo nombre prevalece sobre el incrustado
+	type shadowed struct {
+		studentRefArgs
+		Name string `json:"name" description:"Nombre obligatorio"`
+	}
+	schema = schemaFor(reflect.TypeOf(shadowed{}))
+	if schema.Properties["name"].Description != "Nombre obligatorio" || !reflect.DeepEqual(schema.Required, []string{"name"}) {
+		t.Errorf("Campo incrustado mal resuelto: %+v %v", schema.Properties["name"], schema.Required)
+	}
+
+	var args setGradeArgs
+	if err := json.Unmarshal([]byte(`{"id":"65f1a2b3c4d5e6f708192a3b","subject":"matematicas","grade":8}`), &args); err != nil || args.ref() != (studentRef{ID: "65f1a2b3c4d5e6f708192a3b"}) {
+		t.Errorf("Argumentos mal decodificados: %+v %v", args, err)
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	schema := schemaFor(reflect.TypeOf(addStudentArgs{}))
+
+	cases := []struct {
+		arguments string
+		expected  string
+	}{
+		{`{"name": "Ana", "subjects": {"historia": 9}}`, ""},
+		{`{"name": "Ana"}`, ""},
+		{`{}`, "argumento '/name': es obligatorio"},
+		{`{"name": 3}`, "argumento '/name': se esperaba string, se recibió integer"},
+		{`{"name": ""}`, "argumento '/name': no puede estar vacío"},
+		{`{"name": "Ana", "subjects": {"historia": "nueve"}}`, "argumento '/subjects/historia': se esperaba number, se recibió string"},
+		{`{"name": "Ana", "subjects": {"historia": "7.5"}}`, ""},
+		{`{"name": "Ana", "subjects": {"historia": "12"}}`, "argumento '/subjects/historia': debe ser menor o igual que 10"},
+		{`{"name": "Ana", "subjects": {"historia": 11}}`, "argumento '/subjects/historia': debe ser menor o igual que 10"},
+		{`{"name": "Ana", "subjects": {"a/b": -1}}`, "argumento '/subjects/a~1b': debe ser mayor o igual que 0"},
+		{`{"name": "Ana", "grupo": "2B"}`, "argumento '/grupo': propiedad no permitida"},
+	}
+
+	for _, c := range cases {
+		var arguments map[string]interface{}
+		json.Unmarshal([]byte(c.arguments), &arguments)
+
+		err := schema.validate(arguments, "")
+		switch {
+		case c.expected == "" && err != nil:
+			t.Errorf("%s: error inesperado: %v", c.arguments, err)
+		case c.expected != "" && (err == nil || err.Error() != c.expected):
+			t.Errorf("%s: esperado %q, obtenido %v", c.arguments, c.expected, err)
+		}
+	}
+}
+
+func TestRegistryDecodesTypedArguments(t *testing.T) {
+	r := newToolRegistry()
+
+	var received addStudentArgs
+	registerTool(r, Tool{Name: "echo"}, func(ctx context.Context, req *ToolRequest, args addStudentArgs) (interface{}, error) {
+		received = args
+		return "ok", nil
+	})
+
+	_, err := r.call(context.Background(), &ToolRequest{}, "echo", map[string]interface{}{
+		"name":     "Ana",
+		"subjects": map[string]interface{}{"historia": 9.5},
+	})
+	if err != nil {
+		t.Fatalf("Error inesperado: %v", err)
+	}
+	if received.Name != "Ana" || received.Subjects["historia"] != 9.5 {
+		t.Errorf("Argumentos mal decodificados: %+v", received)
+	}
+
+	// Como antes del registro, add_student acepta notas escritas como texto
+	_, err = r.call(context.Background(), &ToolRequest{}, "echo", map[string]interface{}{
+		"name":     "Ana",
+		"subjects": map[string]interface{}{"historia": "7.5"},
+	})
+	if err != nil || received.Subjects["historia"] != 7.5 {
+		t.Errorf("Nota como texto mal decodificada: %+v %v", received, err)
+	}
+
+	_, err = r.call(context.Background(), &ToolRequest{}, "echo", map[string]interface{}{})
+	var invalid *InvalidParamsError
+	if !errors.As(err, &invalid) {
+		t.Errorf("Se esperaba InvalidParamsError: %v", err)
+	}
+
+	if _, err := r.call(context.Background(), &ToolRequest{}, "otra", nil); !errors.As(err, &invalid) {
+		t.Errorf("Una herramienta desconocida es un error de parámetros: %v", err)
+	}
+}
+
+func TestToolCallValidationReturnsInvalidParams(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportStdio, "", io.Discard)
+	processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+
+	// La validación falla antes de llegar a MongoDB
+	response := processTestMessage(t, s, sess, MCPMessage{
+		JsonRPC: "2.0",
+		ID:      2,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "get_student_by_name", "arguments": map[string]interface{}{}},
+	})
+	if response.Error == nil || response.Error.Code != -32602 {
+		t.Fatalf("Se esperaba error -32602: %+v", response.Error)
+	}
+	if !strings.Contains(response.Error.Message, "'/name'") {
+		t.Errorf("El mensaje debería nombrar el argumento: %s", response.Error.Message)
+	}
+	if data, _ := response.Error.Data.(map[string]interface{}); data["pointer"] != "/name" {
+		t.Errorf("El error debería incluir el pointer: %v", response.Error.Data)
+	}
+}
+
+func TestSchemaKeywords(t *testing.T) {
+	schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+	for name, tag := range map[string]string{
+		"code":  "pattern=^[A-Z]{3}-\\d{2,3}$",
+		"term":  "enum=1|2|3|final",
+		"email": "format=email",
+	} {
+		property := &JSONSchema{Type: "string"}
+		if err := property.applyTag(tag); err != nil {
+			t.Fatalf("%s: %v", tag, err)
+		}
+		schema.Properties[name] = property
+	}
+
+	cases := map[string]string{
+		`{"code": "MAT-101"}`:     "",
+		`{"code": "mat-1"}`:       "argumento '/code': no cumple el patrón ^[A-Z]{3}-\\d{2,3}$",
+		`{"term": "final"}`:       "",
+		`{"term": "4"}`:           `argumento '/term': debe ser uno de "1", "2", "3", "final"`,
+		`{"email": "ana@ies.es"}`: "",
+		`{"email": "ana"}`:        "argumento '/email': no tiene formato email",
+		`{"otra": "se acepta"}`:   "",
+	}
+	for arguments, expected := range cases {
+		var value map[string]interface{}
+		json.Unmarshal([]byte(arguments), &value)
+
+		err := schema.validate(value, "")
+		switch {
+		case expected == "" && err != nil:
+			t.Errorf("%s: error inesperado: %v", arguments, err)
+		case expected != "" && (err == nil || err.Error() != expected):
+			t.Errorf("%s: esperado %q, obtenido %v", arguments, expected, err)
+		}
+	}
+
+	if err := (&JSONSchema{}).applyTag("minimo=3"); err == nil {
+		t.Error("Una restricción desconocida debería dar error")
+	}
+}
+
+func TestSchemaMarshalsAdditionalProperties(t *testing.T) {
+	data, _ := json.Marshal(schemaFor(reflect.TypeOf(addStudentArgs{})))
+
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	if decoded["additionalProperties"] != false {
+		t.Errorf("Los argumentos no admiten propiedades extra: %s", data)
+	}
+
+	subjects := decoded["properties"].(map[string]interface{})["subjects"].(map[string]interface{})
+	values := subjects["additionalProperties"].(map[string]interface{})
+	if values["type"] != "number" || values["minimum"] != 0.0 || values["maximum"] != 10.0 {
+		t.Errorf("Las notas deben estar entre 0 y 10: %v", values)
+	}
+}
+
+func TestEveryToolHasObjectSchema(t *testing.T) {
+	s := &Server{}
+	for _, tool := range s.getTools() {
+		schema, ok := tool.InputSchema.(*JSONSchema)
+		if !ok || schema.Type != "object" {
+			t.Errorf("%s: inputSchema debe ser un objeto: %#v", tool.Name, tool.InputSchema)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Origen del texto del boletín
+const (
+	reportSourceSampling = "sampling"
+	reportSourceTemplate = "template"
+)
+
+// Límite de tokens que pedimos al modelo del cliente para el comentario
+const reportMaxTokens = 400
+
+// ReportCard es el resultado de generate_report_card: los datos del estudiante,
+// el contexto de la clase y el comentario narrativo
+type ReportCard struct {
+	Student       string             `json:"student"`
+	Grades        map[string]float64 `json:"grades"`
+	Average       float64            `json:"average"`
+	ClassAverages map[string]float64 `json:"class_averages"`
+	Report        string             `json:"report"`
+	ReportSource  string             `json:"report_source"`
+	Model         string             `json:"model,omitempty"`
+	Note          string             `json:"note,omitempty"`
+}
+
+// subjectAverages calcula la media de la clase en cada asignatura, con los
+// estudiantes y las asignaturas que el rol puede ver
+func (s *Server) subjectAverages(ctx context.Context, role Role) (map[string]float64, error) {
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: role.studentFilter()}},
+		{{Key: "$project", Value: bson.M{"subject": bson.M{"$objectToArray": "$subjects"}}}},
+		{{Key: "$unwind", Value: "$subject"}},
+		{{Key: "$group", Value: bson.M{"_id": "$subject.k", "average": bson.M{"$avg": subjectGradeExpr("$subject.v")}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Subject string  `bson:"_id"`
+		Average float64 `bson:"average"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	averages := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		if role.canSeeSubject(row.Subject) {
+			averages[row.Subject] = round2(row.Average)
+		}
+	}
+	return averages, nil
+}
+
+func (s *Server) generateReportCard(ctx context.Context, sess *Session, role Role, ref studentRef) (interface{}, error) {
+	student, err := s.findStudent(ctx, sess, role, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	classAverages, err := s.subjectAverages(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+
+	card := ReportCard{
+		Student:       student.Name,
+		Grades:        student.Subjects,
+		Average:       round2(average(student.Subjects)),
+		ClassAverages: classAverages,
+	}
+
+	result, err := sess.createMessage(ctx,
+		"Eres un tutor escolar que redacta comentarios de boletín para las familias. Escribe en español, con tono constructivo y sin inventar datos.",
+		reportPrompt(card),
+		reportMaxTokens,
+	)
+	switch {
+	case err == nil:
+		card.Report = strings.TrimSpace(result.Content.Text)
+		card.ReportSource = reportSourceSampling
+		card.Model = result.Model
+	case err == errSamplingUnsupported:
+		card.Report = templateReport(card)
+		card.ReportSource = reportSourceTemplate
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	default:
+		// El usuario puede rechazar el sampling; el boletín sigue siendo útil
+		sess.logger.Warn("Sampling fallido, se usa la plantilla", "estudiante", student.Name, "error", err)
+		card.Report = templateReport(card)
+		card.ReportSource = reportSourceTemplate
+		card.Note = "No se pudo generar el comentario con el modelo del cliente: " + err.Error()
+	}
+
+	return card, nil
+}
+
+// reportPrompt describe las notas y el contexto de la clase para el modelo
+func reportPrompt(card ReportCard) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Redacta un comentario de boletín de 3 a 5 frases para %s.\n\n", card.Student)
+	fmt.Fprintf(&b, "Nota media: %.2f\n", card.Average)
+	b.WriteString("Notas por asignatura (nota del estudiante / media de la clase):\n")
+	for _, subject := range sortedSubjects(card.Grades) {
+		fmt.Fprintf(&b, "- %s: %.2f / %.2f\n", subject, card.Grades[subject], card.ClassAverages[subject])
+	}
+	b.WriteString("\nMenciona sus puntos fuertes, lo que debe reforzar y una recomendación concreta.")
+	return b.String()
+}
+
+// templateReport genera el comentario sin modelo, a partir de las notas
+func templateReport(card ReportCard) string {
+	if len(card.Grades) == 0 {
+		return fmt.Sprintf("%s no tiene notas registradas todavía.", card.Student)
+	}
+
+	var strengths, weaknesses []string
+	for _, subject := range sortedSubjects(card.Grades) {
+		diff := card.Grades[subject] - card.ClassAverages[subject]
+		switch {
+		case diff >= 0.5:
+			strengths = append(strengths, fmt.Sprintf("%s (%.1f)", subject, card.Grades[subject]))
+		case diff <= -0.5 || card.Grades[subject] < 5:
+			weaknesses = append(weaknesses, fmt.Sprintf("%s (%.1f)", subject, card.Grades[subject]))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s obtiene una nota media de %.2f (%s).", card.Student, card.Average, qualification(card.Average))
+	if len(strengths) > 0 {
+		fmt.Fprintf(&b, " Destaca por encima de la media de la clase en %s.", strings.Join(strengths, ", "))
+	}
+	if len(weaknesses) > 0 {
+		fmt.Fprintf(&b, " Debe reforzar %s.", strings.Join(weaknesses, ", "))
+	} else {
+		b.WriteString(" Mantiene un rendimiento equilibrado en todas las asignaturas.")
+	}
+	return b.String()
+}
+
+// qualification traduce una nota a la calificación habitual en España
+func qualification(grade float64) string {
+	switch {
+	case grade >= 9:
+		return "Sobresaliente"
+	case grade >= 7:
+		return "Notable"
+	case grade >= 6:
+		return "Bien"
+	case grade >= 5:
+		return "Suficiente"
+	default:
+		return "Insuficiente"
+	}
+}
+
+func average(grades map[string]float64) float64 {
+	if len(grades) == 0 {
+		return 0
+	}
+	var total float64
+	for _, grade := range grades {
+		total += grade
+	}
+	return total / float64(len(grades))
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func sortedSubjects(grades map[string]float64) []string {
+	subjects := make([]string, 0, len(grades))
+	for subject := range grades {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
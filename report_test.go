@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTemplateReport(t *testing.T) {
+	card := ReportCard{
+		Student:       "María García",
+		Grades:        map[string]float64{"matematicas": 9.2, "historia": 7.0, "ingles": 8.0},
+		Average:       8.07,
+		ClassAverages: map[string]float64{"matematicas": 8.1, "historia": 8.3, "ingles": 8.0},
+	}
+
+	report := templateReport(card)
+	for _, expected := range []string{"8.07", "Notable", "matematicas (9.2)", "Debe reforzar historia (7.0)"} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("El informe debería contener %q: %s", expected, report)
+		}
+	}
+	if strings.Contains(report, "ingles") {
+		t.Errorf("Una nota en la media no es ni fortaleza ni debilidad: %s", report)
+	}
+}
+
+func TestQualification(t *testing.T) {
+	cases := map[float64]string{9.5: "Sobresaliente", 7: "Notable", 6.2: "Bien", 5: "Suficiente", 4.9: "Insuficiente"}
+	for grade, expected := range cases {
+		if got := qualification(grade); got != expected {
+			t.Errorf("Calificación de %.1f: esperado %s, obtenido %s", grade, expected, got)
+		}
+	}
+}
+
+func TestCreateMessage(t *testing.T) {
+	var prompt string
+	sess := newClientStub(t, map[string]interface{}{"sampling": map[string]interface{}{}}, func(msg MCPMessage) interface{} {
+		params := msg.Params.(map[string]interface{})
+		messages := params["messages"].([]interface{})
+		prompt = messages[0].(map[string]interface{})["content"].(map[string]interface{})["text"].(string)
+		return map[string]interface{}{
+			"role":    "assistant",
+			"model":   "modelo-de-prueba",
+			"content": map[string]interface{}{"type": "text", "text": "Buen trimestre."},
+		}
+	})
+
+	card := ReportCard{Student: "Ana Martínez", Grades: map[string]float64{"historia": 9}, ClassAverages: map[string]float64{"historia": 8}}
+	result, err := sess.createMessage(context.Background(), "sistema", reportPrompt(card), reportMaxTokens)
+	if err != nil {
+		t.Fatalf("Error en sampling: %v", err)
+	}
+	if result.Model != "modelo-de-prueba" || result.Content.Text != "Buen trimestre." {
+		t.Errorf("Resultado incorrecto: %+v", result)
+	}
+	if !strings.Contains(prompt, "historia: 9.00 / 8.00") {
+		t.Errorf("El prompt debería incluir la nota y la media de la clase: %s", prompt)
+	}
+}
+
+func TestCreateMessageRequiresCapability(t *testing.T) {
+	sess := newClientStub(t, map[string]interface{}{}, func(MCPMessage) interface{} {
+		t.Error("No debería enviarse sampling/createMessage")
+		return nil
+	})
+
+	if _, err := sess.createMessage(context.Background(), "", "", 10); err != errSamplingUnsupported {
+		t.Errorf("Se esperaba errSamplingUnsupported: %v", err)
+	}
+}
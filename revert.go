@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	changeInsert = "insert"
+	changeUpdate = "update"
+	changeDelete = "delete"
+
+	defaultRecentChanges = 20
+)
+
+// kind indica si la entrada fue una inserción, una modificación o un borrado
+func (entry AuditEntry) kind() string {
+	switch {
+	case entry.Before == nil && entry.After != nil:
+		return changeInsert
+	case entry.Before != nil && entry.After == nil:
+		return changeDelete
+	case entry.Before != nil:
+		return changeUpdate
+	}
+	return ""
+}
+
+// gradeChange es la diferencia de una nota en un cambio; Before o After son
+// nil si la nota no existía
+type gradeChange struct {
+	Subject string   `json:"subject"`
+	Before  *float64 `json:"before,omitempty"`
+	After   *float64 `json:"after,omitempty"`
+}
+
+// changeSummary resume una entrada de auditoría para elegir qué deshacer
+type changeSummary struct {
+	ID          string        `json:"change_id"`
+	Time        string        `json:"time"`
+	Tool        string        `json:"tool"`
+	Caller      string        `json:"caller"`
+	Reason      string        `json:"reason,omitempty"`
+	Kind        string        `json:"kind"`
+	StudentID   string        `json:"student_id"`
+	StudentName string        `json:"student_name"`
+	Grades      []gradeChange `json:"grades"`
+}
+
+// summarizeChange resume la entrada con las notas que el rol puede ver
+func summarizeChange(role Role, entry AuditEntry) changeSummary {
+	summary := changeSummary{
+		ID:          entry.ID.Hex(),
+		Time:        entry.Time.UTC().Format(time.RFC3339),
+		Tool:        entry.Tool,
+		Caller:      entry.Caller,
+		Reason:      entry.Reason,
+		Kind:        entry.kind(),
+		StudentID:   entry.StudentID.Hex(),
+		StudentName: entry.StudentName,
+		Grades:      []gradeChange{},
+	}
+	visible := role.redactEntry(entry)
+	for _, event := range gradeEvents(Change{Before: visible.Before, After: visible.After}) {
+		summary.Grades = append(summary.Grades, gradeChange{Subject: event.Subject, Before: event.Previous, After: event.Grade})
+	}
+	return summary
+}
+
+type recentChangesArgs struct {
+	Student string `json:"student,omitempty" description:"Nombre o id del estudiante"`
+	Limit   int    `json:"limit,omitempty" description:"Número máximo de cambios (por defecto 20)" jsonschema:"minimum=1,maximum=200"`
+}
+
+type revertChangeArgs struct {
+	ChangeID string `json:"change_id" description:"Id del cambio, tal como lo devuelve list_recent_changes" jsonschema:"pattern=^[0-9a-f]{24}$"`
+	Reason   string `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+// listRecentChanges devuelve los últimos cambios aplicados, los más recientes
+// primero. Como en la auditoría, salvo los administradores cada identidad
+// solo ve los suyos.
+func (s *Server) listRecentChanges(ctx context.Context, sess *Session, role Role, student string, limit int) (interface{}, error) {
+	if s.audit == nil {
+		return nil, fmt.Errorf("la auditoría no está activada: no hay cambios registrados")
+	}
+
+	q := AuditQuery{Student: student, ChangesOnly: true, Limit: limit}
+	if q.Limit == 0 {
+		q.Limit = defaultRecentChanges
+	}
+	if role.Name != roleAdmin {
+		identity, _ := sess.Identity()
+		q.Caller = identity.Subject
+	}
+
+	entries, err := s.audit.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]changeSummary, len(entries))
+	for i, entry := range entries {
+		changes[i] = summarizeChange(role, entry)
+	}
+	return map[string]interface{}{
+		"changes": changes,
+		"count":   len(changes),
+	}, nil
+}
+
+// revertChange deshace un cambio devolviendo al estudiante al estado anterior.
+// Se niega si hubo cambios posteriores sobre el mismo estudiante, o si el
+// documento ya no coincide con el que dejó el cambio: en ambos casos
+// deshacerlo borraría trabajo posterior.
+func (s *Server) revertChange(ctx context.Context, req *ToolRequest, changeID string) (interface{}, error) {
+	if s.audit == nil {
+		return nil, fmt.Errorf("la auditoría no está activada: no hay cambios que deshacer")
+	}
+
+	// El esquema ya garantiza que es un id válido
+	id, _ := primitive.ObjectIDFromHex(changeID)
+	entries, err := s.audit.Query(ctx, AuditQuery{ID: id})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 || entries[0].Error != "" || (entries[0].StudentID.IsZero() && entries[0].Collection == "") {
+		return nil, fmt.Errorf("cambio '%s' no encontrado", changeID)
+	}
+	entry := entries[0]
+	if entry.Collection != "" {
+		return nil, fmt.Errorf("'%s' es un cambio en %s (%s): revert_change solo deshace cambios de estudiantes; corrígelo con la misma herramienta", changeID, entry.Collection, entry.Tool)
+	}
+
+	if req.Role.Name != roleAdmin {
+		identity, _ := req.Session.Identity()
+		if entry.Caller != identity.Subject {
+			return nil, fmt.Errorf("%w: solo puedes deshacer tus propios cambios", errForbidden)
+		}
+	}
+	touched := map[string]float64{}
+	for _, event := range gradeEvents(Change{Before: entry.Before, After: entry.After}) {
+		touched[event.Subject] = 0
+	}
+	if err := req.Role.checkGradesWrite(touched); err != nil {
+		return nil, err
+	}
+
+	later, err := s.audit.Query(ctx, AuditQuery{Student: entry.StudentID.Hex(), Since: entry.Time, ChangesOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []string
+	for _, other := range later {
+		if other.ID != entry.ID {
+			conflicts = append(conflicts, other.ID.Hex())
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("no se puede deshacer '%s': %s tiene cambios posteriores (%s); deshazlos primero", changeID, entry.StudentName, strings.Join(conflicts, ", "))
+	}
+
+	var current *Student
+	var found Student
+	err = s.collection.FindOne(ctx, bson.M{"_id": entry.StudentID}).Decode(&found)
+	switch {
+	case err == nil:
+		current = &found
+	case err != mongo.ErrNoDocuments:
+		return nil, err
+	}
+	if !sameStudent(current, entry.After) {
+		return nil, fmt.Errorf("no se puede deshacer '%s': %s ha cambiado desde entonces", changeID, entry.StudentName)
+	}
+
+	if err := s.restoreStudent(ctx, entry); err != nil {
+		return nil, err
+	}
+	req.RecordChange(Change{StudentID: entry.StudentID, StudentName: entry.StudentName, Before: current, After: entry.Before})
+
+	return map[string]interface{}{
+		"message":   "Cambio deshecho",
+		"change_id": changeID,
+		"kind":      entry.kind(),
+		"student":   entry.StudentName,
+		"restored":  req.Role.redactChanged(entry.Before),
+	}, nil
+}
+
+// restoreStudent aplica la operación inversa del cambio. El filtro exige el
+// estado que dejó el cambio, para no pisar una escritura concurrente.
+func (s *Server) restoreStudent(ctx context.Context, entry AuditEntry) error {
+	conflict := fmt.Errorf("%s ha cambiado mientras se deshacía el cambio; vuelve a intentarlo", entry.StudentName)
+
+	switch entry.kind() {
+	case changeInsert:
+		result, err := s.collection.DeleteOne(ctx, studentStateFilter(entry.After))
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return conflict
+		}
+	case changeUpdate:
+		// El perfil restaurado es un cambio más: su versión sigue subiendo
+		restored := entry.Before.clone()
+		if version := entry.After.Profile.version(); version > 0 {
+			if restored.Profile == nil {
+				restored.Profile = &Profile{}
+			}
+			restored.Profile.Version = version + 1
+		}
+		result, err := s.collection.ReplaceOne(ctx, studentStateFilter(entry.After), restored)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return conflict
+		}
+	case changeDelete:
+		if _, err := s.collection.InsertOne(ctx, entry.Before); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return conflict
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// studentStateFilter selecciona el estudiante solo si conserva ese nombre,
+// esas notas, esas evaluaciones y esa versión del perfil
+func studentStateFilter(student *Student) bson.M {
+	filter := bson.M{"_id": student.ID, "name": student.Name, "profile.version": bson.M{"$exists": false}}
+	if version := student.Profile.version(); version > 0 {
+		filter["profile.version"] = version
+	}
+	for subject, grade := range student.Subjects {
+		if assessments := student.Assessments[subject]; len(assessments) > 0 {
+			filter["subjects."+subject+".assessments"] = assessments
+		} else {
+			filter["subjects."+subject] = grade
+		}
+	}
+	return filter
+}
+
+// sameStudent compara dos estados de un estudiante; nil es que no existe
+func sameStudent(a, b *Student) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Name != b.Name || len(a.Subjects) != len(b.Subjects) {
+		return false
+	}
+	for subject, grade := range a.Subjects {
+		if other, ok := b.Subjects[subject]; !ok || other != grade {
+			return false
+		}
+	}
+	return sameAssessments(a.Assessments, b.Assessments) && sameTermGrades(a.TermGrades, b.TermGrades) && sameProfile(a.Profile, b.Profile)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSummarizeChange(t *testing.T) {
+	before := &Student{Name: "Ana", Subjects: map[string]float64{"matematicas": 6}}
+	after := &Student{Name: "Ana", Subjects: map[string]float64{"matematicas": 8, "historia": 7}}
+
+	summary := summarizeChange(adminRole, AuditEntry{ID: primitive.NewObjectID(), Tool: "set_grade", Before: before, After: after})
+	if summary.Kind != changeUpdate || len(summary.Grades) != 2 {
+		t.Fatalf("Resumen incorrecto: %+v", summary)
+	}
+	if historia := summary.Grades[0]; historia.Subject != "historia" || historia.Before != nil || *historia.After != 7 {
+		t.Errorf("Nota nueva mal resumida: %+v", historia)
+	}
+
+	if kind := (AuditEntry{After: after}).kind(); kind != changeInsert {
+		t.Errorf("Se esperaba una inserción: %q", kind)
+	}
+	if kind := (AuditEntry{Before: before}).kind(); kind != changeDelete {
+		t.Errorf("Se esperaba un borrado: %q", kind)
+	}
+}
+
+func TestListRecentChangesSkipsFailures(t *testing.T) {
+	audit := newTestAuditLog(t)
+	s := &Server{audit: audit}
+	ctx := context.Background()
+	ana := &Student{ID: primitive.NewObjectID(), Name: "Ana", Subjects: map[string]float64{"matematicas": 8}}
+
+	audit.Record(ctx, AuditEntry{ID: primitive.NewObjectID(), Time: time.Now(), Caller: "garcia", StudentID: ana.ID, StudentName: "Ana", After: ana})
+	audit.Record(ctx, AuditEntry{ID: primitive.NewObjectID(), Time: time.Now(), Caller: "garcia", Error: "sin conexión"})
+	audit.Record(ctx, AuditEntry{ID: primitive.NewObjectID(), Time: time.Now(), Caller: "ruiz", StudentID: ana.ID, StudentName: "Ana", Before: ana})
+
+	teacher := Role{Name: roleTeacher, Subjects: []string{"matematicas"}}
+	result, err := s.listRecentChanges(ctx, sessionFor("garcia", authMTLS), teacher, "", 0)
+	if err != nil || result.(map[string]interface{})["count"] != 1 {
+		t.Errorf("Solo los cambios aplicados del propio profesor: %v %v", result, err)
+	}
+	if result, _ := s.listRecentChanges(ctx, sessionFor("garcia", authMTLS), adminRole, "Ana", 0); result.(map[string]interface{})["count"] != 2 {
+		t.Errorf("El administrador ve todos los cambios: %v", result)
+	}
+}
+
+func TestRevertChangeRefusals(t *testing.T) {
+	audit := newTestAuditLog(t)
+	s := &Server{audit: audit}
+	ctx := context.Background()
+	base := time.Now().UTC()
+
+	ana := &Student{ID: primitive.NewObjectID(), Name: "Ana", Subjects: map[string]float64{"matematicas": 6}}
+	corrected := &Student{ID: ana.ID, Name: "Ana", Subjects: map[string]float64{"matematicas": 8}}
+	inserted := AuditEntry{ID: primitive.NewObjectID(), Time: base, Caller: "garcia", StudentID: ana.ID, StudentName: "Ana", After: ana}
+	updated := AuditEntry{ID: primitive.NewObjectID(), Time: base.Add(time.Minute), Caller: "garcia", StudentID: ana.ID, StudentName: "Ana", Before: ana, After: corrected}
+	// La asistencia no cuenta como cambio posterior del estudiante
+	attendance := AuditEntry{ID: primitive.NewObjectID(), Time: base.Add(2 * time.Minute), Caller: "garcia", StudentID: ana.ID, StudentName: "Ana",
+		Collection: "attendance", RecordAfter: AttendanceRecord{StudentID: ana.ID, Date: "2025-03-03", Subject: "matematicas", Status: "absent"}}
+	audit.Record(ctx, inserted)
+	audit.Record(ctx, updated)
+	audit.Record(ctx, attendance)
+
+	revert := func(subject string, role Role, id primitive.ObjectID) error {
+		_, err := s.revertChange(ctx, &ToolRequest{Session: sessionFor(subject, authMTLS), Role: role}, id.Hex())
+		return err
+	}
+
+	if err := revert("garcia", adminRole, primitive.NewObjectID()); err == nil || !strings.Contains(err.Error(), "no encontrado") {
+		t.Errorf("Se esperaba cambio no encontrado: %v", err)
+	}
+	if err := revert("garcia", adminRole, inserted.ID); err == nil || !strings.Contains(err.Error(), updated.ID.Hex()) {
+		t.Errorf("Un cambio posterior impide deshacer: %v", err)
+	}
+	if err := revert("garcia", adminRole, attendance.ID); err == nil || !strings.Contains(err.Error(), "solo deshace cambios de estudiantes") {
+		t.Errorf("revert_change no deshace la asistencia: %v", err)
+	}
+	if err := revert("ruiz", Role{Name: roleTeacher, Subjects: []string{"matematicas"}}, updated.ID); !errors.Is(err, errForbidden) {
+		t.Errorf("Solo se deshacen los cambios propios: %v", err)
+	}
+	if err := revert("garcia", Role{Name: roleTeacher, Subjects: []string{"historia"}}, updated.ID); !errors.Is(err, errForbidden) {
+		t.Errorf("El profesor no deshace notas de otras asignaturas: %v", err)
+	}
+}
+
+func TestSameStudent(t *testing.T) {
+	ana := &Student{Name: "Ana", Subjects: map[string]float64{"matematicas": 6}}
+	if !sameStudent(ana, &Student{Name: "Ana", Subjects: map[string]float64{"matematicas": 6}}) || !sameStudent(nil, nil) {
+		t.Error("Estados iguales")
+	}
+	if sameStudent(ana, nil) || sameStudent(ana, &Student{Name: "Ana", Subjects: map[string]float64{"matematicas": 7}}) {
+		t.Error("Estados distintos")
+	}
+}
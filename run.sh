@@ -128,4 +128,4 @@ else
 fi
 
 # Ejecutar el servidor
-exec "$GO_PATH" run main.go
\ No newline at end of file
+exec "$GO_PATH" run .
\ No newline at end of file
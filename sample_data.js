@@ -8,7 +8,14 @@ const sampleStudents = [
       "ciencias": 7.5,
       "literatura": 8.8,
       "ingles": 8.2
-    }
+    },
+    "profile": {
+      "birth_date": "2010-05-04",
+      "student_number": "2024-001",
+      "email": "juan.perez@example.com",
+      "guardians": [{ "name": "Rosa Pérez", "relationship": "madre", "phone": "600 000 000" }]
+    },
+    "schema_version": 2
   },
   {
     "name": "María García",
@@ -52,6 +59,15 @@ const sampleStudents = [
   }
 ];
 
+// Catálogo de asignaturas: el código es la clave de las notas
+const sampleSubjects = [
+  { "_id": "matematicas", "name": "Matemáticas", "department": "Ciencias", "credits": 4, "aliases": ["mates"] },
+  { "_id": "historia", "name": "Historia", "department": "Humanidades", "credits": 3 },
+  { "_id": "ciencias", "name": "Ciencias Naturales", "department": "Ciencias", "credits": 3, "aliases": ["naturales"] },
+  { "_id": "literatura", "name": "Lengua y Literatura", "department": "Humanidades", "credits": 4, "aliases": ["lengua"] },
+  { "_id": "ingles", "name": "Inglés", "department": "Idiomas", "credits": 3, "aliases": ["english"] }
+];
+
 // Función para insertar estudiantes
 function insertSampleStudents() {
   const result = db.students.insertMany(sampleStudents);
@@ -72,9 +88,13 @@ if (typeof module === 'undefined') {
   
   // Insertar datos
   insertSampleStudents();
+
+  db.subjects.drop();
+  db.subjects.insertMany(sampleSubjects);
+  print(`✅ Insertadas ${sampleSubjects.length} asignaturas en el catálogo`);
 }
 
 // Para exportar en Node.js/Docker si es necesario
 if (typeof module !== 'undefined') {
-  module.exports = { sampleStudents, insertSampleStudents };
+  module.exports = { sampleStudents, sampleSubjects, insertSampleStudents };
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var errSamplingUnsupported = errors.New("el cliente no soporta sampling")
+
+// SamplingResult es la parte que nos interesa de la respuesta a sampling/createMessage
+type SamplingResult struct {
+	Model   string `json:"model"`
+	Content struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+func (sess *Session) supportsSampling() bool {
+	return sess.hasClientCapability("sampling")
+}
+
+// createMessage pide al modelo del cliente que responda a prompt. El cliente
+// puede pedir confirmación al usuario o rechazar la petición.
+func (sess *Session) createMessage(ctx context.Context, systemPrompt, prompt string, maxTokens int) (SamplingResult, error) {
+	if !sess.supportsSampling() {
+		return SamplingResult{}, errSamplingUnsupported
+	}
+
+	response, err := sess.request(ctx, "sampling/createMessage", map[string]interface{}{
+		"messages": []map[string]interface{}{
+			{
+				"role": "user",
+				"content": map[string]interface{}{
+					"type": "text",
+					"text": prompt,
+				},
+			},
+		},
+		"systemPrompt": systemPrompt,
+		"maxTokens":    maxTokens,
+	})
+	if err != nil {
+		return SamplingResult{}, err
+	}
+
+	var result SamplingResult
+	if err := decodeParams(response.Result, &result); err != nil {
+		return SamplingResult{}, fmt.Errorf("respuesta de sampling inválida: %v", err)
+	}
+	if result.Content.Type != "text" || result.Content.Text == "" {
+		return SamplingResult{}, fmt.Errorf("el cliente no devolvió texto (tipo %q)", result.Content.Type)
+	}
+	return result, nil
+}
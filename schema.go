@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema es el subconjunto de JSON Schema que usamos para describir y
+// validar los argumentos de las herramientas
+type JSONSchema struct {
+	Type        string                 `json:"type,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+	Items       *JSONSchema            `json:"items,omitempty"`
+	Enum        []interface{}          `json:"enum,omitempty"`
+
+	Minimum          *float64 `json:"minimum,omitempty"`
+	Maximum          *float64 `json:"maximum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum *float64 `json:"exclusiveMaximum,omitempty"`
+
+	MinLength *int   `json:"minLength,omitempty"`
+	MaxLength *int   `json:"maxLength,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	Format    string `json:"format,omitempty"`
+
+	MinItems      *int `json:"minItems,omitempty"`
+	MaxItems      *int `json:"maxItems,omitempty"`
+	MinProperties *int `json:"minProperties,omitempty"`
+	MaxProperties *int `json:"maxProperties,omitempty"`
+
+	// Esquema de las propiedades no declaradas en Properties
+	AdditionalProperties *JSONSchema `json:"-"`
+	// Rechaza las propiedades no declaradas (additionalProperties: false)
+	DisallowAdditional bool `json:"-"`
+
+	pattern *regexp.Regexp
+	// Acepta también un número escrito como texto ("7.5"), que se convierte
+	// antes de validarlo y decodificarlo
+	numericString bool
+}
+
+// MarshalJSON emite additionalProperties como esquema o como false
+func (schema *JSONSchema) MarshalJSON() ([]byte, error) {
+	type plain JSONSchema
+	out := struct {
+		*plain
+		AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	}{plain: (*plain)(schema)}
+
+	if schema.DisallowAdditional {
+		out.AdditionalProperties = false
+	} else if schema.AdditionalProperties != nil {
+		out.AdditionalProperties = schema.AdditionalProperties
+	}
+	return json.Marshal(out)
+}
+
+// schemaFor genera el esquema de un tipo Go. Los campos de un struct toman el
+// nombre de su etiqueta json, la descripción de la etiqueta description y son
+// obligatorios salvo que lleven omitempty. La etiqueta jsonschema añade
+// restricciones (por ejemplo "minLength=1" o "minimum=0,maximum=10") y la
+// etiqueta values hace lo mismo con los valores de un mapa o los elementos de
+// una lista. Los structs no admiten propiedades no declaradas. Como en
+// encoding/json, los campos de un struct incrustado sin etiqueta json son
+// propiedades del que lo incrusta, que prevalecen si se llaman igual.
+func schemaFor(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: schemaFor(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: schemaFor(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		// interface{} y similares: cualquier valor
+		return &JSONSchema{}
+	}
+}
+
+func structSchema(t reflect.Type) *JSONSchema {
+	schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}, DisallowAdditional: true}
+
+	var embedded []*JSONSchema
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			embedded = append(embedded, structSchema(field.Type))
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schemaFor(field.Type)
+		property.Description = field.Tag.Get("description")
+		if err := property.applyTag(field.Tag.Get("jsonschema")); err != nil {
+			panic(fmt.Sprintf("%s.%s: %v", t.Name(), field.Name, err))
+		}
+		if values := field.Tag.Get("values"); values != "" {
+			inner := property.AdditionalProperties
+			if property.Type == "array" {
+				inner = property.Items
+			}
+			if inner == nil {
+				panic(fmt.Sprintf("%s.%s: la etiqueta values solo vale para mapas y listas", t.Name(), field.Name))
+			}
+			if err := inner.applyTag(values); err != nil {
+				panic(fmt.Sprintf("%s.%s: %v", t.Name(), field.Name, err))
+			}
+		}
+		schema.Properties[name] = property
+
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	for _, inner := range embedded {
+		for name, property := range inner.Properties {
+			if _, ok := schema.Properties[name]; ok {
+				continue
+			}
+			schema.Properties[name] = property
+			for _, required := range inner.Required {
+				if required == name {
+					schema.Required = append(schema.Required, name)
+				}
+			}
+		}
+	}
+
+	sort.Strings(schema.Required)
+	return schema
+}
+
+// applyTag interpreta una etiqueta jsonschema. pattern debe ir al final porque
+// la expresión regular puede contener comas. numericString no es de JSON
+// Schema: admite números escritos como texto.
+func (schema *JSONSchema) applyTag(tag string) error {
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, "pattern=") {
+			item, tag = tag, ""
+		} else {
+			item, tag, _ = strings.Cut(tag, ",")
+		}
+
+		key, value, _ := strings.Cut(item, "=")
+		var err error
+		switch key {
+		case "minimum":
+			schema.Minimum, err = parseFloatPtr(value)
+		case "maximum":
+			schema.Maximum, err = parseFloatPtr(value)
+		case "exclusiveMinimum":
+			schema.ExclusiveMinimum, err = parseFloatPtr(value)
+		case "exclusiveMaximum":
+			schema.ExclusiveMaximum, err = parseFloatPtr(value)
+		case "minLength":
+			schema.MinLength, err = parseIntPtr(value)
+		case "maxLength":
+			schema.MaxLength, err = parseIntPtr(value)
+		case "minItems":
+			schema.MinItems, err = parseIntPtr(value)
+		case "maxItems":
+			schema.MaxItems, err = parseIntPtr(value)
+		case "minProperties":
+			schema.MinProperties, err = parseIntPtr(value)
+		case "maxProperties":
+			schema.MaxProperties, err = parseIntPtr(value)
+		case "format":
+			schema.Format = value
+		case "enum":
+			for _, option := range strings.Split(value, "|") {
+				schema.Enum = append(schema.Enum, option)
+			}
+		case "pattern":
+			schema.Pattern = value
+			schema.pattern, err = regexp.Compile(value)
+		case "numericString":
+			schema.numericString = true
+		default:
+			err = fmt.Errorf("restricción desconocida %q", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseFloatPtr(value string) (*float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	return &f, err
+}
+
+func parseIntPtr(value string) (*int, error) {
+	n, err := strconv.Atoi(value)
+	return &n, err
+}
+
+// SchemaError indica qué valor no cumple el esquema mediante un JSON Pointer
+// (RFC 6901), por ejemplo "/subjects/historia"
+type SchemaError struct {
+	Pointer string
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	if e.Pointer == "" {
+		return "argumentos: " + e.Message
+	}
+	return fmt.Sprintf("argumento '%s': %s", e.Pointer, e.Message)
+}
+
+// validate comprueba value (decodificado de JSON) contra el esquema. pointer
+// es la ruta del valor dentro de los argumentos.
+func (schema *JSONSchema) validate(value interface{}, pointer string) error {
+	if schema == nil {
+		return nil
+	}
+
+	fail := func(format string, args ...interface{}) error {
+		return &SchemaError{Pointer: pointer, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if schema.Type != "" && !matchesType(schema.Type, value) {
+		return fail("se esperaba %s, se recibió %s", schema.Type, jsonTypeName(value))
+	}
+
+	if len(schema.Enum) > 0 && !enumContains(schema.Enum, value) {
+		return fail("debe ser uno de %s", formatEnum(schema.Enum))
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if schema.Minimum != nil && v < *schema.Minimum {
+			return fail("debe ser mayor o igual que %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && v > *schema.Maximum {
+			return fail("debe ser menor o igual que %v", *schema.Maximum)
+		}
+		if schema.ExclusiveMinimum != nil && v <= *schema.ExclusiveMinimum {
+			return fail("debe ser mayor que %v", *schema.ExclusiveMinimum)
+		}
+		if schema.ExclusiveMaximum != nil && v >= *schema.ExclusiveMaximum {
+			return fail("debe ser menor que %v", *schema.ExclusiveMaximum)
+		}
+
+	case string:
+		length := utf8.RuneCountInString(v)
+		if schema.MinLength != nil && length < *schema.MinLength {
+			if *schema.MinLength == 1 {
+				return fail("no puede estar vacío")
+			}
+			return fail("debe tener al menos %d caracteres", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			return fail("debe tener como máximo %d caracteres", *schema.MaxLength)
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(v) {
+			return fail("no cumple el patrón %s", schema.Pattern)
+		}
+		if err := checkFormat(schema.Format, v); err != nil {
+			return fail("%v", err)
+		}
+
+	case map[string]interface{}:
+		if schema.MinProperties != nil && len(v) < *schema.MinProperties {
+			return fail("debe tener al menos %d propiedades", *schema.MinProperties)
+		}
+		if schema.MaxProperties != nil && len(v) > *schema.MaxProperties {
+			return fail("debe tener como máximo %d propiedades", *schema.MaxProperties)
+		}
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				return &SchemaError{Pointer: joinPointer(pointer, name), Message: "es obligatorio"}
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			property, declared := schema.Properties[name]
+			if !declared {
+				if schema.DisallowAdditional {
+					return &SchemaError{Pointer: joinPointer(pointer, name), Message: "propiedad no permitida"}
+				}
+				property = schema.AdditionalProperties
+			}
+			v[name] = property.coerce(v[name])
+			if err := property.validate(v[name], joinPointer(pointer, name)); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		if schema.MinItems != nil && len(v) < *schema.MinItems {
+			return fail("debe tener al menos %d elementos", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(v) > *schema.MaxItems {
+			return fail("debe tener como máximo %d elementos", *schema.MaxItems)
+		}
+		for i := range v {
+			v[i] = schema.Items.coerce(v[i])
+			if err := schema.Items.validate(v[i], joinPointer(pointer, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// coerce convierte en número el texto numérico de un valor con numericString;
+// cualquier otro valor se devuelve tal cual para que validate lo rechace
+func (schema *JSONSchema) coerce(value interface{}) interface{} {
+	text, ok := value.(string)
+	if schema == nil || !schema.numericString || !ok {
+		return value
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return value
+	}
+	return f
+}
+
+// Expresiones de los formatos que comprobamos; el resto se aceptan sin validar
+var formatPatterns = map[string]*regexp.Regexp{
+	"date":      regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`),
+	"date-time": regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})$`),
+	"email":     regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`),
+}
+
+func checkFormat(format, value string) error {
+	re, ok := formatPatterns[format]
+	if !ok || re.MatchString(value) {
+		return nil
+	}
+	return fmt.Errorf("no tiene formato %s", format)
+}
+
+func matchesType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	default:
+		return jsonTypeName(value) == schemaType || (schemaType == "number" && jsonTypeName(value) == "integer")
+	}
+}
+
+// jsonTypeName devuelve el tipo JSON de un valor decodificado con encoding/json
+func jsonTypeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func enumContains(options []interface{}, value interface{}) bool {
+	for _, option := range options {
+		if reflect.DeepEqual(option, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(options []interface{}) string {
+	parts := make([]string, len(options))
+	for i, option := range options {
+		data, _ := json.Marshal(option)
+		parts[i] = string(data)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// joinPointer añade un segmento a un JSON Pointer escapando '~' y '/'
+func joinPointer(pointer, segment string) string {
+	segment = strings.ReplaceAll(segment, "~", "~0")
+	segment = strings.ReplaceAll(segment, "/", "~1")
+	return pointer + "/" + segment
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
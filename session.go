@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Versiones del protocolo MCP soportadas por el servidor
+const (
+	protocolVersion20241105 = "2024-11-05"
+	protocolVersion20250326 = "2025-03-26"
+	protocolVersion20250618 = "2025-06-18"
+
+	latestProtocolVersion = protocolVersion20250618
+)
+
+// Ordenadas de la más reciente a la más antigua
+var supportedProtocolVersions = []string{
+	protocolVersion20250618,
+	protocolVersion20250326,
+	protocolVersion20241105,
+}
+
+// negotiateProtocolVersion devuelve la versión solicitada si la soportamos o,
+// en caso contrario, la más reciente para que el cliente decida si continúa.
+func negotiateProtocolVersion(requested string) string {
+	for _, version := range supportedProtocolVersions {
+		if version == requested {
+			return version
+		}
+	}
+	return latestProtocolVersion
+}
+
+// Información que el cliente envía en initialize
+type ClientInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type InitializeParams struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ClientInfo      ClientInfo             `json:"clientInfo"`
+	// Token compartido del transporte TCP (ver TCP_AUTH_TOKEN)
+	AuthToken string `json:"authToken,omitempty"`
+}
+
+// Transportes por los que puede llegar una sesión
+const (
+	transportStdio = "stdio"
+	transportTCP   = "tcp"
+	transportHTTP  = "http"
+)
+
+// Estados del ciclo de vida de una sesión MCP
+type sessionState int
+
+const (
+	// Recién conectada: solo se acepta initialize
+	sessionCreated sessionState = iota
+	// initialize respondido, a la espera de notifications/initialized
+	sessionInitializing
+	// El cliente confirmó la inicialización
+	sessionReady
+)
+
+// Session guarda el estado negociado con un cliente concreto. Cada conexión
+// TCP y el canal stdio tienen su propia sesión.
+type Session struct {
+	id         string
+	transport  string
+	remoteAddr string
+
+	// Las respuestas se escriben desde varias goroutines
+	writeMu sync.Mutex
+	out     io.Writer
+
+	// ctx se cancela al cerrar la sesión y de él derivan todas las peticiones
+	ctx      context.Context
+	cancel   context.CancelFunc
+	requests sync.WaitGroup
+
+	mu                 sync.Mutex
+	state              sessionState
+	protocolVersion    string
+	clientCapabilities map[string]interface{}
+	clientInfo         ClientInfo
+	inFlight           map[string]context.CancelFunc
+	lastActivity       time.Time
+	clientLogLevel     slog.Level
+	identity           *Identity
+
+	// reachable indica si el cliente puede recibir ahora mensajes del
+	// servidor; nil si siempre puede. En HTTP, solo con el stream SSE abierto.
+	reachable func() bool
+
+	// logger escribe en el log del servidor y envía notifications/message al cliente
+	logger *slog.Logger
+
+	// Peticiones que el servidor envió al cliente y esperan respuesta
+	nextRequestID int
+	pending       map[string]chan MCPMessage
+
+	// Se cierra al terminar la entrada: ya no puede llegar ninguna respuesta
+	inputClosed chan struct{}
+	endInput    sync.Once
+}
+
+// Tiempo máximo de espera de una petición al cliente que no lleva plazo
+// propio. La elicitación depende de que el usuario conteste, así que es
+// generoso; evita que una herramienta quede bloqueada para siempre.
+var clientRequestTimeout = 5 * time.Minute
+
+func newSession(transport, remoteAddr string, out io.Writer) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &Session{
+		id:                 newSessionID(),
+		transport:          transport,
+		remoteAddr:         remoteAddr,
+		out:                out,
+		ctx:                ctx,
+		cancel:             cancel,
+		state:              sessionCreated,
+		protocolVersion:    protocolVersion20241105,
+		clientCapabilities: map[string]interface{}{},
+		inFlight:           map[string]context.CancelFunc{},
+		lastActivity:       time.Now(),
+		clientLogLevel:     defaultClientLogLevel,
+		pending:            map[string]chan MCPMessage{},
+		inputClosed:        make(chan struct{}),
+	}
+
+	serverLog := slog.Default().Handler().WithAttrs([]slog.Attr{slog.String("transport", transport)})
+	if remoteAddr != "" {
+		serverLog = serverLog.WithAttrs([]slog.Attr{slog.String("remote", remoteAddr)})
+	}
+	sess.logger = slog.New(teeHandler{serverLog, &clientLogHandler{sess: sess}})
+
+	return sess
+}
+
+// write envía un mensaje ya serializado al cliente, uno por línea
+func (sess *Session) write(message []byte) error {
+	if len(message) == 0 {
+		return nil
+	}
+
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	_, err := sess.out.Write(append(message, '\n'))
+	return err
+}
+
+// requestKey normaliza un ID JSON-RPC para usarlo como clave: 1 y "1" son
+// IDs distintos.
+func requestKey(id interface{}) string {
+	data, _ := json.Marshal(id)
+	return string(data)
+}
+
+// beginRequest registra una petición en curso y devuelve su contexto. La
+// función devuelta debe llamarse al terminar la petición.
+func (sess *Session) beginRequest(id interface{}) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(sess.ctx)
+	key := requestKey(id)
+
+	sess.mu.Lock()
+	sess.inFlight[key] = cancel
+	sess.mu.Unlock()
+	sess.requests.Add(1)
+
+	return ctx, func() {
+		sess.mu.Lock()
+		delete(sess.inFlight, key)
+		sess.mu.Unlock()
+		cancel()
+		sess.requests.Done()
+	}
+}
+
+// cancelRequest atiende notifications/cancelled. Devuelve false si la petición
+// ya había terminado o no existe.
+func (sess *Session) cancelRequest(id interface{}) bool {
+	sess.mu.Lock()
+	cancel, ok := sess.inFlight[requestKey(id)]
+	sess.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// closeInput indica que el cliente no enviará más mensajes. Las peticiones
+// en curso siguen hasta terminar, pero las que esperan una respuesta del
+// cliente fallan en el acto.
+func (sess *Session) closeInput() {
+	sess.endInput.Do(func() { close(sess.inputClosed) })
+}
+
+// close cancela todas las peticiones en curso y espera a que terminen
+func (sess *Session) close() {
+	sess.closeInput()
+	sess.cancel()
+	sess.requests.Wait()
+
+	sess.mu.Lock()
+	sess.pending = map[string]chan MCPMessage{}
+	sess.mu.Unlock()
+}
+
+// touch registra actividad del cliente para el keepalive
+func (sess *Session) touch() {
+	sess.mu.Lock()
+	sess.lastActivity = time.Now()
+	sess.mu.Unlock()
+}
+
+func (sess *Session) idleSince() time.Time {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.lastActivity
+}
+
+// request envía una petición al cliente y espera su respuesta, como mucho
+// clientRequestTimeout si ctx no tiene plazo. Los IDs llevan prefijo para no
+// confundirse con los que usa el cliente.
+func (sess *Session) request(ctx context.Context, method string, params interface{}) (MCPMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, clientRequestTimeout)
+		defer cancel()
+	}
+
+	sess.mu.Lock()
+	sess.nextRequestID++
+	id := fmt.Sprintf("srv-%d", sess.nextRequestID)
+	reply := make(chan MCPMessage, 1)
+	sess.pending[requestKey(id)] = reply
+	sess.mu.Unlock()
+
+	defer func() {
+		sess.mu.Lock()
+		delete(sess.pending, requestKey(id))
+		sess.mu.Unlock()
+	}()
+
+	data, err := json.Marshal(MCPMessage{
+		JsonRPC: "2.0",
+		ID:      id,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return MCPMessage{}, err
+	}
+	if err := sess.write(data); err != nil {
+		return MCPMessage{}, err
+	}
+
+	select {
+	case response := <-reply:
+		if response.Error != nil {
+			return response, fmt.Errorf("el cliente respondió con error %d: %s", response.Error.Code, response.Error.Message)
+		}
+		return response, nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return MCPMessage{}, fmt.Errorf("el cliente no respondió a %s a tiempo", method)
+		}
+		return MCPMessage{}, ctx.Err()
+	case <-sess.inputClosed:
+		return MCPMessage{}, fmt.Errorf("el cliente cerró la conexión sin responder a %s", method)
+	case <-sess.ctx.Done():
+		return MCPMessage{}, fmt.Errorf("sesión cerrada")
+	}
+}
+
+// handleResponse entrega la respuesta del cliente a la petición que la espera.
+// Devuelve false si nadie la esperaba.
+func (sess *Session) handleResponse(msg MCPMessage) bool {
+	sess.mu.Lock()
+	reply, ok := sess.pending[requestKey(msg.ID)]
+	sess.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	// Una respuesta duplicada no debe bloquear la lectura
+	select {
+	case reply <- msg:
+	default:
+	}
+	return true
+}
+
+// initialize negocia la versión del protocolo y guarda las capacidades del
+// cliente. Solo puede hacerse una vez por sesión.
+func (sess *Session) initialize(params InitializeParams) (string, error) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.state != sessionCreated {
+		return "", fmt.Errorf("la sesión ya está inicializada")
+	}
+	sess.state = sessionInitializing
+
+	sess.protocolVersion = negotiateProtocolVersion(params.ProtocolVersion)
+	sess.clientCapabilities = params.Capabilities
+	if sess.clientCapabilities == nil {
+		sess.clientCapabilities = map[string]interface{}{}
+	}
+	sess.clientInfo = params.ClientInfo
+
+	return sess.protocolVersion, nil
+}
+
+// markInitialized procesa notifications/initialized. Devuelve false si llega
+// antes de initialize o repetida.
+func (sess *Session) markInitialized() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.state != sessionInitializing {
+		return false
+	}
+	sess.state = sessionReady
+	return true
+}
+
+// acceptsRequests indica si initialize ya fue respondido. No exigimos
+// notifications/initialized porque algunos clientes la envían junto a la
+// primera petición y el orden de llegada no está garantizado.
+func (sess *Session) acceptsRequests() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.state != sessionCreated
+}
+
+// IsInitialized indica si el cliente confirmó la inicialización
+func (sess *Session) IsInitialized() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.state == sessionReady
+}
+
+func (sess *Session) ClientInfo() ClientInfo {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.clientInfo
+}
+
+// String describe la sesión para los logs
+func (sess *Session) String() string {
+	info := sess.ClientInfo()
+	client := info.Name
+	if client == "" {
+		client = "cliente desconocido"
+	}
+	if info.Version != "" {
+		client += " " + info.Version
+	}
+	if sess.remoteAddr != "" {
+		return fmt.Sprintf("%s (%s %s)", client, sess.transport, sess.remoteAddr)
+	}
+	return fmt.Sprintf("%s (%s)", client, sess.transport)
+}
+
+func (sess *Session) setIdentity(identity Identity) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.identity = &identity
+}
+
+// Identity devuelve quién está al otro lado de la sesión, si ya se sabe
+func (sess *Session) Identity() (Identity, bool) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.identity == nil {
+		return Identity{}, false
+	}
+	return *sess.identity, true
+}
+
+func (sess *Session) ProtocolVersion() string {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.protocolVersion
+}
+
+func (sess *Session) hasClientCapability(name string) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	_, ok := sess.clientCapabilities[name]
+	return ok
+}
+
+// Las fechas de versión se comparan como cadenas porque siguen el formato AAAA-MM-DD
+func (sess *Session) atLeast(version string) bool {
+	return sess.ProtocolVersion() >= version
+}
+
+// structuredContent apareció en 2025-06-18
+func (sess *Session) supportsStructuredContent() bool {
+	return sess.atLeast(protocolVersion20250618)
+}
+
+// Las anotaciones de herramientas aparecieron en 2025-03-26
+func (sess *Session) supportsToolAnnotations() bool {
+	return sess.atLeast(protocolVersion20250326)
+}
+
+// La elicitación requiere 2025-06-18 y que el cliente la anuncie
+func (sess *Session) supportsElicitation() bool {
+	return sess.atLeast(protocolVersion20250618) && sess.hasClientCapability("elicitation")
+}
+
+// decodeParams convierte los params genéricos de un mensaje en una estructura tipada
+func decodeParams(params interface{}, v interface{}) error {
+	if params == nil {
+		return nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateProtocolVersion(t *testing.T) {
+	cases := map[string]string{
+		"2024-11-05": "2024-11-05",
+		"2025-03-26": "2025-03-26",
+		"2025-06-18": "2025-06-18",
+		"2099-01-01": latestProtocolVersion,
+		"":           latestProtocolVersion,
+	}
+
+	for requested, expected := range cases {
+		if got := negotiateProtocolVersion(requested); got != expected {
+			t.Errorf("Versión negociada para %q: esperado %s, obtenido %s", requested, expected, got)
+		}
+	}
+}
+
+// Test helper para procesar un mensaje y decodificar la respuesta
+func processTestMessage(t *testing.T, s *Server, sess *Session, message MCPMessage) MCPMessage {
+	t.Helper()
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("Error serializando mensaje: %v", err)
+	}
+
+	var response MCPMessage
+	if err := json.Unmarshal(s.processMessage(context.Background(), sess, data), &response); err != nil {
+		t.Fatalf("Error deserializando respuesta: %v", err)
+	}
+	return response
+}
+
+func TestInitializeStoresSessionState(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportStdio, "", io.Discard)
+
+	response := processTestMessage(t, s, sess, MCPMessage{
+		JsonRPC: "2.0",
+		ID:      1,
+		Method:  "initialize",
+		Params: map[string]interface{}{
+			"protocolVersion": "2025-06-18",
+			"capabilities": map[string]interface{}{
+				"elicitation": map[string]interface{}{},
+			},
+			"clientInfo": map[string]interface{}{
+				"name":    "test-client",
+				"version": "0.1.0",
+			},
+		},
+	})
+
+	if response.Error != nil {
+		t.Fatalf("Error en respuesta: %v", response.Error)
+	}
+
+	result, ok := response.Result.(map[string]interface{})
+	if !ok {
+		t.Fatal("Respuesta no tiene formato esperado")
+	}
+	if result["protocolVersion"] != "2025-06-18" {
+		t.Errorf("Versión de protocolo incorrecta: %v", result["protocolVersion"])
+	}
+
+	if !sess.supportsStructuredContent() || !sess.supportsToolAnnotations() || !sess.supportsElicitation() {
+		t.Error("La sesión debería habilitar las funciones de 2025-06-18")
+	}
+}
+
+func TestOlderProtocolDisablesNewFeatures(t *testing.T) {
+	sess := newSession(transportStdio, "", io.Discard)
+	sess.initialize(InitializeParams{
+		ProtocolVersion: "2024-11-05",
+		Capabilities:    map[string]interface{}{"elicitation": map[string]interface{}{}},
+	})
+
+	if sess.supportsStructuredContent() || sess.supportsToolAnnotations() || sess.supportsElicitation() {
+		t.Error("2024-11-05 no debería habilitar funciones más nuevas")
+	}
+
+	result := toolResult(sess, []string{"a"})
+	if _, ok := result["structuredContent"]; ok {
+		t.Error("structuredContent no debería enviarse con 2024-11-05")
+	}
+}
+
+func TestToolResultWrapsNonObjects(t *testing.T) {
+	sess := newSession(transportStdio, "", io.Discard)
+	sess.initialize(InitializeParams{ProtocolVersion: "2025-06-18"})
+
+	result := toolResult(sess, []string{"a", "b"})
+	data, _ := json.Marshal(result["structuredContent"])
+
+	var structured map[string]interface{}
+	if err := json.Unmarshal(data, &structured); err != nil {
+		t.Fatalf("structuredContent debe ser un objeto: %s", data)
+	}
+	if _, ok := structured["result"]; !ok {
+		t.Errorf("Se esperaba la clave 'result': %s", data)
+	}
+}
+
+func TestRequestsRejectedBeforeInitialize(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportTCP, "127.0.0.1:5000", io.Discard)
+
+	response := processTestMessage(t, s, sess, MCPMessage{
+		JsonRPC: "2.0",
+		ID:      1,
+		Method:  "tools/list",
+	})
+	if response.Error == nil || response.Error.Code != -32002 {
+		t.Fatalf("Se esperaba error -32002 antes de initialize: %+v", response.Error)
+	}
+
+	processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 2, Method: "initialize"})
+
+	response = processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 3, Method: "tools/list"})
+	if response.Error != nil {
+		t.Fatalf("tools/list debería aceptarse tras initialize: %v", response.Error)
+	}
+
+	response = processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 4, Method: "initialize"})
+	if response.Error == nil || response.Error.Code != -32600 {
+		t.Errorf("Se esperaba error al repetir initialize: %+v", response.Error)
+	}
+}
+
+func TestInitializedNotification(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportStdio, "", io.Discard)
+
+	notification, _ := json.Marshal(MCPMessage{JsonRPC: "2.0", Method: "notifications/initialized"})
+	if out := s.processMessage(context.Background(), sess, notification); len(out) != 0 {
+		t.Errorf("Las notificaciones no llevan respuesta: %s", out)
+	}
+	if sess.IsInitialized() {
+		t.Error("notifications/initialized antes de initialize no debería cambiar el estado")
+	}
+
+	processTestMessage(t, s, sess, MCPMessage{
+		JsonRPC: "2.0",
+		ID:      1,
+		Method:  "initialize",
+		Params: map[string]interface{}{
+			"clientInfo": map[string]interface{}{"name": "test-client", "version": "0.1.0"},
+		},
+	})
+	s.processMessage(context.Background(), sess, notification)
+
+	if !sess.IsInitialized() {
+		t.Error("La sesión debería estar inicializada")
+	}
+	if sess.ClientInfo().Name != "test-client" {
+		t.Errorf("Cliente incorrecto: %+v", sess.ClientInfo())
+	}
+}
+
+func TestCancelledNotificationCancelsRequest(t *testing.T) {
+	s := &Server{}
+	sess := newSession(transportStdio, "", io.Discard)
+	processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+
+	ctx, done := sess.beginRequest(7)
+	defer done()
+
+	// Un ID de otro tipo no debe cancelar la petición
+	other, _ := json.Marshal(MCPMessage{
+		JsonRPC: "2.0",
+		Method:  "notifications/cancelled",
+		Params:  map[string]interface{}{"requestId": "7"},
+	})
+	s.processMessage(context.Background(), sess, other)
+	if ctx.Err() != nil {
+		t.Fatal("El ID \"7\" no debería cancelar la petición 7")
+	}
+
+	cancelled, _ := json.Marshal(MCPMessage{
+		JsonRPC: "2.0",
+		Method:  "notifications/cancelled",
+		Params:  map[string]interface{}{"requestId": 7, "reason": "el usuario la canceló"},
+	})
+	if out := s.processMessage(context.Background(), sess, cancelled); len(out) != 0 {
+		t.Errorf("Las notificaciones no llevan respuesta: %s", out)
+	}
+	if ctx.Err() == nil {
+		t.Error("La petición debería estar cancelada")
+	}
+}
+
+func TestSessionCloseCancelsInFlightRequests(t *testing.T) {
+	sess := newSession(transportTCP, "127.0.0.1:5000", io.Discard)
+	ctx, done := sess.beginRequest("a")
+
+	go func() {
+		<-ctx.Done()
+		done()
+	}()
+
+	sess.close()
+	if ctx.Err() == nil {
+		t.Error("Cerrar la sesión debería cancelar las peticiones en curso")
+	}
+}
+
+func TestServeSessionAnswersEveryRequest(t *testing.T) {
+	s := &Server{}
+	var out bytes.Buffer
+	sess := newSession(transportStdio, "", &out)
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/list"}`,
+	}, "\n")
+
+	if err := s.serveSession(sess, strings.NewReader(input)); err != nil {
+		t.Fatalf("Error sirviendo la sesión: %v", err)
+	}
+
+	// Las peticiones se atienden en paralelo, así que el orden no está garantizado
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var response MCPMessage
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			t.Fatalf("Respuesta inválida: %s", scanner.Bytes())
+		}
+		if response.Error != nil {
+			t.Errorf("Error en respuesta %v: %v", response.ID, response.Error)
+		}
+		ids[requestKey(response.ID)] = true
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		if !ids[id] {
+			t.Errorf("Falta la respuesta a la petición %s", id)
+		}
+	}
+	if len(ids) != 3 {
+		t.Errorf("Se esperaban 3 respuestas, obtenidas %d", len(ids))
+	}
+}
+
+func TestClientRequestFailsWhenInputEnds(t *testing.T) {
+	sess := newSession(transportStdio, "", io.Discard)
+	defer sess.close()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := sess.request(context.Background(), "elicitation/create", nil)
+		result <- err
+	}()
+
+	sess.closeInput()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Sin entrada la petición al cliente debería fallar")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("La petición al cliente sigue esperando tras el fin de la entrada")
+	}
+}
+
+func TestClientRequestTimeout(t *testing.T) {
+	defer func(previous time.Duration) { clientRequestTimeout = previous }(clientRequestTimeout)
+	clientRequestTimeout = 20 * time.Millisecond
+
+	sess := newSession(transportStdio, "", io.Discard)
+	defer sess.close()
+
+	if _, err := sess.request(context.Background(), "sampling/createMessage", nil); err == nil {
+		t.Error("Una petición sin respuesta debería caducar")
+	}
+}
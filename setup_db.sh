@@ -28,4 +28,4 @@ else
 fi
 
 echo "🎉 Base de datos configurada exitosamente!"
-echo "🚀 Ahora puedes ejecutar el servidor con: go run main.go"
\ No newline at end of file
+echo "🚀 Ahora puedes ejecutar el servidor con: go run ."
\ No newline at end of file
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// watchSignals no hace nada en sistemas sin SIGUSR1
+func (s *Server) watchSignals() {}
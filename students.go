@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Coincidencias entre estudiantes
+const (
+	matchExact         = "exact"          // el mismo nombre, sin contar mayúsculas ni tildes
+	matchSimilar       = "similar"        // un nombre parecido
+	matchStudentNumber = "student_number" // el mismo número de expediente
+)
+
+// studentRef identifica a un estudiante en los argumentos de una herramienta:
+// por su id o por su nombre
+type studentRef struct {
+	ID   string
+	Name string
+}
+
+// studentRefArgs son los argumentos con los que una herramienta identifica al
+// estudiante. Se incrustan en los argumentos de cada herramienta.
+type studentRefArgs struct {
+	Name string `json:"name,omitempty" description:"Nombre del estudiante" jsonschema:"minLength=1,maxLength=200"`
+	ID   string `json:"id,omitempty" description:"Id del estudiante, en lugar del nombre; necesario si hay varios con el mismo nombre" jsonschema:"pattern=^[0-9a-f]{24}$"`
+}
+
+func (args studentRefArgs) ref() studentRef {
+	return studentRef{ID: args.ID, Name: args.Name}
+}
+
+func (ref studentRef) isZero() bool {
+	return ref.ID == "" && ref.Name == ""
+}
+
+func (ref studentRef) String() string {
+	if ref.ID != "" {
+		return "con id " + ref.ID
+	}
+	return "'" + ref.Name + "'"
+}
+
+// check exige exactamente uno de los dos argumentos
+func (ref studentRef) check(namePointer, idPointer string) error {
+	switch {
+	case ref.isZero():
+		return &InvalidParamsError{Message: fmt.Sprintf("argumento '%s': indica el nombre o el id del estudiante", namePointer), Pointer: namePointer}
+	case ref.ID != "" && ref.Name != "":
+		return &InvalidParamsError{Message: fmt.Sprintf("argumento '%s': indica el nombre o el id del estudiante, no los dos", idPointer), Pointer: idPointer}
+	}
+	return nil
+}
+
+// StudentCandidate es un estudiante que encaja con una búsqueda ambigua o con
+// un estudiante nuevo
+type StudentCandidate struct {
+	ID            primitive.ObjectID `json:"id"`
+	Name          string             `json:"name"`
+	StudentNumber string             `json:"student_number,omitempty"`
+	BirthDate     string             `json:"birth_date,omitempty"`
+	Match         string             `json:"match,omitempty"`
+}
+
+// candidateFor resume un estudiante con lo que el rol puede ver de él
+func candidateFor(role Role, student Student, match string) StudentCandidate {
+	candidate := StudentCandidate{ID: student.ID, Name: student.Name, Match: match}
+	if redacted, visible := role.redact(student); visible && redacted.Profile != nil {
+		candidate.StudentNumber = redacted.Profile.StudentNumber
+		candidate.BirthDate = redacted.Profile.BirthDate
+	}
+	return candidate
+}
+
+// StudentMatchError indica que hay varios estudiantes donde se esperaba uno:
+// un nombre ambiguo en una búsqueda o un posible duplicado al añadir. Se
+// responde con -32003 y los candidatos en data, para que el cliente elija
+// uno por su id o confirme el alta.
+type StudentMatchError struct {
+	Message    string
+	Candidates []StudentCandidate
+}
+
+func (e *StudentMatchError) Error() string {
+	return e.Message
+}
+
+// ensureStudentIndexes crea los índices que impiden repetir un número de
+// expediente o, salvo con allow_duplicate, un nombre, y el que permite buscar
+// duplicados por las palabras del nombre. Los documentos anteriores a
+// name_words lo reciben ahora.
+func ensureStudentIndexes(ctx context.Context, students *mongo.Collection) error {
+	_, err := students.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "profile.student_number", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"profile.student_number": bson.M{"$exists": true},
+			}),
+		},
+		{
+			Keys:    bson.D{{Key: "name_key", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"name_key": bson.M{"$exists": true}}),
+		},
+		{Keys: bson.D{{Key: "name_words", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	cursor, err := students.Find(ctx, bson.M{"name_words": bson.M{"$exists": false}}, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID   primitive.ObjectID `bson:"_id"`
+			Name string             `bson:"name"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if _, err := students.UpdateByID(ctx, doc.ID, bson.M{"$set": bson.M{"name_words": nameWords(doc.Name)}}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// nameWords son las palabras de un nombre sin mayúsculas ni tildes
+func nameWords(name string) []string {
+	return strings.Fields(subjectKey(name))
+}
+
+// findStudent busca un estudiante por id o por nombre exacto entre los que el
+// rol puede ver. Si hay varios con el mismo nombre y el cliente soporta
+// elicitación, se pide al usuario que elija; si no, se devuelven los
+// candidatos para repetir la llamada con el id.
+func (s *Server) findStudent(ctx context.Context, sess *Session, role Role, ref studentRef) (Student, error) {
+	if err := ref.check("/name", "/id"); err != nil {
+		return Student{}, err
+	}
+
+	filter := bson.M{"name": ref.Name}
+	if ref.ID != "" {
+		id, err := primitive.ObjectIDFromHex(ref.ID)
+		if err != nil {
+			return Student{}, &InvalidParamsError{Message: fmt.Sprintf("argumento '/id': %q no es un id válido", ref.ID), Pointer: "/id"}
+		}
+		filter = bson.M{"_id": id}
+	}
+
+	cursor, err := s.collection.Find(ctx, role.restrict(filter))
+	if err != nil {
+		return Student{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var found []Student
+	if err := cursor.All(ctx, &found); err != nil {
+		return Student{}, err
+	}
+
+	// Un estudiante fuera del ámbito del rol se trata como inexistente
+	var candidates []Student
+	for _, student := range found {
+		if student, visible := role.redact(student); visible {
+			candidates = append(candidates, student)
+		}
+	}
+
+	switch {
+	case len(candidates) == 0:
+		return Student{}, fmt.Errorf("estudiante %s no encontrado", ref)
+	case len(candidates) == 1:
+		return candidates[0], nil
+	}
+
+	student, err := sess.elicitStudentChoice(ctx, ref.Name, candidates)
+	if err == errElicitationUnsupported {
+		matches := make([]StudentCandidate, len(candidates))
+		ids := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			matches[i] = candidateFor(role, candidate, matchExact)
+			ids[i] = candidate.ID.Hex()
+		}
+		return Student{}, &StudentMatchError{
+			Message:    fmt.Sprintf("hay %d estudiantes llamados '%s'; indica su id (%s)", len(candidates), ref.Name, strings.Join(ids, ", ")),
+			Candidates: matches,
+		}
+	}
+	return student, err
+}
+
+// nameMatch compara dos nombres de estudiante: exact si son el mismo sin
+// contar mayúsculas, tildes ni espacios; similar si solo se diferencian en
+// una o dos letras, en el orden de las palabras o en que uno añade palabras
+// al otro (un segundo apellido); vacío si no se parecen
+func nameMatch(a, b string) string {
+	ka, kb := subjectKey(a), subjectKey(b)
+	if ka == kb {
+		return matchExact
+	}
+
+	wa, wb := strings.Fields(ka), strings.Fields(kb)
+	if len(wa) > len(wb) {
+		wa, wb = wb, wa
+	}
+	if len(wa) >= 2 && containsAll(wb, wa) {
+		return matchSimilar
+	}
+
+	tolerance := 1
+	if len([]rune(ka)) >= 8 {
+		tolerance = 2
+	}
+	if editDistance(ka, kb) <= tolerance {
+		return matchSimilar
+	}
+	return ""
+}
+
+func containsAll(items, wanted []string) bool {
+	for _, w := range wanted {
+		if !containsString(items, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// editDistance es la distancia de Levenshtein entre dos textos
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// duplicateCandidates busca entre los estudiantes existentes los que podrían
+// ser el mismo que uno nuevo. El número de expediente no se puede repetir;
+// los nombres iguales o parecidos se aceptan si se confirma el alta.
+// Devuelve primero los de nombre igual.
+func duplicateCandidates(existing []Student, name string, profile *Profile) (sameNumber *Student, names []Student, matches []string) {
+	number := ""
+	if profile != nil {
+		number = profile.StudentNumber
+	}
+	var similar []Student
+	for i, student := range existing {
+		if number != "" && student.Profile != nil && student.Profile.StudentNumber == number {
+			sameNumber = &existing[i]
+			continue
+		}
+		switch nameMatch(name, student.Name) {
+		case matchExact:
+			names = append(names, student)
+			matches = append(matches, matchExact)
+		case matchSimilar:
+			similar = append(similar, student)
+		}
+	}
+	for _, student := range similar {
+		names = append(names, student)
+		matches = append(matches, matchSimilar)
+	}
+	return sameNumber, names, matches
+}
+
+// checkDuplicates impide añadir un estudiante que ya existe. Solo se leen los
+// que tienen el mismo número de expediente o alguna palabra del nombre en
+// común, con los índices de ensureStudentIndexes. Se comprueba contra todos
+// los estudiantes, no solo los que ve el rol, pero de los que no ve solo se
+// devuelve el id y el nombre.
+func (s *Server) checkDuplicates(ctx context.Context, role Role, name string, profile *Profile, allowSimilar bool) error {
+	filter := bson.M{"name_words": bson.M{"$in": nameWords(name)}}
+	if profile != nil && profile.StudentNumber != "" {
+		filter = bson.M{"$or": bson.A{filter, bson.M{"profile.student_number": profile.StudentNumber}}}
+	}
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var existing []Student
+	if err := cursor.All(ctx, &existing); err != nil {
+		return err
+	}
+
+	sameNumber, names, matches := duplicateCandidates(existing, name, profile)
+	if sameNumber != nil {
+		return &StudentMatchError{
+			Message:    fmt.Sprintf("el número de expediente %s ya es de %s", profile.StudentNumber, sameNumber.Name),
+			Candidates: []StudentCandidate{candidateFor(role, *sameNumber, matchStudentNumber)},
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	if allowSimilar {
+		// Un homónimo dejaría sin acceso a quien ROLES_FILE asigna por nombre
+		if subject, bound := s.nameBinding(name); bound && matches[0] == matchExact {
+			return &InvalidParamsError{
+				Message: fmt.Sprintf("argumento '/allow_duplicate': '%s' está asignado por nombre a %s en ROLES_FILE; asígnalo por student_number antes de añadir otro estudiante con el mismo nombre", name, subject),
+				Pointer: "/allow_duplicate",
+			}
+		}
+		return nil
+	}
+
+	candidates := make([]StudentCandidate, len(names))
+	for i, student := range names {
+		candidates[i] = candidateFor(role, student, matches[i])
+	}
+	message := fmt.Sprintf("ya hay un estudiante con un nombre parecido a '%s' (%s)", name, names[0].Name)
+	if matches[0] == matchExact {
+		message = fmt.Sprintf("ya hay un estudiante llamado '%s'", names[0].Name)
+	}
+	if len(names) > 1 {
+		message += fmt.Sprintf(" y %d más", len(names)-1)
+	}
+	return &StudentMatchError{
+		Message:    message + "; si es otra persona, repite la llamada con allow_duplicate",
+		Candidates: candidates,
+	}
+}
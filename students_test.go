@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNameMatch(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, expected string
+	}{
+		{"Juan Pérez", "juan perez", matchExact},
+		{"Juan  Pérez ", "JUAN PÉREZ", matchExact},
+		{"Juan Pérez", "Juan Peres", matchSimilar},
+		{"Juan Pérez", "Pérez Juan", matchSimilar},
+		{"Juan Pérez", "Juan Pérez García", matchSimilar},
+		{"María García", "Mario García", matchSimilar},
+		{"Ana", "Eva", ""},
+		{"Juan Pérez", "Juana Martínez", ""},
+		{"Ana", "Ana López", ""},
+	} {
+		if match := nameMatch(tc.a, tc.b); match != tc.expected {
+			t.Errorf("%s / %s: %q, esperado %q", tc.a, tc.b, match, tc.expected)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"ana", "", 3},
+		{"perez", "peres", 1},
+		{"garcia", "gracia", 2},
+		{"muñoz", "munoz", 1},
+	} {
+		if d := editDistance(tc.a, tc.b); d != tc.distance {
+			t.Errorf("%s / %s: %d, esperado %d", tc.a, tc.b, d, tc.distance)
+		}
+	}
+}
+
+func TestDuplicateCandidates(t *testing.T) {
+	existing := []Student{
+		{ID: primitive.NewObjectID(), Name: "Juan Peres"},
+		{ID: primitive.NewObjectID(), Name: "Juan Pérez"},
+		{ID: primitive.NewObjectID(), Name: "Ana López", Profile: &Profile{StudentNumber: "2024-017"}},
+		{ID: primitive.NewObjectID(), Name: "Luis Martín"},
+	}
+
+	sameNumber, names, matches := duplicateCandidates(existing, "juan pérez", nil)
+	if sameNumber != nil || len(names) != 2 {
+		t.Fatalf("Candidatos incorrectos: %v %v", sameNumber, names)
+	}
+	if names[0].Name != "Juan Pérez" || matches[0] != matchExact || matches[1] != matchSimilar {
+		t.Errorf("Los de nombre igual van primero: %v %v", names, matches)
+	}
+
+	sameNumber, _, _ = duplicateCandidates(existing, "Luisa Gómez", &Profile{StudentNumber: "2024-017"})
+	if sameNumber == nil || sameNumber.Name != "Ana López" {
+		t.Errorf("Un número de expediente repetido es un duplicado: %v", sameNumber)
+	}
+
+	if sameNumber, names, _ := duplicateCandidates(existing, "Carmen Ruiz", &Profile{StudentNumber: "2024-018"}); sameNumber != nil || len(names) != 0 {
+		t.Errorf("Sin coincidencias: %v %v", sameNumber, names)
+	}
+}
+
+func TestNameKeysAreStored(t *testing.T) {
+	data, err := bson.Marshal(Student{Name: "José  Martín", Subjects: map[string]float64{}, NameKey: subjectKey("José  Martín")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		NameWords []string `bson:"name_words"`
+		NameKey   string   `bson:"name_key"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc.NameWords, []string{"jose", "martin"}) || doc.NameKey != "jose martin" {
+		t.Errorf("Claves de búsqueda incorrectas: %+v", doc)
+	}
+
+	// Un estudiante añadido con allow_duplicate no reserva el nombre
+	data, _ = bson.Marshal(Student{Name: "José Martín", Subjects: map[string]float64{}})
+	var raw bson.M
+	bson.Unmarshal(data, &raw)
+	if _, ok := raw["name_key"]; ok {
+		t.Errorf("Sin name_key no se guarda: %v", raw)
+	}
+}
+
+func TestStudentRefCheck(t *testing.T) {
+	var invalid *InvalidParamsError
+
+	if err := (studentRef{}).check("/name", "/id"); !errors.As(err, &invalid) || invalid.Pointer != "/name" {
+		t.Errorf("Sin nombre ni id: %v", err)
+	}
+	both := studentRef{ID: primitive.NewObjectID().Hex(), Name: "Ana"}
+	if err := both.check("/student", "/student_id"); !errors.As(err, &invalid) || invalid.Pointer != "/student_id" {
+		t.Errorf("Con nombre e id: %v", err)
+	}
+	if err := (studentRef{Name: "Ana"}).check("/name", "/id"); err != nil {
+		t.Errorf("Solo el nombre es válido: %v", err)
+	}
+
+	// findStudent lo comprueba antes de consultar la base de datos
+	if _, err := (&Server{}).findStudent(context.Background(), sessionFor("admin", authMTLS), adminRole, studentRef{}); !errors.As(err, &invalid) {
+		t.Errorf("findStudent sin nombre ni id: %v", err)
+	}
+}
+
+func TestCandidateForRedactsProfile(t *testing.T) {
+	student := Student{
+		ID:       primitive.NewObjectID(),
+		Name:     "Ana",
+		Subjects: map[string]float64{"matematicas": 8},
+		Profile:  &Profile{StudentNumber: "2024-017", BirthDate: "2010-05-04"},
+	}
+
+	if candidate := candidateFor(adminRole, student, matchExact); candidate.StudentNumber != "2024-017" || candidate.BirthDate != "2010-05-04" {
+		t.Errorf("Un administrador ve el expediente: %+v", candidate)
+	}
+	candidate := candidateFor(testRoles["profesora.garcia"], student, matchExact)
+	if candidate.ID != student.ID || candidate.Name != "Ana" || candidate.StudentNumber != "" {
+		t.Errorf("Un profesor solo ve el id y el nombre: %+v", candidate)
+	}
+}
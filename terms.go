@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Evaluaciones del curso
+const (
+	termFirst  = "1"
+	termSecond = "2"
+	termThird  = "3"
+	termFinal  = "final"
+
+	defaultTermsCollection = "academic_terms"
+)
+
+var regularTerms = []string{termFirst, termSecond, termThird}
+
+// Period es una evaluación de un curso (por ejemplo, la 2ª de 2024-2025).
+// El periodo vacío son las notas actuales, sin distinguir evaluaciones.
+type Period struct {
+	Year string `bson:"year"`
+	Term string `bson:"term"`
+}
+
+func (p Period) IsZero() bool {
+	return p.Term == ""
+}
+
+func (p Period) String() string {
+	if p.Term == termFinal {
+		return "evaluación final " + p.Year
+	}
+	return p.Term + "ª evaluación " + p.Year
+}
+
+// id identifica el periodo en la colección de evaluaciones cerradas
+func (p Period) id() string {
+	return p.Year + "/" + p.Term
+}
+
+// academicYear devuelve el curso de una fecha; empieza en septiembre
+func academicYear(t time.Time) string {
+	start := t.Year()
+	if t.Month() < time.September {
+		start--
+	}
+	return fmt.Sprintf("%d-%d", start, start+1)
+}
+
+// termOf devuelve la evaluación de una fecha según el calendario habitual:
+// la 1ª hasta diciembre, la 2ª hasta marzo y la 3ª el resto del curso
+func termOf(t time.Time) string {
+	switch {
+	case t.Month() >= time.September:
+		return termFirst
+	case t.Month() <= time.March:
+		return termSecond
+	default:
+		return termThird
+	}
+}
+
+// newPeriod construye el periodo de los argumentos de una herramienta. Sin
+// evaluación devuelve el periodo vacío; sin curso, el curso actual.
+func newPeriod(year, term string, now time.Time) (Period, error) {
+	if term == "" {
+		if year != "" {
+			return Period{}, &InvalidParamsError{Message: "argumento '/term': indica la evaluación del curso " + year, Pointer: "/term"}
+		}
+		return Period{}, nil
+	}
+	if year == "" {
+		return Period{Year: academicYear(now), Term: term}, nil
+	}
+
+	// El esquema ya comprueba el formato AAAA-AAAA
+	first, _ := strconv.Atoi(year[:4])
+	second, _ := strconv.Atoi(year[5:])
+	if second != first+1 {
+		return Period{}, &InvalidParamsError{Message: fmt.Sprintf("argumento '/year': %s no es un curso válido", year), Pointer: "/year"}
+	}
+	return Period{Year: year, Term: term}, nil
+}
+
+// assessmentPeriod es la evaluación a la que pertenece una evaluación nueva:
+// la indicada o, si no, la que corresponde a su fecha (o a hoy)
+func assessmentPeriod(year, term, date string, now time.Time) (Period, error) {
+	when := now
+	if date != "" {
+		when, _ = time.Parse("2006-01-02", date)
+	}
+	if term == "" {
+		term = termOf(when)
+		if year == "" {
+			year = academicYear(when)
+		}
+	}
+	if term == termFinal {
+		return Period{}, &InvalidParamsError{Message: "argumento '/term': la evaluación final se calcula al cerrarla, no admite evaluaciones", Pointer: "/term"}
+	}
+	if year == "" {
+		year = academicYear(when)
+	}
+	return newPeriod(year, term, when)
+}
+
+// periodOf devuelve la evaluación a la que pertenece una fecha según el
+// calendario habitual
+func periodOf(t time.Time) Period {
+	return Period{Year: academicYear(t), Term: termOf(t)}
+}
+
+// gradesFor devuelve las notas del estudiante en un periodo y si son
+// definitivas. Las de una evaluación abierta se calculan con las
+// evaluaciones de ese periodo; la final abierta, con la media de las tres.
+// Una nota sin evaluaciones cuenta en la evaluación en la que se puso.
+func (st Student) gradesFor(p Period) (map[string]float64, bool) {
+	if p.IsZero() {
+		return st.Subjects, false
+	}
+	if closed, ok := st.TermGrades[p.Year][p.Term]; ok {
+		return closed, true
+	}
+
+	if p.Term == termFinal {
+		var terms []map[string]float64
+		for _, term := range regularTerms {
+			grades, _ := st.gradesFor(Period{Year: p.Year, Term: term})
+			terms = append(terms, grades)
+		}
+		return finalGrades(terms), false
+	}
+
+	grades := map[string]float64{}
+	for subject, grade := range st.Subjects {
+		assessments := st.Assessments[subject]
+		if len(assessments) == 0 {
+			if st.GradePeriods[subject] == p {
+				grades[subject] = grade
+			}
+			continue
+		}
+		var inTerm []Assessment
+		for _, a := range assessments {
+			if (Period{Year: a.Year, Term: a.Term}) == p {
+				inTerm = append(inTerm, a)
+			}
+		}
+		if len(inTerm) > 0 {
+			grades[subject] = assessmentGrade(inTerm)
+		}
+	}
+	return grades, false
+}
+
+// gradePeriod es la evaluación de la nota sin evaluaciones de una asignatura;
+// si no se guardó, la de now
+func (st Student) gradePeriod(subject string, now time.Time) Period {
+	if period, ok := st.GradePeriods[subject]; ok {
+		return period
+	}
+	return periodOf(now)
+}
+
+// pinUndatedGrades asigna una evaluación a las notas que no la tienen: las
+// puestas antes de que se guardara la evaluación de cada nota y las "Nota
+// anterior" creadas sin ella. La fecha sale del historial de notas: la del
+// último cambio de una nota sin evaluaciones y, para una "Nota anterior", la
+// de la primera evaluación registrada, cuando esa nota aún era la vigente.
+// Sin historial, se usa la evaluación en la que se ejecuta. Una vez guardada,
+// la evaluación de una nota ya no depende de la fecha de la consulta.
+func pinUndatedGrades(ctx context.Context, students, history *mongo.Collection, now time.Time) error {
+	cursor, err := students.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var st Student
+		if err := cursor.Decode(&st); err != nil {
+			return err
+		}
+
+		var lookupErr error
+		set := st.pinUndated(func(subject string, assessed bool) time.Time {
+			filter := bson.M{"student_id": st.ID, "subject": subject}
+			order := -1
+			if assessed {
+				filter["tool"] = "record_assessment"
+				order = 1
+			}
+			var event GradeEvent
+			err := history.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "time", Value: order}})).Decode(&event)
+			if err != nil {
+				if err != mongo.ErrNoDocuments && lookupErr == nil {
+					lookupErr = err
+				}
+				return now
+			}
+			return event.Time
+		})
+		if lookupErr != nil {
+			return lookupErr
+		}
+		if len(set) == 0 {
+			continue
+		}
+
+		// Si cambió mientras tanto, se completa al arrancar la próxima vez
+		if _, err := students.UpdateOne(ctx, studentStateFilter(&st), bson.M{"$set": set}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// pinUndated devuelve los campos que asignan una evaluación a las notas sin
+// ella. when da la fecha de la nota de una asignatura; assessed indica que
+// es una "Nota anterior" entre evaluaciones.
+func (st Student) pinUndated(when func(subject string, assessed bool) time.Time) bson.M {
+	set := bson.M{}
+	for _, subject := range sortedSubjects(st.Subjects) {
+		assessments := st.Assessments[subject]
+		if len(assessments) == 0 {
+			if _, ok := st.GradePeriods[subject]; !ok {
+				set["grade_periods."+subject] = periodOf(when(subject, false))
+			}
+			continue
+		}
+
+		var pinned []Assessment
+		for i, a := range assessments {
+			if a.Term != "" {
+				continue
+			}
+			if pinned == nil {
+				pinned = append([]Assessment(nil), assessments...)
+			}
+			period := periodOf(when(subject, true))
+			pinned[i].Year, pinned[i].Term = period.Year, period.Term
+		}
+		if pinned != nil {
+			set["subjects."+subject+".assessments"] = pinned
+		}
+	}
+	return set
+}
+
+// finalGrades es la media de cada asignatura en las evaluaciones que la tienen
+func finalGrades(terms []map[string]float64) map[string]float64 {
+	totals := map[string]float64{}
+	counts := map[string]int{}
+	for _, grades := range terms {
+		for subject, grade := range grades {
+			totals[subject] += grade
+			counts[subject]++
+		}
+	}
+
+	final := make(map[string]float64, len(totals))
+	for subject, total := range totals {
+		final[subject] = round2(total / float64(counts[subject]))
+	}
+	return final
+}
+
+// sameTermGrades compara las notas cerradas de dos estados de un estudiante
+func sameTermGrades(a, b map[string]map[string]map[string]float64) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// termRecord marca una evaluación como cerrada. Se inserta antes de guardar
+// las notas para que no se registren más evaluaciones en ella; Complete
+// indica que se guardaron las de todos los estudiantes.
+type termRecord struct {
+	ID       string    `bson:"_id" json:"id"`
+	Year     string    `bson:"year" json:"year"`
+	Term     string    `bson:"term" json:"term"`
+	ClosedAt time.Time `bson:"closed_at" json:"closed_at"`
+	ClosedBy string    `bson:"closed_by" json:"closed_by"`
+	Complete bool      `bson:"complete" json:"complete"`
+}
+
+// closedTerms devuelve las evaluaciones cerradas de un curso
+func (s *Server) closedTerms(ctx context.Context, year string) (map[string]termRecord, error) {
+	closed := map[string]termRecord{}
+	if s.terms == nil {
+		return closed, nil
+	}
+
+	cursor, err := s.terms.Find(ctx, bson.M{"year": year})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []termRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		closed[record.Term] = record
+	}
+	return closed, nil
+}
+
+// checkTermOpen impide registrar evaluaciones en una evaluación cerrada
+func (s *Server) checkTermOpen(ctx context.Context, p Period) error {
+	closed, err := s.closedTerms(ctx, p.Year)
+	if err != nil {
+		return err
+	}
+	if _, ok := closed[p.Term]; ok {
+		return fmt.Errorf("la %s está cerrada", p)
+	}
+	return nil
+}
+
+type closeTermArgs struct {
+	Term   string `json:"term" description:"Evaluación que se cierra: 1, 2, 3 o final" jsonschema:"enum=1|2|3|final"`
+	Year   string `json:"year,omitempty" description:"Curso (por ejemplo 2024-2025); por defecto el actual" jsonschema:"pattern=^[0-9]{4}-[0-9]{4}$"`
+	Reason string `json:"reason,omitempty" description:"Motivo del cambio; queda en la auditoría" jsonschema:"maxLength=500"`
+}
+
+// closeTerm cierra una evaluación: guarda en cada estudiante sus notas de ese
+// periodo, que a partir de entonces son definitivas. La final exige las tres
+// evaluaciones cerradas y es la media de ellas. Si un cierre se interrumpe,
+// volver a llamar a la herramienta lo completa.
+func (s *Server) closeTerm(ctx context.Context, req *ToolRequest, p Period) (interface{}, error) {
+	if req.Role.Name != roleAdmin {
+		return nil, fmt.Errorf("%w: solo un administrador puede cerrar evaluaciones", errForbidden)
+	}
+
+	closed, err := s.closedTerms(ctx, p.Year)
+	if err != nil {
+		return nil, err
+	}
+	if record, ok := closed[p.Term]; ok && record.Complete {
+		return nil, fmt.Errorf("la %s ya está cerrada", p)
+	}
+	if p.Term == termFinal {
+		var open []string
+		for _, term := range regularTerms {
+			if record, ok := closed[term]; !ok || !record.Complete {
+				open = append(open, term+"ª")
+			}
+		}
+		if len(open) > 0 {
+			return nil, fmt.Errorf("para cerrar la %s hay que cerrar antes las evaluaciones %s", p, strings.Join(open, ", "))
+		}
+	}
+
+	identity, _ := req.Session.Identity()
+	record := termRecord{ID: p.id(), Year: p.Year, Term: p.Term, ClosedAt: time.Now().UTC(), ClosedBy: identity.Subject}
+	if _, err := s.terms.InsertOne(ctx, record); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	cursor, err := s.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	field := "term_grades." + p.Year + "." + p.Term
+	count := 0
+	for cursor.Next(ctx) {
+		var before Student
+		if err := cursor.Decode(&before); err != nil {
+			return nil, err
+		}
+		grades, alreadyClosed := before.gradesFor(p)
+		if alreadyClosed || len(grades) == 0 {
+			continue
+		}
+
+		result, err := s.collection.UpdateOne(ctx,
+			bson.M{"_id": before.ID, field: bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{field: grades}})
+		if err != nil {
+			return nil, err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+
+		after := before.clone()
+		if after.TermGrades[p.Year] == nil {
+			after.TermGrades[p.Year] = map[string]map[string]float64{}
+		}
+		after.TermGrades[p.Year][p.Term] = grades
+		req.RecordChange(Change{StudentID: before.ID, StudentName: before.Name, Before: &before, After: &after})
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.terms.UpdateOne(ctx, bson.M{"_id": p.id()}, bson.M{"$set": bson.M{"complete": true}}); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"message":  fmt.Sprintf("%s cerrada", capitalize(p.String())),
+		"year":     p.Year,
+		"term":     p.Term,
+		"students": count,
+	}, nil
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcademicCalendar(t *testing.T) {
+	cases := []struct {
+		date string
+		year string
+		term string
+	}{
+		{"2024-09-16", "2024-2025", termFirst},
+		{"2024-12-20", "2024-2025", termFirst},
+		{"2025-01-08", "2024-2025", termSecond},
+		{"2025-03-31", "2024-2025", termSecond},
+		{"2025-06-20", "2024-2025", termThird},
+	}
+	for _, c := range cases {
+		day, _ := time.Parse("2006-01-02", c.date)
+		if year, term := academicYear(day), termOf(day); year != c.year || term != c.term {
+			t.Errorf("%s: curso %s evaluación %s, esperados %s %s", c.date, year, term, c.year, c.term)
+		}
+	}
+}
+
+func TestNewPeriod(t *testing.T) {
+	now := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	if p, err := newPeriod("", termSecond, now); err != nil || p != (Period{Year: "2024-2025", Term: termSecond}) {
+		t.Errorf("Sin curso se usa el actual: %v %v", p, err)
+	}
+	if p, err := newPeriod("", "", now); err != nil || !p.IsZero() {
+		t.Errorf("Sin evaluación no hay periodo: %v %v", p, err)
+	}
+
+	var invalidParams *InvalidParamsError
+	if _, err := newPeriod("2024-2026", termFirst, now); !errors.As(err, &invalidParams) || invalidParams.Pointer != "/year" {
+		t.Errorf("Curso inválido aceptado: %v", err)
+	}
+	if _, err := newPeriod("2024-2025", "", now); !errors.As(err, &invalidParams) || invalidParams.Pointer != "/term" {
+		t.Errorf("Un curso sin evaluación debería rechazarse: %v", err)
+	}
+}
+
+func TestAssessmentPeriod(t *testing.T) {
+	now := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	if p, _ := assessmentPeriod("", "", "2024-10-03", now); p != (Period{Year: "2024-2025", Term: termFirst}) {
+		t.Errorf("El periodo se deduce de la fecha: %v", p)
+	}
+	if p, _ := assessmentPeriod("", "", "", now); p != (Period{Year: "2024-2025", Term: termThird}) {
+		t.Errorf("Sin fecha se usa hoy: %v", p)
+	}
+	if _, err := assessmentPeriod("", termFinal, "", now); err == nil {
+		t.Error("La final no admite evaluaciones")
+	}
+}
+
+func TestGradesForPeriod(t *testing.T) {
+	ana := Student{
+		Name:     "Ana",
+		Subjects: map[string]float64{"matematicas": 7, "historia": 6},
+		Assessments: map[string][]Assessment{
+			"matematicas": {
+				{Name: "Examen 1", Weight: 1, Score: 6, MaxScore: 10, Year: "2024-2025", Term: termFirst},
+				{Name: "Examen 2", Weight: 1, Score: 8, MaxScore: 10, Year: "2024-2025", Term: termSecond},
+			},
+		},
+		TermGrades: map[string]map[string]map[string]float64{
+			"2024-2025": {termFirst: {"matematicas": 6.5}},
+		},
+	}
+
+	if grades, closed := ana.gradesFor(Period{}); closed || !reflect.DeepEqual(grades, ana.Subjects) {
+		t.Errorf("Sin periodo, las notas actuales: %v", grades)
+	}
+	if grades, closed := ana.gradesFor(Period{Year: "2024-2025", Term: termFirst}); !closed || grades["matematicas"] != 6.5 {
+		t.Errorf("Una evaluación cerrada usa sus notas guardadas: %v %v", grades, closed)
+	}
+	if grades, closed := ana.gradesFor(Period{Year: "2024-2025", Term: termSecond}); closed || !reflect.DeepEqual(grades, map[string]float64{"matematicas": 8}) {
+		t.Errorf("Una evaluación abierta se calcula con sus evaluaciones: %v", grades)
+	}
+	if grades, _ := ana.gradesFor(Period{Year: "2024-2025", Term: termFinal}); grades["matematicas"] != 7.25 {
+		t.Errorf("La final es la media de las evaluaciones: %v", grades)
+	}
+}
+
+func TestGradesWithoutAssessmentsKeepTheirPeriod(t *testing.T) {
+	second := Period{Year: "2024-2025", Term: termSecond}
+	luis := Student{
+		Name:         "Luis",
+		Subjects:     map[string]float64{"matematicas": 7, "historia": 6},
+		GradePeriods: map[string]Period{"historia": second},
+		Assessments: map[string][]Assessment{
+			"matematicas": {
+				{Name: legacyAssessmentName, Weight: 1, Score: 5, MaxScore: 10, Year: "2024-2025", Term: termFirst},
+				{Name: "Examen 2", Weight: 1, Score: 9, MaxScore: 10, Year: "2024-2025", Term: termSecond},
+			},
+		},
+	}
+
+	if grades, _ := luis.gradesFor(second); !reflect.DeepEqual(grades, map[string]float64{"matematicas": 9, "historia": 6}) {
+		t.Errorf("La nota sin evaluaciones cuenta en la evaluación en la que se puso: %v", grades)
+	}
+	if grades, _ := luis.gradesFor(Period{Year: "2024-2025", Term: termFirst}); !reflect.DeepEqual(grades, map[string]float64{"matematicas": 5}) {
+		t.Errorf("Y en ninguna otra: %v", grades)
+	}
+}
+
+func TestPinUndatedGrades(t *testing.T) {
+	luis := Student{
+		Name:         "Luis",
+		Subjects:     map[string]float64{"matematicas": 7, "historia": 6, "ingles": 8},
+		GradePeriods: map[string]Period{"ingles": {Year: "2024-2025", Term: termThird}},
+		Assessments: map[string][]Assessment{
+			"matematicas": {
+				{Name: legacyAssessmentName, Weight: 1, Score: 5, MaxScore: 10},
+				{Name: "Examen 2", Weight: 1, Score: 9, MaxScore: 10, Year: "2024-2025", Term: termSecond},
+			},
+		},
+	}
+	// La nota de historia se puso en noviembre y la primera evaluación de
+	// matemáticas se registró en enero
+	set := luis.pinUndated(func(subject string, assessed bool) time.Time {
+		if assessed {
+			return time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC)
+		}
+		return time.Date(2024, time.November, 5, 0, 0, 0, 0, time.UTC)
+	})
+
+	if set["grade_periods.historia"] != (Period{Year: "2024-2025", Term: termFirst}) {
+		t.Errorf("La nota sin evaluaciones queda en la evaluación en la que se puso: %v", set)
+	}
+	pinned, _ := set["subjects.matematicas.assessments"].([]Assessment)
+	if len(pinned) != 2 || pinned[0].Term != termSecond || pinned[0].Year != "2024-2025" || pinned[1] != luis.Assessments["matematicas"][1] {
+		t.Errorf("La nota anterior queda en la evaluación de la primera evaluación: %v", pinned)
+	}
+	if _, ok := set["grade_periods.ingles"]; ok || len(set) != 2 {
+		t.Errorf("Las notas que ya tienen evaluación no cambian: %v", set)
+	}
+	if luis.Assessments["matematicas"][0].Term != "" {
+		t.Error("pinUndated no modifica el estudiante")
+	}
+}
+
+func TestCloseTermRequiresAdmin(t *testing.T) {
+	s := &Server{}
+	req := &ToolRequest{Session: sessionFor("profesora.garcia", authMTLS), Role: testRoles["profesora.garcia"]}
+	if _, err := s.closeTerm(context.Background(), req, Period{Year: "2024-2025", Term: termFirst}); !errors.Is(err, errForbidden) {
+		t.Errorf("Solo un administrador cierra evaluaciones: %v", err)
+	}
+
+	// Sin las tres evaluaciones cerradas no se puede cerrar la final
+	req.Role = adminRole
+	if _, err := s.closeTerm(context.Background(), req, Period{Year: "2024-2025", Term: termFinal}); err == nil || !strings.Contains(err.Error(), "1ª, 2ª, 3ª") {
+		t.Errorf("Se esperaba el error de evaluaciones abiertas: %v", err)
+	}
+}
+
+func TestTeacherSeesOnlyOwnTermGrades(t *testing.T) {
+	ana := Student{
+		Name:     "Ana",
+		Subjects: map[string]float64{"matematicas": 8, "historia": 6},
+		TermGrades: map[string]map[string]map[string]float64{
+			"2024-2025": {termFirst: {"matematicas": 8, "historia": 6}},
+		},
+	}
+	got, _ := testRoles["profesora.garcia"].redact(ana)
+	if !reflect.DeepEqual(got.TermGrades["2024-2025"][termFirst], map[string]float64{"matematicas": 8}) {
+		t.Errorf("El profesor solo ve las notas cerradas de sus asignaturas: %v", got.TermGrades)
+	}
+}
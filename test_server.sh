@@ -9,11 +9,22 @@ echo "🧪 Script de pruebas para el servidor MCP MongoDB"
 echo "📡 Servidor: ${SERVER_HOST}:${SERVER_PORT}"
 echo ""
 
-# Función para enviar mensajes MCP usando netcat
+# Cada llamada abre una conexión nueva y el servidor rechaza peticiones antes
+# de initialize, así que cada mensaje va precedido del saludo MCP
+# Si el servidor exige token (TCP_AUTH_TOKEN) se envía en initialize
+INIT_MESSAGE='{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"test_server.sh","version":"1.0.0"},"authToken":"'"${TCP_AUTH_TOKEN}"'"}}'
+INITIALIZED_MESSAGE='{"jsonrpc":"2.0","method":"notifications/initialized"}'
+
+# Función para enviar mensajes MCP usando netcat (el servidor lee un mensaje por línea)
 send_mcp_message() {
-    local message="$1"
+    local message
+    message=$(echo "$1" | tr -d '\n')
     echo "📤 Enviando: $message"
-    echo "$message" | nc $SERVER_HOST $SERVER_PORT
+    if echo "$message" | grep -q '"method": *"initialize"'; then
+        echo "$message" | nc $SERVER_HOST $SERVER_PORT
+    else
+        printf '%s\n%s\n%s\n' "$INIT_MESSAGE" "$INITIALIZED_MESSAGE" "$message" | nc $SERVER_HOST $SERVER_PORT
+    fi
     echo ""
 }
 
@@ -48,7 +59,7 @@ send_mcp_message '{
     "jsonrpc": "2.0",
     "id": 1,
     "method": "initialize",
-    "params": {}
+    "params": {"authToken": "'"${TCP_AUTH_TOKEN}"'"}
 }'
 
 # 2. Listar herramientas disponibles
@@ -150,4 +161,5 @@ send_mcp_message '{
 echo "🎉 Pruebas completadas!"
 echo ""
 echo "💡 Puedes ejecutar consultas personalizadas usando:"
+echo "   (tras initialize y notifications/initialized en la misma conexión)"
 echo "   echo '{\"jsonrpc\":\"2.0\",\"id\":1,\"method\":\"tools/call\",\"params\":{\"name\":\"HERRAMIENTA\",\"arguments\":{...}}}' | nc $SERVER_HOST $SERVER_PORT"
\ No newline at end of file
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log/slog"
+)
+
+// ToolAnnotations describe el comportamiento de una herramienta para que el
+// cliente decida, por ejemplo, si pedir confirmación antes de llamarla
+type ToolAnnotations struct {
+	Title           string `json:"title,omitempty"`
+	ReadOnlyHint    bool   `json:"readOnlyHint"`
+	DestructiveHint bool   `json:"destructiveHint"`
+	IdempotentHint  bool   `json:"idempotentHint"`
+	OpenWorldHint   bool   `json:"openWorldHint"`
+}
+
+// readOnlyTool anota una herramienta de consulta
+func readOnlyTool(title string) *ToolAnnotations {
+	return &ToolAnnotations{Title: title, ReadOnlyHint: true, IdempotentHint: true}
+}
+
+// writeTool anota una herramienta de escritura; ninguna sale del servidor
+func writeTool(title string, destructive, idempotent bool) *ToolAnnotations {
+	return &ToolAnnotations{Title: title, DestructiveHint: destructive, IdempotentHint: idempotent}
+}
+
+// isReadOnly indica si la herramienta no modifica datos. Las herramientas sin
+// anotaciones se consideran de escritura.
+func (t Tool) isReadOnly() bool {
+	return t.Annotations != nil && t.Annotations.ReadOnlyHint
+}
+
+// forSession adapta la definición a la versión del protocolo de la sesión: las
+// anotaciones aparecieron en 2025-03-26 y el título en 2025-06-18
+func (t Tool) forSession(sess *Session) Tool {
+	if !sess.supportsToolAnnotations() {
+		t.Annotations = nil
+	}
+	if !sess.atLeast(protocolVersion20250618) {
+		t.Title = ""
+	}
+	return t
+}
+
+// listTools devuelve las herramientas habilitadas tal como las ve la sesión
+func (s *Server) listTools(sess *Session) []Tool {
+	var tools []Tool
+	for _, tool := range s.getTools() {
+		if s.toolEnabled(sess, tool) {
+			tools = append(tools, tool.forSession(sess))
+		}
+	}
+	return tools
+}
+
+// findTool busca una herramienta por nombre e indica si la sesión puede usarla
+func (s *Server) findTool(sess *Session, name string) (Tool, bool) {
+	for _, tool := range s.getTools() {
+		if tool.Name == name {
+			return tool, s.toolEnabled(sess, tool)
+		}
+	}
+	return Tool{}, false
+}
+
+// toolEnabled aplica el modo solo lectura del servidor, los scopes y el rol de
+// la identidad y la política del transporte de la sesión
+func (s *Server) toolEnabled(sess *Session, tool Tool) bool {
+	if s.isReadOnly() && !tool.isReadOnly() {
+		return false
+	}
+	if identity, ok := sess.Identity(); ok && !identity.allows(tool) {
+		return false
+	}
+	if !s.roleFor(sess).canUse(tool) {
+		return false
+	}
+	return s.toolPolicy(sess).permits(tool)
+}
+
+func (s *Server) isReadOnly() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readOnly
+}
+
+// setReadOnly activa o desactiva en caliente las herramientas de escritura y
+// avisa a los clientes conectados de que la lista ha cambiado
+func (s *Server) setReadOnly(readOnly bool) {
+	s.mu.Lock()
+	changed := s.readOnly != readOnly
+	s.readOnly = readOnly
+	s.mu.Unlock()
+
+	if changed {
+		slog.Info("Modo solo lectura cambiado", "solo_lectura", readOnly)
+		s.notifyToolListChanged()
+	}
+}
+
+// notifyToolListChanged envía notifications/tools/list_changed a todas las
+// sesiones ya inicializadas
+func (s *Server) notifyToolListChanged() {
+	for _, sess := range s.activeSessions() {
+		if sess.IsInitialized() {
+			sess.notify("notifications/tools/list_changed", nil)
+		}
+	}
+}
+
+func (s *Server) addSession(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = map[*Session]struct{}{}
+	}
+	s.sessions[sess] = struct{}{}
+}
+
+func (s *Server) removeSession(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sess)
+}
+
+func (s *Server) activeSessions() []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEveryToolHasAnnotations(t *testing.T) {
+	s := &Server{}
+	for _, tool := range s.getTools() {
+		if tool.Annotations == nil || tool.Title == "" {
+			t.Errorf("%s no tiene título o anotaciones", tool.Name)
+		}
+	}
+
+	if tool, _ := s.toolRegistry().lookup("add_student"); tool.isReadOnly() {
+		t.Error("add_student modifica datos")
+	}
+	if tool, _ := s.toolRegistry().lookup("list_students"); !tool.isReadOnly() {
+		t.Error("list_students es de solo lectura")
+	}
+}
+
+func TestAnnotationsGatedByProtocolVersion(t *testing.T) {
+	s := &Server{}
+	cases := []struct {
+		version     string
+		annotations bool
+		title       bool
+	}{
+		{"2024-11-05", false, false},
+		{"2025-03-26", true, false},
+		{"2025-06-18", true, true},
+	}
+
+	for _, c := range cases {
+		sess := newSession(transportStdio, "", io.Discard)
+		sess.initialize(InitializeParams{ProtocolVersion: c.version})
+
+		tool := s.listTools(sess)[0]
+		if (tool.Annotations != nil) != c.annotations {
+			t.Errorf("%s: anotaciones presentes = %v", c.version, tool.Annotations != nil)
+		}
+		if (tool.Title != "") != c.title {
+			t.Errorf("%s: título presente = %v", c.version, tool.Title != "")
+		}
+	}
+}
+
+func TestReadOnlyModeHidesWriteTools(t *testing.T) {
+	s := &Server{}
+	var out bytes.Buffer
+	sess := newSession(transportStdio, "", &out)
+	s.addSession(sess)
+
+	// Las sesiones sin inicializar no reciben la notificación
+	s.setReadOnly(true)
+	if out.Len() != 0 {
+		t.Errorf("No se notifica a sesiones sin inicializar: %s", out.String())
+	}
+	s.setReadOnly(false)
+
+	processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+	s.processMessage(context.Background(), sess, []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`))
+	out.Reset()
+
+	s.setReadOnly(true)
+	if !strings.Contains(out.String(), "notifications/tools/list_changed") {
+		t.Errorf("Se esperaba notifications/tools/list_changed: %s", out.String())
+	}
+
+	for _, tool := range s.listTools(sess) {
+		if tool.Name == "add_student" {
+			t.Error("add_student no debería listarse en modo solo lectura")
+		}
+	}
+
+	call, _ := json.Marshal(MCPMessage{
+		JsonRPC: "2.0",
+		ID:      2,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "add_student", "arguments": map[string]interface{}{"name": "X"}},
+	})
+	var response MCPMessage
+	json.Unmarshal(s.processMessage(context.Background(), sess, call), &response)
+	if response.Error == nil || response.Error.Code != -32602 {
+		t.Errorf("add_student debería rechazarse en modo solo lectura: %+v", response.Error)
+	}
+
+	// Repetir el mismo modo no vuelve a notificar
+	out.Reset()
+	s.setReadOnly(true)
+	if out.Len() != 0 {
+		t.Errorf("Sin cambios no debería notificarse: %s", out.String())
+	}
+}
+
+func TestToolPolicyPerTransport(t *testing.T) {
+	s := &Server{}
+	s.setToolPolicy(transportTCP, ToolPolicy{ReadOnly: true, Deny: []string{"generate_report_card"}})
+	s.setToolPolicy(transportStdio, ToolPolicy{Allow: []string{"list_students", "add_student"}})
+
+	cases := []struct {
+		transport string
+		tool      string
+		enabled   bool
+	}{
+		{transportTCP, "list_students", true},
+		{transportTCP, "add_student", false},
+		{transportTCP, "generate_report_card", false},
+		{transportStdio, "add_student", true},
+		{transportStdio, "get_student_by_name", false},
+	}
+	for _, c := range cases {
+		sess := newSession(c.transport, "", io.Discard)
+		if _, enabled := s.findTool(sess, c.tool); enabled != c.enabled {
+			t.Errorf("%s/%s: habilitada = %v", c.transport, c.tool, enabled)
+		}
+
+		listed := false
+		for _, tool := range s.listTools(sess) {
+			listed = listed || tool.Name == c.tool
+		}
+		if listed != c.enabled {
+			t.Errorf("%s/%s: listada = %v", c.transport, c.tool, listed)
+		}
+	}
+
+	// La llamada a una herramienta denegada se rechaza antes de ejecutarse
+	sess := newSession(transportTCP, "127.0.0.1:5000", io.Discard)
+	processTestMessage(t, s, sess, MCPMessage{JsonRPC: "2.0", ID: 1, Method: "initialize"})
+	response := processTestMessage(t, s, sess, MCPMessage{
+		JsonRPC: "2.0",
+		ID:      2,
+		Method:  "tools/call",
+		Params:  map[string]interface{}{"name": "add_student", "arguments": map[string]interface{}{"name": "X"}},
+	})
+	if response.Error == nil || response.Error.Code != -32602 {
+		t.Errorf("add_student debería rechazarse por TCP: %+v", response.Error)
+	}
+}
+
+func TestLoadToolPolicy(t *testing.T) {
+	t.Setenv("READ_ONLY", "true")
+	t.Setenv("STDIO_READ_ONLY", "false")
+	t.Setenv("TCP_TOOLS_DENY", " add_student, generate_report_card ,")
+
+	if policy := loadToolPolicy(transportStdio); policy.ReadOnly || len(policy.Deny) != 0 {
+		t.Errorf("stdio debería tener acceso completo: %+v", policy)
+	}
+
+	policy := loadToolPolicy(transportTCP)
+	if !policy.ReadOnly {
+		t.Error("TCP debería heredar READ_ONLY")
+	}
+	if !reflect.DeepEqual(policy.Deny, []string{"add_student", "generate_report_card"}) {
+		t.Errorf("Lista de denegadas incorrecta: %v", policy.Deny)
+	}
+}